@@ -1,10 +1,16 @@
 package compiler
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
+	"html"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/codingersid/legit-template/lexer"
 	"github.com/codingersid/legit-template/parser"
 )
 
@@ -12,29 +18,133 @@ import (
 type Compiler struct {
 	// Template inheritance
 	extends     string
+	extendsData string
 	sections    map[string]string
 	parentCalls map[string]bool
 
-	// Stacks
-	pushes   map[string][]string
-	prepends map[string][]string
+	// Render @section blocks in place, for rendering a child template
+	// without its layout
+	inlineSections bool
+
+	// Top-level @push and @prepend statements
+	pushes []string
 
 	// State
 	loopDepth int
 	onceKeys  map[string]bool
+	scopes    []map[string]bool // Template variables declared by enclosing blocks
+
+	// Custom directives
+	directives        map[string]DirectiveFunc
+	blockDirectives   map[string]BlockDirectiveFunc
+	runtimeDirectives map[string]bool
+
+	// Form helpers
+	csrfField string
+
+	// Directory of component templates, relative to the views path
+	componentsPath string
+
+	// Collapse whitespace-only text flagged by the lexer
+	collapseWhitespace bool
+
+	// Render {{-- --}} comments as HTML comments
+	keepComments bool
+
+	// Route {!! !!} output through sanitizeRaw
+	rawGuard bool
+
+	// Component slot bodies, emitted as {{ define }} blocks
+	slotNames  []string
+	slotBodies map[string]string
+
+	// Source marks for mapping errors back to the template source
+	sourceMarks bool
+	source      string
 }
 
+// DirectiveFunc expands a custom directive at compile time.
+// It receives the directive arguments translated to Go template syntax
+// and returns the Go template source that replaces the directive.
+type DirectiveFunc func(args string) string
+
+// BlockDirectiveFunc expands a custom block directive at compile time.
+// It additionally receives the compiled content between the directive
+// and its @end<name> terminator.
+type BlockDirectiveFunc func(args string, inner string) string
+
 // New creates a new Compiler
 func New() *Compiler {
 	return &Compiler{
-		sections:    make(map[string]string),
-		parentCalls: make(map[string]bool),
-		pushes:      make(map[string][]string),
-		prepends:    make(map[string][]string),
-		onceKeys:    make(map[string]bool),
+		sections:          make(map[string]string),
+		parentCalls:       make(map[string]bool),
+		onceKeys:          make(map[string]bool),
+		directives:        make(map[string]DirectiveFunc),
+		blockDirectives:   make(map[string]BlockDirectiveFunc),
+		runtimeDirectives: make(map[string]bool),
+		csrfField:         "_token",
+		componentsPath:    "components",
+		slotBodies:        make(map[string]string),
 	}
 }
 
+// SetCSRFField sets the input name used by @csrf
+func (c *Compiler) SetCSRFField(name string) {
+	c.csrfField = name
+}
+
+// SetComponentsPath sets the directory @component templates are resolved
+// in, relative to the views path (default: components). An empty path
+// resolves components from the views path itself.
+func (c *Compiler) SetComponentsPath(dir string) {
+	c.componentsPath = strings.Trim(strings.ReplaceAll(dir, "\\", "/"), "/")
+}
+
+// SetCollapseWhitespace collapses whitespace-only text to a single newline,
+// or a single space when it has no line break. It only affects text the
+// lexer flagged with SetMarkWhitespace. Block sections also lose the
+// newline at the start and end of their body.
+func (c *Compiler) SetCollapseWhitespace(collapse bool) {
+	c.collapseWhitespace = collapse
+}
+
+// SetKeepComments renders {{-- --}} comments as HTML comments instead of
+// dropping them. {{--! --}} comments are kept either way.
+func (c *Compiler) SetKeepComments(keep bool) {
+	c.keepComments = keep
+}
+
+// SetRawGuard routes raw {!! !!} output through the sanitizeRaw function
+// instead of raw
+func (c *Compiler) SetRawGuard(guard bool) {
+	c.rawGuard = guard
+}
+
+// SetInlineSections renders @section blocks where they are defined, with
+// any @parent dropped, so a child template can be rendered without its
+// layout. Inline sections such as @section('title', 'Home') produce no
+// output.
+func (c *Compiler) SetInlineSections(inline bool) {
+	c.inlineSections = inline
+}
+
+// RegisterDirective registers a custom directive expanded at compile time
+func (c *Compiler) RegisterDirective(name string, fn DirectiveFunc) {
+	c.directives[name] = fn
+}
+
+// RegisterRuntimeDirective registers a custom directive handled at render
+// time. It compiles to a call of the customDirective template function with
+// the directive name, its arguments as written and the render data.
+func (c *Compiler) RegisterRuntimeDirective(name string) {
+	c.runtimeDirectives[name] = true
+}
+
+// RegisterBlockDirective registers a custom block directive expanded at compile time
+func (c *Compiler) RegisterBlockDirective(name string, fn BlockDirectiveFunc) {
+	c.blockDirectives[name] = fn
+}
+
 // Compile compiles AST to Go template string
 func (c *Compiler) Compile(root *parser.RootNode) (string, error) {
 	var result strings.Builder
@@ -45,50 +155,93 @@ func (c *Compiler) Compile(root *parser.RootNode) (string, error) {
 			return "", err
 		}
 		result.WriteString(compiled)
+
+		switch node.(type) {
+		case *parser.PushNode, *parser.PrependNode:
+			c.pushes = append(c.pushes, compiled)
+		}
 	}
 
+	result.WriteString(c.GetDefinitions())
+
 	return result.String(), nil
 }
 
+// GetDefinitions returns the {{ define }} blocks holding component slot
+// bodies. They must be part of every template set that renders the output.
+func (c *Compiler) GetDefinitions() string {
+	var result strings.Builder
+	for _, name := range c.slotNames {
+		result.WriteString(fmt.Sprintf("{{ define %q }}%s{{ end }}", name, c.slotBodies[name]))
+	}
+	return result.String()
+}
+
+// GetPushes returns the top-level @push and @prepend statements. A child
+// template must keep them, since its output outside sections is dropped.
+func (c *Compiler) GetPushes() string {
+	return strings.Join(c.pushes, "")
+}
+
 // GetExtends returns the parent template name if @extends was used
 func (c *Compiler) GetExtends() string {
 	return c.extends
 }
 
+// GetExtendsData returns the statement merging the data passed with
+// @extends into the render data, to run before the parent's content, or ""
+func (c *Compiler) GetExtendsData() string {
+	if c.extendsData == "" {
+		return ""
+	}
+	return fmt.Sprintf("{{ extendData $ %s }}", c.extendsData)
+}
+
 // GetSections returns all defined sections
 func (c *Compiler) GetSections() map[string]string {
 	return c.sections
 }
 
-// GetStacks returns push content for a stack
-func (c *Compiler) GetPushes(name string) []string {
-	return c.pushes[name]
-}
-
-// GetPrepends returns prepend content for a stack
-func (c *Compiler) GetPrepends(name string) []string {
-	return c.prepends[name]
-}
-
 // HasParentCall checks if a section has @parent
 func (c *Compiler) HasParentCall(section string) bool {
 	return c.parentCalls[section]
 }
 
-// compileNode compiles a single node
+// compileNode compiles a single node, preceded by its source mark when
+// source marks are enabled. Text never fails to render and is not marked.
 func (c *Compiler) compileNode(node parser.Node) (string, error) {
+	compiled, err := c.compileNodeContent(node)
+	if err != nil || compiled == "" {
+		return compiled, err
+	}
+
+	switch node.(type) {
+	case *parser.TextNode, *parser.VerbatimNode, *parser.CommentNode:
+		return compiled, nil
+	}
+	return c.mark(node.Position()) + compiled, nil
+}
+
+// compileNodeContent compiles the output of a single node
+func (c *Compiler) compileNodeContent(node parser.Node) (string, error) {
 	switch n := node.(type) {
 	case *parser.TextNode:
-		return n.Content, nil
+		if c.collapseWhitespace && n.Whitespace {
+			if strings.Contains(n.Content, "\n") {
+				return "\n", nil
+			}
+			return " ", nil
+		}
+		return escapeActions(n.Content), nil
 
 	case *parser.EchoNode:
 		return c.compileEcho(n), nil
 
 	case *parser.CommentNode:
-		return "", nil // Comments are not rendered
+		return c.compileComment(n), nil
 
 	case *parser.DirectiveNode:
-		return c.compileDirective(n), nil
+		return c.compileDirective(n)
 
 	case *parser.IfNode:
 		return c.compileIf(n)
@@ -119,6 +272,9 @@ func (c *Compiler) compileNode(node parser.Node) (string, error) {
 
 	case *parser.ExtendsNode:
 		c.extends = n.Template
+		if n.Data != "" {
+			c.extendsData = c.transformExpression(n.Data)
+		}
 		return "", nil
 
 	case *parser.IncludeNode:
@@ -134,16 +290,22 @@ func (c *Compiler) compileNode(node parser.Node) (string, error) {
 		return c.compilePrepend(n)
 
 	case *parser.StackNode:
-		return c.compileStack(n), nil
+		return c.compileStack(n)
 
 	case *parser.ComponentNode:
 		return c.compileComponent(n)
 
+	case *parser.AwareNode:
+		return c.compileAware(n), nil
+
+	case *parser.PropsNode:
+		return c.compileProps(n), nil
+
 	case *parser.VerbatimNode:
-		return n.Content, nil
+		return escapeActions(n.Content), nil
 
 	case *parser.PhpNode:
-		return c.compilePhp(n), nil
+		return c.compilePhp(n)
 
 	case *parser.IssetNode:
 		return c.compileIsset(n)
@@ -157,6 +319,12 @@ func (c *Compiler) compileNode(node parser.Node) (string, error) {
 	case *parser.GuestNode:
 		return c.compileGuest(n)
 
+	case *parser.AllowNode:
+		return c.compileAllow(n)
+
+	case *parser.FeatureNode:
+		return c.compileFeature(n)
+
 	case *parser.EnvNode:
 		return c.compileEnv(n)
 
@@ -166,9 +334,15 @@ func (c *Compiler) compileNode(node parser.Node) (string, error) {
 	case *parser.ErrorNode:
 		return c.compileError(n)
 
+	case *parser.FlashNode:
+		return c.compileFlash(n)
+
 	case *parser.OnceNode:
 		return c.compileOnce(n)
 
+	case *parser.SpacelessNode:
+		return c.compileSpaceless(n)
+
 	case *parser.BreakNode:
 		return c.compileBreak(n), nil
 
@@ -178,6 +352,12 @@ func (c *Compiler) compileNode(node parser.Node) (string, error) {
 	case *parser.ParentNode:
 		return "{{__PARENT__}}", nil
 
+	case *parser.BlockNode:
+		return c.compileBlock(n)
+
+	case *parser.CacheNode:
+		return c.compileCache(n)
+
 	default:
 		return "", nil
 	}
@@ -196,55 +376,141 @@ func (c *Compiler) compileChildren(children []parser.Node) (string, error) {
 	return result.String(), nil
 }
 
-// compileEcho compiles {{ }} and {!! !!}
+// compileBranch compiles the children of a conditional branch. Variables
+// they declare with @php are scoped to the branch, as in the compiled
+// {{ if }}, so later assignments outside it declare them anew.
+func (c *Compiler) compileBranch(children []parser.Node) (string, error) {
+	c.pushScope()
+	defer c.popScope()
+	return c.compileChildren(children)
+}
+
+// compileComment drops a comment unless it is kept. Kept comments are
+// printed through raw, since html/template strips comments in template text.
+func (c *Compiler) compileComment(n *parser.CommentNode) string {
+	if !c.keepComments && !n.Keep {
+		return ""
+	}
+
+	// "--" may not appear inside an HTML comment
+	content := n.Content
+	for strings.Contains(content, "--") {
+		content = strings.ReplaceAll(content, "--", "- -")
+	}
+	return fmt.Sprintf("{{ raw %s }}", strconv.Quote("<!-- "+content+" -->"))
+}
+
+// compileEcho compiles {{ }} and {!! !!}. Raw echoes go through the raw
+// function, or sanitizeRaw when a raw guard is set, since html/template
+// would otherwise escape strings and slots.
 func (c *Compiler) compileEcho(n *parser.EchoNode) string {
 	expr := c.transformExpression(n.Expression)
 	if n.Escaped {
 		return fmt.Sprintf("{{ html %s }}", expr)
 	}
-	return fmt.Sprintf("{{ %s }}", expr)
+	if c.rawGuard {
+		return fmt.Sprintf("{{ sanitizeRaw %s }}", expr)
+	}
+	return fmt.Sprintf("{{ raw %s }}", expr)
 }
 
-// compileDirective compiles simple directives
-func (c *Compiler) compileDirective(n *parser.DirectiveNode) string {
+// spoofableMethods lists the HTTP verbs accepted by @method
+var spoofableMethods = map[string]bool{
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
+// compileDirective compiles simple directives. Registered custom
+// directives take precedence over built-ins.
+func (c *Compiler) compileDirective(n *parser.DirectiveNode) (string, error) {
+	if fn, ok := c.directives[n.Name]; ok {
+		return fn(c.transformExpression(n.Args)), nil
+	}
+	if c.runtimeDirectives[n.Name] {
+		return fmt.Sprintf("{{ customDirective %q %q %s }}", n.Name, strings.TrimSpace(n.Args), c.rootData()), nil
+	}
+
 	switch n.Name {
 	case "csrf":
-		return `<input type="hidden" name="_token" value="{{ .csrf_token }}">`
+		return fmt.Sprintf(`<input type="hidden" name="%s" value="{{ csrfToken $ }}">`, html.EscapeString(c.csrfField)), nil
 	case "method":
-		method := strings.Trim(n.Args, "'\"")
-		return fmt.Sprintf(`<input type="hidden" name="_method" value="%s">`, method)
+		return c.compileMethod(n)
+	case "status":
+		return fmt.Sprintf("{{ setStatus $ %s }}", c.transformExpression(n.Args)), nil
+	case "header":
+		return c.compileHeader(n)
 	case "json":
 		expr := c.transformExpression(n.Args)
-		return fmt.Sprintf("{{ json %s }}", expr)
+		return fmt.Sprintf("{{ json %s }}", expr), nil
+	case "dump":
+		return fmt.Sprintf("{{ dumpHTML %s }}", c.transformExpression(n.Args)), nil
 	case "class":
-		return c.compileClass(n.Args)
+		return c.compileClass(n.Args), nil
 	case "style":
-		return c.compileStyle(n.Args)
+		return c.compileStyle(n.Args), nil
 	case "checked":
 		expr := c.transformExpression(n.Args)
-		return fmt.Sprintf(`{{ if %s }}checked{{ end }}`, expr)
+		return fmt.Sprintf(`{{ if %s }}checked{{ end }}`, expr), nil
 	case "selected":
 		expr := c.transformExpression(n.Args)
-		return fmt.Sprintf(`{{ if %s }}selected{{ end }}`, expr)
+		return fmt.Sprintf(`{{ if %s }}selected{{ end }}`, expr), nil
 	case "disabled":
 		expr := c.transformExpression(n.Args)
-		return fmt.Sprintf(`{{ if %s }}disabled{{ end }}`, expr)
+		return fmt.Sprintf(`{{ if %s }}disabled{{ end }}`, expr), nil
 	case "readonly":
 		expr := c.transformExpression(n.Args)
-		return fmt.Sprintf(`{{ if %s }}readonly{{ end }}`, expr)
+		return fmt.Sprintf(`{{ if %s }}readonly{{ end }}`, expr), nil
 	case "required":
 		expr := c.transformExpression(n.Args)
-		return fmt.Sprintf(`{{ if %s }}required{{ end }}`, expr)
+		return fmt.Sprintf(`{{ if %s }}required{{ end }}`, expr), nil
 	case "old":
-		field := strings.Trim(n.Args, "'\"")
-		return fmt.Sprintf(`{{ index .old "%s" }}`, field)
+		return fmt.Sprintf("{{ %s }}", callExpr("old", n.Args)), nil
 	default:
-		// Custom directive - call as function
+		// Unknown directive - call as function
 		if n.Args != "" {
-			return fmt.Sprintf("{{ %s %s }}", n.Name, c.transformExpression(n.Args))
+			return fmt.Sprintf("{{ %s %s }}", n.Name, c.transformExpression(n.Args)), nil
+		}
+		return fmt.Sprintf("{{ %s }}", n.Name), nil
+	}
+}
+
+// compileMethod compiles @method, accepting PUT, PATCH and DELETE in any case
+func (c *Compiler) compileMethod(n *parser.DirectiveNode) (string, error) {
+	method := strings.ToUpper(strings.TrimSpace(strings.Trim(strings.TrimSpace(n.Args), "'\"")))
+	if !spoofableMethods[method] {
+		return "", &CompilerError{
+			Message:  fmt.Sprintf("invalid HTTP method %q in @method, expected PUT, PATCH or DELETE", n.Args),
+			Position: n.Pos,
 		}
-		return fmt.Sprintf("{{ %s }}", n.Name)
 	}
+	return fmt.Sprintf(`<input type="hidden" name="_method" value="%s">`, html.EscapeString(method)), nil
+}
+
+// compileHeader compiles @header('Name', 'value')
+func (c *Compiler) compileHeader(n *parser.DirectiveNode) (string, error) {
+	args := parser.SplitArgs(n.Args)
+	if len(args) != 2 {
+		return "", &CompilerError{
+			Message:  fmt.Sprintf("@header expects a name and a value, got %q", n.Args),
+			Position: n.Pos,
+		}
+	}
+	return fmt.Sprintf("{{ setHeader $ %s %s }}", c.transformExpression(args[0]), c.transformExpression(args[1])), nil
+}
+
+// compileBlock compiles a custom block directive
+func (c *Compiler) compileBlock(n *parser.BlockNode) (string, error) {
+	inner, err := c.compileChildren(n.Children)
+	if err != nil {
+		return "", err
+	}
+
+	if fn, ok := c.blockDirectives[n.Name]; ok {
+		return fn(c.transformExpression(n.Args), unmark(inner)), nil
+	}
+
+	return inner, nil
 }
 
 // compileClass compiles @class directive
@@ -264,20 +530,21 @@ func (c *Compiler) compileStyle(args string) string {
 func (c *Compiler) compileIf(n *parser.IfNode) (string, error) {
 	var result strings.Builder
 
-	condition := c.transformExpression(n.Condition)
+	condition := c.truthy(c.transformExpression(n.Condition))
 	result.WriteString(fmt.Sprintf("{{ if %s }}", condition))
 
-	children, err := c.compileChildren(n.Children)
+	children, err := c.compileBranch(n.Children)
 	if err != nil {
 		return "", err
 	}
 	result.WriteString(children)
 
 	for _, elseif := range n.ElseIfs {
-		elseifCond := c.transformExpression(elseif.Condition)
+		elseifCond := c.truthy(c.transformExpression(elseif.Condition))
+		result.WriteString(c.mark(elseif.Pos))
 		result.WriteString(fmt.Sprintf("{{ else if %s }}", elseifCond))
 
-		elseifChildren, err := c.compileChildren(elseif.Children)
+		elseifChildren, err := c.compileBranch(elseif.Children)
 		if err != nil {
 			return "", err
 		}
@@ -286,7 +553,7 @@ func (c *Compiler) compileIf(n *parser.IfNode) (string, error) {
 
 	if n.Else != nil {
 		result.WriteString("{{ else }}")
-		elseChildren, err := c.compileChildren(n.Else.Children)
+		elseChildren, err := c.compileBranch(n.Else.Children)
 		if err != nil {
 			return "", err
 		}
@@ -297,14 +564,53 @@ func (c *Compiler) compileIf(n *parser.IfNode) (string, error) {
 	return result.String(), nil
 }
 
+// booleanFuncs are functions whose result needs no truthiness coercion
+var booleanFuncs = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "lte": true, "gt": true, "gte": true,
+	"not": true, "toBool": true,
+	"isset": true, "empty": true, "exists": true, "hasKey": true, "hasError": true,
+	"contains": true, "hasPrefix": true, "hasSuffix": true,
+}
+
+// truthy wraps a condition in toBool so it follows Blade truthiness, where
+// e.g. the string "0" is false. Comparisons and other boolean results are
+// left as they are.
+func (c *Compiler) truthy(expr string) string {
+	inner := expr
+	grouped := strings.HasPrefix(inner, "(") && closingParen(inner, 0) == len(inner)-1
+	if grouped {
+		inner = strings.TrimSpace(inner[1 : len(inner)-1])
+	}
+	if inner == "true" || inner == "false" {
+		return expr
+	}
+	if fields := strings.Fields(inner); len(fields) > 0 && booleanFuncs[fields[0]] {
+		return expr
+	}
+	if grouped {
+		return "toBool " + expr
+	}
+	return fmt.Sprintf("toBool (%s)", expr)
+}
+
+// negate returns the pipeline negating a condition, with Blade truthiness
+// like truthy
+func (c *Compiler) negate(expr string) string {
+	cond := c.truthy(expr)
+	if cond != expr {
+		cond = "(" + cond + ")"
+	}
+	return "not " + cond
+}
+
 // compileUnless compiles @unless...@endunless
 func (c *Compiler) compileUnless(n *parser.UnlessNode) (string, error) {
 	var result strings.Builder
 
-	condition := c.transformExpression(n.Condition)
-	result.WriteString(fmt.Sprintf("{{ if not %s }}", condition))
+	condition := c.negate(c.transformExpression(n.Condition))
+	result.WriteString(fmt.Sprintf("{{ if %s }}", condition))
 
-	children, err := c.compileChildren(n.Children)
+	children, err := c.compileBranch(n.Children)
 	if err != nil {
 		return "", err
 	}
@@ -320,15 +626,23 @@ func (c *Compiler) compileSwitch(n *parser.SwitchNode) (string, error) {
 
 	expr := c.transformExpression(n.Expression)
 
+	if fallsThrough(n) {
+		return c.compileSwitchFallthrough(n, expr)
+	}
+
 	for i, caseNode := range n.Cases {
-		caseVal := c.transformExpression(caseNode.Value)
+		conds := c.caseConditions(caseNode, expr)
+		cond := conds[0]
+		if len(conds) > 1 {
+			cond = fmt.Sprintf("or (%s)", strings.Join(conds, ") ("))
+		}
 		if i == 0 {
-			result.WriteString(fmt.Sprintf("{{ if eq %s %s }}", expr, caseVal))
+			result.WriteString(fmt.Sprintf("{{ if %s }}", cond))
 		} else {
-			result.WriteString(fmt.Sprintf("{{ else if eq %s %s }}", expr, caseVal))
+			result.WriteString(fmt.Sprintf("{{ else if %s }}", cond))
 		}
 
-		caseChildren, err := c.compileChildren(caseNode.Children)
+		caseChildren, err := c.compileBranch(caseNode.Children)
 		if err != nil {
 			return "", err
 		}
@@ -337,7 +651,7 @@ func (c *Compiler) compileSwitch(n *parser.SwitchNode) (string, error) {
 
 	if n.Default != nil {
 		result.WriteString("{{ else }}")
-		defaultChildren, err := c.compileChildren(n.Default.Children)
+		defaultChildren, err := c.compileBranch(n.Default.Children)
 		if err != nil {
 			return "", err
 		}
@@ -351,6 +665,79 @@ func (c *Compiler) compileSwitch(n *parser.SwitchNode) (string, error) {
 	return result.String(), nil
 }
 
+// fallsThrough checks if a case without @break is followed by another
+// case or @default
+func fallsThrough(n *parser.SwitchNode) bool {
+	for i, caseNode := range n.Cases {
+		if !caseNode.Break && (i < len(n.Cases)-1 || n.Default != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSwitchFallthrough compiles a @switch where some cases fall through.
+// Go templates have no fallthrough, so each case body gets its own condition:
+// it runs when the value matches the case or any case falling into it.
+func (c *Compiler) compileSwitchFallthrough(n *parser.SwitchNode, expr string) (string, error) {
+	var result strings.Builder
+	var all, matched []string
+
+	for _, caseNode := range n.Cases {
+		conds := c.caseConditions(caseNode, expr)
+		all = append(all, conds...)
+		matched = append(matched, conds...)
+
+		caseChildren, err := c.compileBranch(caseNode.Children)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(fmt.Sprintf("{{ if %s }}%s{{ end }}", anyOf(matched), caseChildren))
+
+		if caseNode.Break {
+			matched = nil
+		}
+	}
+
+	if n.Default != nil {
+		// Runs when nothing matched or the last cases fall into it
+		cond := fmt.Sprintf("not %s", anyOf(all))
+		if len(matched) > 0 {
+			cond = fmt.Sprintf("or (not %s) %s", anyOf(all), anyOf(matched))
+		}
+
+		defaultChildren, err := c.compileBranch(n.Default.Children)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(fmt.Sprintf("{{ if %s }}%s{{ end }}", cond, defaultChildren))
+	}
+
+	return result.String(), nil
+}
+
+// caseConditions returns an eq condition for each value of a case
+func (c *Compiler) caseConditions(n *parser.CaseNode, expr string) []string {
+	values := n.Values
+	if len(values) == 0 {
+		values = []string{n.Value}
+	}
+
+	conds := make([]string, len(values))
+	for i, value := range values {
+		conds[i] = fmt.Sprintf("eq %s %s", expr, c.transformExpression(value))
+	}
+	return conds
+}
+
+// anyOf joins conditions with or
+func anyOf(conds []string) string {
+	if len(conds) == 1 {
+		return "(" + conds[0] + ")"
+	}
+	return fmt.Sprintf("(or (%s))", strings.Join(conds, ") ("))
+}
+
 // compileFor compiles @for...@endfor
 func (c *Compiler) compileFor(n *parser.ForNode) (string, error) {
 	c.loopDepth++
@@ -361,9 +748,12 @@ func (c *Compiler) compileFor(n *parser.ForNode) (string, error) {
 	// Convert PHP-style for to Go range
 	// @for($i = 0; $i < 10; $i++) -> {{ range $i := seq 0 10 }}
 	// This is a simplified conversion - real implementation needs expression parsing
-	result.WriteString(fmt.Sprintf("{{ $__loop%d := newLoop -1 %d }}", c.loopDepth, c.loopDepth))
+	result.WriteString(fmt.Sprintf("{{ $__loop%d := %s }}", c.loopDepth, c.newLoop("-1")))
 	result.WriteString(fmt.Sprintf("{{ range $__idx%d := seq %s }}", c.loopDepth, c.extractForRange(n)))
 
+	c.pushScope("loop")
+	defer c.popScope()
+
 	result.WriteString(fmt.Sprintf("{{ $loop := $__loop%d.Update $__idx%d }}", c.loopDepth, c.loopDepth))
 
 	children, err := c.compileChildren(n.Children)
@@ -414,21 +804,10 @@ func (c *Compiler) compileForeach(n *parser.ForeachNode) (string, error) {
 	key = strings.TrimPrefix(key, "$")
 	value = strings.TrimPrefix(value, "$")
 
-	// Initialize loop variable
-	result.WriteString(fmt.Sprintf("{{ $__loop%d := newLoop (len %s) %d }}", c.loopDepth, items, c.loopDepth))
+	c.pushScope(key, value, "loop")
+	defer c.popScope()
 
-	if key == "_" {
-		result.WriteString(fmt.Sprintf("{{ range $__idx%d, $%s := %s }}", c.loopDepth, value, items))
-	} else {
-		result.WriteString(fmt.Sprintf("{{ range $%s, $%s := %s }}", key, value, items))
-	}
-
-	// Update loop on each iteration
-	if key == "_" {
-		result.WriteString(fmt.Sprintf("{{ $loop := $__loop%d.Update $__idx%d }}", c.loopDepth, c.loopDepth))
-	} else {
-		result.WriteString(fmt.Sprintf("{{ $loop := $__loop%d.Update $%s }}", c.loopDepth, key))
-	}
+	result.WriteString(c.loopHeader(items, key, value))
 
 	children, err := c.compileChildren(n.Children)
 	if err != nil {
@@ -440,11 +819,35 @@ func (c *Compiler) compileForeach(n *parser.ForeachNode) (string, error) {
 	return result.String(), nil
 }
 
+// loopHeader opens the range of a @foreach or @forelse and binds $loop.
+// With a key, items go through iterate so maps are visited in key order and
+// structs field by field.
+func (c *Compiler) loopHeader(items, key, value string) string {
+	d := c.loopDepth
+
+	if key == "_" {
+		return fmt.Sprintf("{{ $__loop%d := %s }}{{ range $__idx%d, $%s := %s }}{{ $loop := $__loop%d.Update $__idx%d }}",
+			d, c.newLoop("(len "+items+")"), d, value, items, d, d)
+	}
+
+	return fmt.Sprintf("{{ $__items%d := iterate %s }}{{ $__loop%d := %s }}"+
+		"{{ range $__idx%d, $__pair%d := $__items%d }}{{ $%s := $__pair%d.Key }}{{ $%s := $__pair%d.Value }}"+
+		"{{ $loop := $__loop%d.Update $__idx%d }}",
+		d, items, d, c.newLoop(fmt.Sprintf("(len $__items%d)", d)), d, d, d, key, d, value, d, d, d)
+}
+
+// newLoop returns the pipeline creating the $loop of the innermost loop.
+// A nested loop is created from the enclosing $loop, so $loop->parent and
+// $loop->depth reflect the actual nesting.
+func (c *Compiler) newLoop(count string) string {
+	if c.loopDepth > 1 {
+		return "$loop.Child " + count
+	}
+	return fmt.Sprintf("newLoop %s %d", count, c.loopDepth)
+}
+
 // compileForelse compiles @forelse...@empty...@endforelse
 func (c *Compiler) compileForelse(n *parser.ForelseNode) (string, error) {
-	c.loopDepth++
-	defer func() { c.loopDepth-- }()
-
 	var result strings.Builder
 
 	items := c.transformExpression(n.Items)
@@ -459,30 +862,23 @@ func (c *Compiler) compileForelse(n *parser.ForelseNode) (string, error) {
 
 	// Check if items is not empty
 	result.WriteString(fmt.Sprintf("{{ if %s }}", items))
-	result.WriteString(fmt.Sprintf("{{ $__loop%d := newLoop (len %s) %d }}", c.loopDepth, items, c.loopDepth))
-
-	if key == "_" {
-		result.WriteString(fmt.Sprintf("{{ range $__idx%d, $%s := %s }}", c.loopDepth, value, items))
-	} else {
-		result.WriteString(fmt.Sprintf("{{ range $%s, $%s := %s }}", key, value, items))
-	}
-
-	if key == "_" {
-		result.WriteString(fmt.Sprintf("{{ $loop := $__loop%d.Update $__idx%d }}", c.loopDepth, c.loopDepth))
-	} else {
-		result.WriteString(fmt.Sprintf("{{ $loop := $__loop%d.Update $%s }}", c.loopDepth, key))
-	}
+	c.loopDepth++
+	c.pushScope(key, value, "loop")
+	result.WriteString(c.loopHeader(items, key, value))
 
 	children, err := c.compileChildren(n.Children)
+	c.popScope()
+	c.loopDepth--
 	if err != nil {
 		return "", err
 	}
 	result.WriteString(children)
 	result.WriteString("{{ end }}")
 
-	// Empty block
+	// Empty block, outside the range so @break and @continue apply to an
+	// enclosing loop
 	result.WriteString("{{ else }}")
-	empty, err := c.compileChildren(n.Empty)
+	empty, err := c.compileBranch(n.Empty)
 	if err != nil {
 		return "", err
 	}
@@ -502,11 +898,14 @@ func (c *Compiler) compileWhile(n *parser.WhileNode) (string, error) {
 	// Go templates don't have while loops, so we use a workaround with range and break
 	// This is a simplified implementation
 	condition := c.transformExpression(n.Condition)
-	result.WriteString(fmt.Sprintf("{{ $__loop%d := newLoop -1 %d }}", c.loopDepth, c.loopDepth))
+	result.WriteString(fmt.Sprintf("{{ $__loop%d := %s }}", c.loopDepth, c.newLoop("-1")))
 	result.WriteString(fmt.Sprintf("{{ range $__idx%d := until 1000 }}", c.loopDepth))
 	result.WriteString(fmt.Sprintf("{{ if not %s }}{{ break }}{{ end }}", condition))
 	result.WriteString(fmt.Sprintf("{{ $loop := $__loop%d.Update $__idx%d }}", c.loopDepth, c.loopDepth))
 
+	c.pushScope("loop")
+	defer c.popScope()
+
 	children, err := c.compileChildren(n.Children)
 	if err != nil {
 		return "", err
@@ -529,6 +928,18 @@ func (c *Compiler) compileSection(n *parser.SectionNode) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if c.collapseWhitespace {
+		children = trimSectionNewlines(children, n.Children)
+	}
+
+	// @append keeps the parent section before this content, @overwrite
+	// replaces it even where @parent is used
+	switch n.Merge {
+	case "append":
+		children = "{{__PARENT__}}" + children
+	case "overwrite":
+		children = strings.ReplaceAll(children, "{{__PARENT__}}", "")
+	}
 
 	// Check for @parent
 	if strings.Contains(children, "{{__PARENT__}}") {
@@ -537,6 +948,10 @@ func (c *Compiler) compileSection(n *parser.SectionNode) (string, error) {
 
 	c.sections[n.Name] = children
 
+	if c.inlineSections {
+		return strings.ReplaceAll(children, "{{__PARENT__}}", ""), nil
+	}
+
 	if n.Show {
 		// @show outputs immediately
 		return fmt.Sprintf("{{ block \"%s\" . }}%s{{ end }}", n.Name, children), nil
@@ -545,6 +960,39 @@ func (c *Compiler) compileSection(n *parser.SectionNode) (string, error) {
 	return "", nil
 }
 
+// trimSectionNewlines removes the newline after @section and the one
+// before its end directive, left by putting them on their own lines.
+// Only text at the edges of the body is trimmed.
+func trimSectionNewlines(body string, children []parser.Node) string {
+	if len(children) == 0 {
+		return body
+	}
+	if _, ok := children[0].(*parser.TextNode); ok {
+		if strings.HasPrefix(body, "\r\n") {
+			body = body[2:]
+		} else {
+			body = strings.TrimPrefix(body, "\n")
+		}
+	}
+	if _, ok := children[len(children)-1].(*parser.TextNode); ok {
+		trimmed := strings.TrimRight(body, " \t")
+		if strings.HasSuffix(trimmed, "\n") {
+			body = strings.TrimSuffix(strings.TrimSuffix(trimmed, "\n"), "\r")
+		}
+	}
+	return body
+}
+
+// escapeActions makes literal {{ in template text, such as the contents of
+// @verbatim or {{ }} left alone by custom echo delimiters, print as text
+// instead of opening a Go template action
+func escapeActions(text string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	return strings.ReplaceAll(text, "{{", "{{`{{`}}")
+}
+
 // compileYield compiles @yield
 func (c *Compiler) compileYield(n *parser.YieldNode) string {
 	if n.Default != "" {
@@ -555,31 +1003,26 @@ func (c *Compiler) compileYield(n *parser.YieldNode) string {
 
 // compileInclude compiles @include variants
 func (c *Compiler) compileInclude(n *parser.IncludeNode) string {
+	// The partial sees the template data and the variables in scope. Data
+	// may be a PHP array literal like ['key' => $value]
+	data := c.scopeData()
+	if n.Data != "" {
+		data = fmt.Sprintf("(merge %s %s)", data, c.transformExpression(n.Data))
+	}
+
 	switch n.Variant {
 	case "include":
-		if n.Data != "" {
-			return fmt.Sprintf("{{ template \"%s\" (merge . %s) }}", n.Template, n.Data)
-		}
-		return fmt.Sprintf("{{ template \"%s\" . }}", n.Template)
+		return fmt.Sprintf("{{ template \"%s\" %s }}", n.Template, data)
 	case "includeIf":
-		if n.Data != "" {
-			return fmt.Sprintf("{{ if templateExists \"%s\" }}{{ template \"%s\" (merge . %s) }}{{ end }}", n.Template, n.Template, n.Data)
-		}
-		return fmt.Sprintf("{{ if templateExists \"%s\" }}{{ template \"%s\" . }}{{ end }}", n.Template, n.Template)
+		return fmt.Sprintf("{{ if templateExists \"%s\" }}{{ template \"%s\" %s }}{{ end }}", n.Template, n.Template, data)
 	case "includeWhen":
-		cond := c.transformExpression(n.Condition)
-		if n.Data != "" {
-			return fmt.Sprintf("{{ if %s }}{{ template \"%s\" (merge . %s) }}{{ end }}", cond, n.Template, n.Data)
-		}
-		return fmt.Sprintf("{{ if %s }}{{ template \"%s\" . }}{{ end }}", cond, n.Template)
+		cond := c.truthy(c.transformExpression(n.Condition))
+		return fmt.Sprintf("{{ if %s }}{{ template \"%s\" %s }}{{ end }}", cond, n.Template, data)
 	case "includeUnless":
-		cond := c.transformExpression(n.Condition)
-		if n.Data != "" {
-			return fmt.Sprintf("{{ if not %s }}{{ template \"%s\" (merge . %s) }}{{ end }}", cond, n.Template, n.Data)
-		}
-		return fmt.Sprintf("{{ if not %s }}{{ template \"%s\" . }}{{ end }}", cond, n.Template)
+		cond := c.negate(c.transformExpression(n.Condition))
+		return fmt.Sprintf("{{ if %s }}{{ template \"%s\" %s }}{{ end }}", cond, n.Template, data)
 	case "includeFirst":
-		return fmt.Sprintf("{{ includeFirst %s . }}", n.Template)
+		return fmt.Sprintf("{{ includeFirst %s %s }}", c.transformExpression(n.Template), data)
 	}
 	return ""
 }
@@ -593,78 +1036,300 @@ func (c *Compiler) compileEach(n *parser.EachNode) string {
 	return fmt.Sprintf("{{ each \"%s\" %s \"%s\" \"\" }}", n.Template, items, n.ItemVar)
 }
 
-// compilePush compiles @push...@endpush
+// compilePush compiles @push...@endpush, @pushOnce and @pushIf. The
+// content is rendered in place and added to the stack at runtime, so a
+// push inside a condition or loop only counts when it runs.
 func (c *Compiler) compilePush(n *parser.PushNode) (string, error) {
-	children, err := c.compileChildren(n.Children)
+	if n.Condition != "" && n.Stack == "" {
+		return "", &CompilerError{
+			Message:  fmt.Sprintf("@pushIf expects a condition and a stack name, got %q", n.Condition),
+			Position: n.Pos,
+		}
+	}
+
+	content, err := c.compileSlot(n.Children)
 	if err != nil {
 		return "", err
 	}
 
 	if n.Once {
-		key := fmt.Sprintf("push_%s_%s", n.Stack, children)
+		key := fmt.Sprintf("push_%s_%s", n.Stack, content)
 		if c.onceKeys[key] {
 			return "", nil
 		}
 		c.onceKeys[key] = true
 	}
 
-	c.pushes[n.Stack] = append(c.pushes[n.Stack], children)
-	return "", nil
+	push := fmt.Sprintf("{{ pushStack $ %s %s }}", templateString(n.Stack), content)
+	if n.Condition != "" {
+		condition := c.truthy(c.transformExpression(n.Condition))
+		return fmt.Sprintf("{{ if %s }}%s{{ end }}", condition, push), nil
+	}
+	return push, nil
 }
 
 // compilePrepend compiles @prepend...@endprepend
 func (c *Compiler) compilePrepend(n *parser.PrependNode) (string, error) {
-	children, err := c.compileChildren(n.Children)
+	content, err := c.compileSlot(n.Children)
 	if err != nil {
 		return "", err
 	}
 
-	c.prepends[n.Stack] = append([]string{children}, c.prepends[n.Stack]...)
-	return "", nil
+	return fmt.Sprintf("{{ prependStack $ %s %s }}", templateString(n.Stack), content), nil
 }
 
-// compileStack compiles @stack
-func (c *Compiler) compileStack(n *parser.StackNode) string {
-	return fmt.Sprintf("{{ stack \"%s\" }}", n.Name)
+// compileStack compiles @stack and @stack...@endstack. The default
+// content is rendered in place and dropped if anything was pushed.
+func (c *Compiler) compileStack(n *parser.StackNode) (string, error) {
+	if n.Default == nil {
+		return fmt.Sprintf("{{ stack \"%s\" }}", n.Name), nil
+	}
+
+	content, err := c.compileSlot(n.Default)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("{{ stack \"%s\" %s }}", n.Name, content), nil
 }
 
-// compileComponent compiles @component...@endcomponent
+// compileComponent compiles @component...@endcomponent. Slot bodies are
+// compiled into separate {{ define }} blocks and rendered at runtime in the
+// caller's context, so their output is passed to the component as HTML.
 func (c *Compiler) compileComponent(n *parser.ComponentNode) (string, error) {
 	var result strings.Builder
 
-	// Compile default slot (children)
-	defaultSlot, err := c.compileChildren(n.Children)
+	// An explicit @slot('default') replaces the component body, which
+	// may then only contain whitespace
+	children := n.Children
+	if slot, ok := n.Slots["default"]; ok {
+		body, err := c.compileChildren(n.Children)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(body) != "" {
+			return "", &CompilerError{
+				Message:  fmt.Sprintf("component %q has both body content and @slot('default')", n.Name),
+				Position: slot.Pos,
+			}
+		}
+		children = slot.Children
+	}
+
+	defaultSlot, err := c.compileSlot(children)
 	if err != nil {
 		return "", err
 	}
 
-	// Build slots map
-	result.WriteString(fmt.Sprintf("{{ $__slots := dict \"default\" `%s`", escapeBackticks(defaultSlot)))
+	// Build slots map. The default slot is exposed to the component as $slot.
+	result.WriteString(fmt.Sprintf("{{ $__slots := dict \"default\" %s", defaultSlot))
+
+	names := make([]string, 0, len(n.Slots))
+	for name := range n.Slots {
+		if name != "default" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
 
-	for name, slot := range n.Slots {
-		slotContent, err := c.compileChildren(slot.Children)
+	for _, name := range names {
+		slot := n.Slots[name]
+		slotContent, err := c.compileSlot(slot.Children)
 		if err != nil {
 			return "", err
 		}
-		result.WriteString(fmt.Sprintf(" \"%s\" `%s`", name, escapeBackticks(slotContent)))
+		attrs := "(dict)"
+		if slot.Attributes != "" {
+			attrs = c.transformExpression(slot.Attributes)
+		}
+		result.WriteString(fmt.Sprintf(" %s (newSlot %s %s)", templateString(name), slotContent, attrs))
 	}
 	result.WriteString(" }}")
 
-	// Render component
+	// Render component with its props pushed onto the component data stack
+	props := "(dict)"
 	if n.Data != "" {
-		result.WriteString(fmt.Sprintf("{{ template \"components/%s\" (merge . (dict \"slot\" (index $__slots \"default\") \"slots\" $__slots) %s) }}", n.Name, n.Data))
-	} else {
-		result.WriteString(fmt.Sprintf("{{ template \"components/%s\" (merge . (dict \"slot\" (index $__slots \"default\") \"slots\" $__slots)) }}", n.Name))
+		props = c.transformExpression(n.Data)
 	}
+	result.WriteString(fmt.Sprintf("{{ template \"%s\" (componentData %s $__slots %s) }}", c.componentTemplate(n.Name), c.rootData(), props))
 
 	return result.String(), nil
 }
 
-// compilePhp compiles @php...@endphp
-func (c *Compiler) compilePhp(n *parser.PhpNode) string {
-	// Map PHP-like code to Go template actions
-	// This is a simplified implementation
-	return fmt.Sprintf("{{ /* php: %s */ }}", n.Code)
+// componentTemplate returns the template name of a component under the
+// components path. A namespaced component ("ns::name") is resolved under
+// the components path of its namespace.
+func (c *Compiler) componentTemplate(name string) string {
+	namespace := ""
+	if idx := strings.Index(name, "::"); idx != -1 {
+		namespace, name = name[:idx+2], name[idx+2:]
+	}
+	if c.componentsPath == "" {
+		return namespace + name
+	}
+	return namespace + c.componentsPath + "/" + name
+}
+
+// compileSlot compiles slot content into a {{ define }} block and returns
+// the pipeline rendering it. Template variables in scope at the call site
+// (e.g. loop variables) are passed along as data, since defined templates
+// cannot see the caller's variables.
+func (c *Compiler) compileSlot(children []parser.Node) (string, error) {
+	name, data, err := c.defineSlot(children)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return `""`, nil
+	}
+	return fmt.Sprintf("(renderSlot %q %s)", name, data), nil
+}
+
+// defineSlot compiles children into a {{ define }} block and returns its
+// name and the data to render it with. The name is empty if the children
+// produce no output.
+func (c *Compiler) defineSlot(children []parser.Node) (string, string, error) {
+	data := c.scopeData()
+
+	// The body runs as its own template where dot is the slot data again
+	savedScopes, savedDepth := c.scopes, c.loopDepth
+	c.scopes, c.loopDepth = nil, 0
+	body, err := c.compileChildren(children)
+	c.scopes, c.loopDepth = savedScopes, savedDepth
+	if err != nil {
+		return "", "", err
+	}
+
+	if body == "" {
+		return "", "", nil
+	}
+
+	hash := md5.Sum([]byte(unmark(body)))
+	name := "__slot_" + hex.EncodeToString(hash[:8])
+	if _, ok := c.slotBodies[name]; !ok {
+		c.slotNames = append(c.slotNames, name)
+		c.slotBodies[name] = body
+	}
+
+	return name, data, nil
+}
+
+// scopeData returns the pipeline for the data of a template rendered from
+// here, such as a slot or a partial: the template data with the variables
+// in scope merged in
+func (c *Compiler) scopeData() string {
+	var locals []string
+	seen := make(map[string]bool)
+	for _, scope := range c.scopes {
+		for name := range scope {
+			if !seen[name] {
+				seen[name] = true
+				locals = append(locals, name)
+			}
+		}
+	}
+	sort.Strings(locals)
+
+	data := c.rootData()
+	if len(locals) == 0 {
+		return data
+	}
+	pairs := make([]string, 0, len(locals)*2)
+	for _, local := range locals {
+		pairs = append(pairs, templateString(local), "$"+local)
+	}
+	return fmt.Sprintf("(merge %s (dict %s))", data, strings.Join(pairs, " "))
+}
+
+// rootData returns the pipeline for the template data, which is no longer
+// dot inside a range loop
+func (c *Compiler) rootData() string {
+	if c.loopDepth > 0 {
+		return "$"
+	}
+	return "."
+}
+
+// compileAware compiles @aware, binding each prop to a template variable
+// resolved from the props passed to this component, then from enclosing
+// components, then from the declared default
+func (c *Compiler) compileAware(n *parser.AwareNode) string {
+	var result strings.Builder
+	for _, prop := range n.Props {
+		def := ""
+		if prop.Default != "" {
+			def = " " + c.transformExpression(prop.Default)
+		}
+		c.declareLocal(prop.Name)
+		result.WriteString(fmt.Sprintf("{{ $%s := aware . \"%s\"%s }}", prop.Name, prop.Name, def))
+	}
+	return result.String()
+}
+
+// compileProps compiles @props. Each prop is bound like @aware: the value
+// passed to this component wins over one passed to an enclosing component,
+// which wins over the declared default. The passed props that are not
+// declared are bound to $attributes.
+func (c *Compiler) compileProps(n *parser.PropsNode) string {
+	var result strings.Builder
+	names := make([]string, 0, len(n.Props))
+	for _, prop := range n.Props {
+		def := ""
+		if prop.Default != "" {
+			def = " " + c.transformExpression(prop.Default)
+		}
+		c.declareLocal(prop.Name)
+		result.WriteString(fmt.Sprintf("{{ $%s := aware . \"%s\"%s }}", prop.Name, prop.Name, def))
+		names = append(names, templateString(prop.Name))
+	}
+
+	c.declareLocal("attributes")
+	result.WriteString("{{ $attributes := attributesExcept .")
+	for _, name := range names {
+		result.WriteString(" " + name)
+	}
+	result.WriteString(" }}")
+	return result.String()
+}
+
+var phpAssignRe = regexp.MustCompile(`^\$([a-zA-Z_][a-zA-Z0-9_]*)\s*=([^=].*)$`)
+
+// compilePhp compiles @php($var = expr) and @php...@endphp. Only variable
+// assignments are supported; they become template variables that stay in
+// scope until the end of the enclosing block.
+func (c *Compiler) compilePhp(n *parser.PhpNode) (string, error) {
+	statements := []string{n.Code}
+	if !n.Inline {
+		statements = strings.FieldsFunc(n.Code, func(r rune) bool {
+			return r == ';' || r == '\n'
+		})
+	}
+
+	var result strings.Builder
+	for _, stmt := range statements {
+		stmt = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+		if stmt == "" {
+			continue
+		}
+
+		m := phpAssignRe.FindStringSubmatch(stmt)
+		if m == nil {
+			return "", &CompilerError{
+				Message:  fmt.Sprintf("unsupported @php statement %q, only $variable = expression assignments are allowed", stmt),
+				Position: n.Pos,
+			}
+		}
+
+		name := m[1]
+		expr := c.transformExpression(m[2])
+		if c.isLocal(name) {
+			result.WriteString(fmt.Sprintf("{{ $%s = %s }}", name, expr))
+		} else {
+			c.declareLocal(name)
+			result.WriteString(fmt.Sprintf("{{ $%s := %s }}", name, expr))
+		}
+	}
+
+	return result.String(), nil
 }
 
 // compileIsset compiles @isset...@endisset
@@ -674,7 +1339,7 @@ func (c *Compiler) compileIsset(n *parser.IssetNode) (string, error) {
 	variable := c.transformExpression(n.Variable)
 	result.WriteString(fmt.Sprintf("{{ if isset %s }}", variable))
 
-	children, err := c.compileChildren(n.Children)
+	children, err := c.compileBranch(n.Children)
 	if err != nil {
 		return "", err
 	}
@@ -691,7 +1356,7 @@ func (c *Compiler) compileEmptyCheck(n *parser.EmptyCheckNode) (string, error) {
 	variable := c.transformExpression(n.Variable)
 	result.WriteString(fmt.Sprintf("{{ if empty %s }}", variable))
 
-	children, err := c.compileChildren(n.Children)
+	children, err := c.compileBranch(n.Children)
 	if err != nil {
 		return "", err
 	}
@@ -711,7 +1376,7 @@ func (c *Compiler) compileAuth(n *parser.AuthNode) (string, error) {
 		result.WriteString("{{ if .auth }}")
 	}
 
-	children, err := c.compileChildren(n.Children)
+	children, err := c.compileBranch(n.Children)
 	if err != nil {
 		return "", err
 	}
@@ -721,6 +1386,55 @@ func (c *Compiler) compileAuth(n *parser.AuthNode) (string, error) {
 	return result.String(), nil
 }
 
+// compileAllow compiles @allow...@endallow, asking the engine's gate
+// whether the permission is granted
+func (c *Compiler) compileAllow(n *parser.AllowNode) (string, error) {
+	if strings.TrimSpace(n.Permission) == "" {
+		return "", &CompilerError{
+			Message:  "@allow expects a permission",
+			Position: n.Pos,
+		}
+	}
+
+	children, err := c.compileBranch(n.Children)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("{{ if allow $ %s }}%s{{ end }}", c.transformExpression(n.Permission), children), nil
+}
+
+// compileFeature compiles @feature('flag')...@else...@endfeature
+func (c *Compiler) compileFeature(n *parser.FeatureNode) (string, error) {
+	if strings.TrimSpace(n.Flag) == "" {
+		return "", &CompilerError{
+			Message:  "@feature expects a flag",
+			Position: n.Pos,
+		}
+	}
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("{{ if feature %s }}", c.transformExpression(n.Flag)))
+
+	children, err := c.compileBranch(n.Children)
+	if err != nil {
+		return "", err
+	}
+	result.WriteString(children)
+
+	if n.Else != nil {
+		result.WriteString("{{ else }}")
+		elseChildren, err := c.compileBranch(n.Else.Children)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(elseChildren)
+	}
+
+	result.WriteString("{{ end }}")
+	return result.String(), nil
+}
+
 // compileGuest compiles @guest...@endguest
 func (c *Compiler) compileGuest(n *parser.GuestNode) (string, error) {
 	var result strings.Builder
@@ -731,7 +1445,7 @@ func (c *Compiler) compileGuest(n *parser.GuestNode) (string, error) {
 		result.WriteString("{{ if not .auth }}")
 	}
 
-	children, err := c.compileChildren(n.Children)
+	children, err := c.compileBranch(n.Children)
 	if err != nil {
 		return "", err
 	}
@@ -755,7 +1469,7 @@ func (c *Compiler) compileEnv(n *parser.EnvNode) (string, error) {
 		result.WriteString(fmt.Sprintf("{{ if or %s }}", strings.Join(conditions, " ")))
 	}
 
-	children, err := c.compileChildren(n.Children)
+	children, err := c.compileBranch(n.Children)
 	if err != nil {
 		return "", err
 	}
@@ -771,7 +1485,7 @@ func (c *Compiler) compileProduction(n *parser.ProductionNode) (string, error) {
 
 	result.WriteString(`{{ if eq .env "production" }}`)
 
-	children, err := c.compileChildren(n.Children)
+	children, err := c.compileBranch(n.Children)
 	if err != nil {
 		return "", err
 	}
@@ -785,8 +1499,40 @@ func (c *Compiler) compileProduction(n *parser.ProductionNode) (string, error) {
 func (c *Compiler) compileError(n *parser.ErrorNode) (string, error) {
 	var result strings.Builder
 
-	result.WriteString(fmt.Sprintf("{{ if hasError .errors \"%s\" }}", n.Field))
-	result.WriteString(fmt.Sprintf("{{ $message := getError .errors \"%s\" }}", n.Field))
+	fields := make([]string, len(n.Fields))
+	for i, field := range n.Fields {
+		fields[i] = fmt.Sprintf("%q", field)
+	}
+	args := strings.Join(fields, " ")
+
+	// $message and $messages are scoped to the @error block
+	result.WriteString(fmt.Sprintf("{{ if hasError $.errors %s }}", args))
+	result.WriteString(fmt.Sprintf("{{ $message := getError $.errors %s }}", args))
+	result.WriteString(fmt.Sprintf("{{ $messages := getErrors $.errors %s }}", args))
+
+	c.pushScope("message", "messages")
+	defer c.popScope()
+
+	children, err := c.compileChildren(n.Children)
+	if err != nil {
+		return "", err
+	}
+	result.WriteString(children)
+	result.WriteString("{{ end }}")
+
+	return result.String(), nil
+}
+
+// compileFlash compiles @flash...@endflash. The message is bound to
+// $message, scoped to the block.
+func (c *Compiler) compileFlash(n *parser.FlashNode) (string, error) {
+	var result strings.Builder
+
+	result.WriteString(fmt.Sprintf("{{ if getFlash $.flash %q }}", n.Key))
+	result.WriteString(fmt.Sprintf("{{ $message := getFlash $.flash %q }}", n.Key))
+
+	c.pushScope("message")
+	defer c.popScope()
 
 	children, err := c.compileChildren(n.Children)
 	if err != nil {
@@ -805,7 +1551,7 @@ func (c *Compiler) compileOnce(n *parser.OnceNode) (string, error) {
 		return "", err
 	}
 
-	key := fmt.Sprintf("once_%s", children)
+	key := fmt.Sprintf("once_%s", unmark(children))
 	if c.onceKeys[key] {
 		return "", nil
 	}
@@ -814,10 +1560,80 @@ func (c *Compiler) compileOnce(n *parser.OnceNode) (string, error) {
 	return children, nil
 }
 
+// compileCache compiles @cache('key', ttl)...@endcache. The block is
+// rendered by cacheFragment, which caches it under the key together with
+// the values of the variables the block refers to.
+func (c *Compiler) compileCache(n *parser.CacheNode) (string, error) {
+	if n.Key == "" {
+		return "", &CompilerError{
+			Message:  "@cache expects a key",
+			Position: n.Pos,
+		}
+	}
+
+	name, data, err := c.defineSlot(n.Children)
+	if err != nil || name == "" {
+		return "", err
+	}
+
+	ttl := "0"
+	if n.TTL != "" {
+		ttl = c.transformExpression(n.TTL)
+	}
+
+	return fmt.Sprintf("{{ cacheFragment %s %s %s %q %s }}",
+		c.transformExpression(n.Key), ttl, c.referencedData(c.slotBodies[name]), name, data), nil
+}
+
+var (
+	actionRe   = regexp.MustCompile(`{{(.*?)}}`)
+	fieldRefRe = regexp.MustCompile(`(?:^|[\s($])\.([a-zA-Z_][a-zA-Z0-9_]*)`)
+)
+
+// referencedData returns a dict of the template data fields a compiled
+// body refers to, evaluated at the call site
+func (c *Compiler) referencedData(body string) string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, action := range actionRe.FindAllStringSubmatch(body, -1) {
+		for _, ref := range fieldRefRe.FindAllStringSubmatch(action[1], -1) {
+			if name := ref[1]; !seen[name] && !strings.HasPrefix(name, "__") {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names)*2)
+	for _, name := range names {
+		value := "." + name
+		if c.isLocal(name) {
+			value = "$" + name
+		} else if c.rootData() == "$" {
+			value = "$." + name
+		}
+		pairs = append(pairs, templateString(name), value)
+	}
+	return "(" + strings.TrimSpace("dict "+strings.Join(pairs, " ")) + ")"
+}
+
+// compileSpaceless compiles @spaceless...@endspaceless
+// The block is delimited with markers; whitespace between tags is
+// removed from the rendered output by the engine.
+func (c *Compiler) compileSpaceless(n *parser.SpacelessNode) (string, error) {
+	children, err := c.compileChildren(n.Children)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`{{ spaceless "start" }}%s{{ spaceless "end" }}`, children), nil
+}
+
 // compileBreak compiles @break
 func (c *Compiler) compileBreak(n *parser.BreakNode) string {
 	if n.Condition != "" {
-		cond := c.transformExpression(n.Condition)
+		cond := c.truthy(c.transformExpression(n.Condition))
 		return fmt.Sprintf("{{ if %s }}{{ break }}{{ end }}", cond)
 	}
 	return "{{ break }}"
@@ -826,57 +1642,226 @@ func (c *Compiler) compileBreak(n *parser.BreakNode) string {
 // compileContinue compiles @continue
 func (c *Compiler) compileContinue(n *parser.ContinueNode) string {
 	if n.Condition != "" {
-		cond := c.transformExpression(n.Condition)
+		cond := c.truthy(c.transformExpression(n.Condition))
 		return fmt.Sprintf("{{ if %s }}{{ continue }}{{ end }}", cond)
 	}
 	return "{{ continue }}"
 }
 
+// pushScope declares template variables for the enclosing block
+func (c *Compiler) pushScope(names ...string) {
+	scope := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name != "" && name != "_" {
+			scope[name] = true
+		}
+	}
+	c.scopes = append(c.scopes, scope)
+}
+
+// declareLocal declares a template variable in the innermost block
+func (c *Compiler) declareLocal(name string) {
+	if len(c.scopes) == 0 {
+		c.pushScope()
+	}
+	c.scopes[len(c.scopes)-1][name] = true
+}
+
+// popScope discards the variables declared by the innermost block
+func (c *Compiler) popScope() {
+	if len(c.scopes) > 0 {
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	}
+}
+
+// isLocal checks if a variable is declared by an enclosing block
+func (c *Compiler) isLocal(name string) bool {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if c.scopes[i][name] {
+			return true
+		}
+	}
+	return false
+}
+
 // transformExpression transforms PHP-style expression to Go template
 func (c *Compiler) transformExpression(expr string) string {
 	expr = strings.TrimSpace(expr)
 
-	// Transform $variable to .variable
+	// Transform $variable to .variable, keeping block-scoped variables.
+	// Inside a loop dot is the item, so the field is read from $ instead.
 	re := regexp.MustCompile(`\$([a-zA-Z_][a-zA-Z0-9_]*)`)
-	expr = re.ReplaceAllString(expr, ".$1")
+	expr = re.ReplaceAllStringFunc(expr, func(match string) string {
+		if c.isLocal(match[1:]) {
+			return match
+		}
+		if c.loopDepth > 0 {
+			return "$." + match[1:]
+		}
+		return "." + match[1:]
+	})
+
+	// Transform $loop->index to $loop.Index
+	expr = loopPropertyRe.ReplaceAllStringFunc(expr, func(match string) string {
+		return loopSegmentRe.ReplaceAllStringFunc(match, func(segment string) string {
+			name := strings.TrimLeft(segment, "->.")
+			return "." + strings.ToUpper(name[:1]) + name[1:]
+		})
+	})
+
+	// Transform method calls $obj->method('arg') to (.obj.Method "arg")
+	expr = transformMethodCalls(expr)
 
 	// Transform -> to .
 	expr = strings.ReplaceAll(expr, "->", ".")
 
-	// Transform array access $arr['key'] to (index .arr "key")
-	arrayRe := regexp.MustCompile(`\.([a-zA-Z_][a-zA-Z0-9_]*)\[['"]([^'"]+)['"]\]`)
-	expr = arrayRe.ReplaceAllString(expr, `(index .$1 "$2")`)
+	// Transform array access $arr['key'][0] to (index .arr "key" 0)
+	expr = arrayAccessRe.ReplaceAllStringFunc(expr, func(match string) string {
+		m := arrayAccessRe.FindStringSubmatch(match)
+		keys := arrayKeyRe.FindAllStringSubmatch(m[2], -1)
+		args := make([]string, len(keys))
+		for i, key := range keys {
+			args[i] = key[1]
+			if strings.HasPrefix(key[1], "'") {
+				args[i] = `"` + strings.Trim(key[1], "'") + `"`
+			}
+		}
+		return fmt.Sprintf("(index %s %s)", m[1], strings.Join(args, " "))
+	})
 
-	// Transform !== to ne
-	expr = strings.ReplaceAll(expr, "!==", " ne ")
-	expr = strings.ReplaceAll(expr, "!=", " ne ")
+	// Transform infix operators (arithmetic, comparison, logical) to prefix calls
+	expr = rewriteOperators(expr)
 
-	// Transform === to eq
-	expr = strings.ReplaceAll(expr, "===", " eq ")
-	expr = strings.ReplaceAll(expr, "==", " eq ")
+	// Clean up multiple spaces
+	expr = regexp.MustCompile(`\s+`).ReplaceAllString(expr, " ")
 
-	// Transform && to and
-	expr = strings.ReplaceAll(expr, "&&", " and ")
+	return strings.TrimSpace(expr)
+}
 
-	// Transform || to or
-	expr = strings.ReplaceAll(expr, "||", " or ")
+var (
+	// loopPropertyRe matches property access on $loop, whose fields are
+	// exported, such as $loop->index or $loop.parent.first
+	loopPropertyRe = regexp.MustCompile(`\$loop(?:(?:->|\.)[a-zA-Z_][a-zA-Z0-9_]*)+\b`)
+	loopSegmentRe  = regexp.MustCompile(`(?:->|\.)[a-zA-Z_][a-zA-Z0-9_]*`)
 
-	// Transform ! to not (careful with != already transformed)
-	expr = regexp.MustCompile(`!([^=])`).ReplaceAllString(expr, "not $1")
+	arrayAccessRe = regexp.MustCompile(`(\$?[.$][a-zA-Z_][a-zA-Z0-9_]*(?:\.[a-zA-Z_][a-zA-Z0-9_]*)*)((?:\[(?:'[^']*'|"[^"]*"|\d+)\])+)`)
+	arrayKeyRe    = regexp.MustCompile(`\[('[^']*'|"[^"]*"|\d+)\]`)
+)
 
-	// Transform >= and <=
-	expr = strings.ReplaceAll(expr, ">=", " gte ")
-	expr = strings.ReplaceAll(expr, "<=", " lte ")
-	expr = strings.ReplaceAll(expr, ">", " gt ")
-	expr = strings.ReplaceAll(expr, "<", " lt ")
+// pseudoMethods maps collection methods commonly called on plain slices
+// and maps in Blade templates to the template functions evaluating them
+var pseudoMethods = map[string]string{
+	"count":      "length %s",
+	"isEmpty":    "empty %s",
+	"isNotEmpty": "not (empty %s)",
+	"exists":     "exists %s",
+}
 
-	// Clean up multiple spaces
-	expr = regexp.MustCompile(`\s+`).ReplaceAllString(expr, " ")
+var methodCallRe = regexp.MustCompile(`(\$?[.$][a-zA-Z_][a-zA-Z0-9_]*(?:(?:->|\.)[a-zA-Z_][a-zA-Z0-9_]*)*)->([a-zA-Z_][a-zA-Z0-9_]*)\(([^()]*)\)`)
+
+// transformMethodCalls rewrites PHP-style method calls into Go template
+// method invocations, capitalizing the method name so it resolves to an
+// exported Go method. Single-quoted string arguments become double-quoted.
+// Argument-less pseudo-methods such as count() and isEmpty() become
+// function calls, so they also work on plain slices and maps.
+func transformMethodCalls(expr string) string {
+	for methodCallRe.MatchString(expr) {
+		expr = methodCallRe.ReplaceAllStringFunc(expr, func(match string) string {
+			parts := methodCallRe.FindStringSubmatch(match)
+			receiver := strings.ReplaceAll(parts[1], "->", ".")
+			if format, ok := pseudoMethods[parts[2]]; ok && strings.TrimSpace(parts[3]) == "" {
+				return "(" + fmt.Sprintf(format, receiver) + ")"
+			}
+			method := strings.ToUpper(parts[2][:1]) + parts[2][1:]
+
+			call := receiver + "." + method
+			for _, arg := range parser.SplitArgs(parts[3]) {
+				call += " " + quoteArg(arg)
+			}
+			return "(" + call + ")"
+		})
+	}
 
-	return strings.TrimSpace(expr)
+	// Calls chained on a call result: collect($a)->where('x', 1)->count()
+	// becomes ((collect(.a).Where "x" 1).Count)
+	for {
+		loc := chainedCall(expr)
+		if loc == nil {
+			break
+		}
+		open := openingParen(expr, loc[0])
+		argsClose := closingParen(expr, loc[1]-1)
+		if open < 0 || argsClose < 0 {
+			break
+		}
+		start := open
+		for start > 0 && isIdentByte(expr[start-1]) {
+			start--
+		}
+
+		method := expr[loc[2]:loc[3]]
+		call := expr[start:loc[0]+1] + "." + strings.ToUpper(method[:1]) + method[1:]
+		for _, arg := range parser.SplitArgs(expr[loc[1]:argsClose]) {
+			call += " " + quoteArg(arg)
+		}
+		expr = expr[:start] + "(" + call + ")" + expr[argsClose+1:]
+	}
+	return expr
+}
+
+var chainedCallRe = regexp.MustCompile(`\)->([a-zA-Z_][a-zA-Z0-9_]*)\(`)
+
+// chainedCall returns the submatch indexes of the first call chained on a
+// call result outside quoted strings, or nil
+func chainedCall(expr string) []int {
+	for _, loc := range chainedCallRe.FindAllStringSubmatchIndex(expr, -1) {
+		if !inQuotes(expr, loc[0]) {
+			return loc
+		}
+	}
+	return nil
+}
+
+// inQuotes checks if the byte at pos is inside a quoted string
+func inQuotes(expr string, pos int) bool {
+	for i := 0; i < pos; i++ {
+		switch expr[i] {
+		case '"', '\'', '`':
+			end := closingQuote(expr, i)
+			if pos <= end {
+				return true
+			}
+			i = end
+		}
+	}
+	return false
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+// quoteArg converts a single-quoted string literal to a double-quoted one
+func quoteArg(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= 2 && arg[0] == '\'' && arg[len(arg)-1] == '\'' {
+		return fmt.Sprintf("%q", arg[1:len(arg)-1])
+	}
+	return arg
+}
+
+// templateString quotes s as a Go template string literal. Unlike a raw
+// `...` literal, the quoted form can hold any content, including backticks.
+func templateString(s string) string {
+	return strconv.Quote(s)
+}
+
+// CompilerError represents a compiler error
+type CompilerError struct {
+	Message  string
+	Position lexer.Position
 }
 
-// escapeBackticks escapes backticks in string for Go raw string literals
-func escapeBackticks(s string) string {
-	return strings.ReplaceAll(s, "`", "` + \"`\" + `")
+func (e *CompilerError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Message, e.Position.Line, e.Position.Column)
 }
@@ -0,0 +1,399 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codingersid/legit-template/lexer"
+	"github.com/codingersid/legit-template/parser"
+)
+
+func compileTemplate(t *testing.T, input string) (string, error) {
+	lex := lexer.New(input)
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+
+	p := parser.New(tokens)
+	ast, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	return New().Compile(ast)
+}
+
+func TestCompiler_MethodLowercase(t *testing.T) {
+	compiled, err := compileTemplate(t, "@method('put')")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `<input type="hidden" name="_method" value="PUT">`
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_MethodInvalid(t *testing.T) {
+	for _, input := range []string{"@method('GET')", `@method('PUT"><script>')`} {
+		_, err := compileTemplate(t, input)
+		if err == nil {
+			t.Fatalf("expected error for %s", input)
+		}
+
+		cerr, ok := err.(*CompilerError)
+		if !ok {
+			t.Fatalf("expected CompilerError, got %T", err)
+		}
+		if cerr.Position.Line != 1 {
+			t.Errorf("expected error on line 1, got %d", cerr.Position.Line)
+		}
+	}
+}
+
+func TestCompiler_PhpInlineAssignment(t *testing.T) {
+	compiled, err := compileTemplate(t, "@php($total = $qty * $price + 1){{ $total }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(compiled, "{{ $total := (add (mul .qty .price) 1) }}") {
+		t.Errorf("expected assignment binding, got %q", compiled)
+	}
+	if strings.Contains(compiled, ".total") {
+		t.Errorf("expected $total to stay a template variable, got %q", compiled)
+	}
+}
+
+func TestCompiler_PhpScopedToBranch(t *testing.T) {
+	compiled, err := compileTemplate(t, "@if($a)@php($x = 1)@else@php($x = 2)@endif@php($x = 3){{ $x }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "{{ if toBool (.a) }}{{ $x := 1 }}{{ else }}{{ $x := 2 }}{{ end }}{{ $x := 3 }}{{ html $x }}"
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_PhpBlockUnsupported(t *testing.T) {
+	_, err := compileTemplate(t, "@php echo 'hi'; @endphp")
+	if err == nil {
+		t.Fatal("expected error for unsupported @php statement")
+	}
+}
+
+func TestCompiler_ArithmeticPrecedence(t *testing.T) {
+	compiled, err := compileTemplate(t, "{{ $a + $b * $c }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "{{ html (add .a (mul .b .c)) }}"
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_UnaryMinus(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{{ -$a }}", "{{ html (sub 0 .a) }}"},
+		{"{{ $a * -$b }}", "{{ html (mul .a (sub 0 .b)) }}"},
+		{"{{ -($a + 1) }}", "{{ html (sub 0 (add .a 1)) }}"},
+		{"{{ $a - -2 }}", "{{ html (sub .a -2) }}"},
+	}
+
+	for _, tt := range tests {
+		compiled, err := compileTemplate(t, tt.input)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.input, err)
+		}
+		if compiled != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, compiled)
+		}
+	}
+}
+
+func TestCompiler_ArithmeticComparisonCondition(t *testing.T) {
+	compiled, err := compileTemplate(t, "@if(($a + $b) * 2 > 10 && !$done)yes@endif")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "{{ if toBool (and (gt (mul (add .a .b) 2) 10) (not .done)) }}yes{{ end }}"
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_GroupedBooleanExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"@if(($a || $b) && $c)x@endif", "{{ if toBool (and (or .a .b) .c) }}x{{ end }}"},
+		{"@if($a || $b && $c)x@endif", "{{ if toBool (or .a (and .b .c)) }}x{{ end }}"},
+		{"@if($a || ($b && ($c || !$d)))x@endif", "{{ if toBool (or .a (and .b (or .c (not .d)))) }}x{{ end }}"},
+		{"@if(!($a || $b) && ($c || $d))x@endif", "{{ if toBool (and (not (or .a .b)) (or .c .d)) }}x{{ end }}"},
+		{"@if(($a == 1) || ($b != \"x\"))x@endif", "{{ if toBool (or (eq .a 1) (ne .b \"x\")) }}x{{ end }}"},
+		{"@if($a and $b or not $c)x@endif", "{{ if toBool (or (and .a .b) (not .c)) }}x{{ end }}"},
+		{"@if(count($items) > 0)x@endif", "{{ if (gt (count .items) 0) }}x{{ end }}"},
+		{"@if(and $a $b)x@endif", "{{ if toBool (and .a .b) }}x{{ end }}"},
+	}
+
+	for _, tt := range tests {
+		compiled, err := compileTemplate(t, tt.input)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.input, err)
+		}
+		if compiled != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, compiled)
+		}
+	}
+}
+
+func TestCompiler_ArrayAccess(t *testing.T) {
+	compiled, err := compileTemplate(t, "{{ $user->meta['tags'][0] }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{{ html (index .user.meta "tags" 0) }}`
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_IncludeWhenArrayData(t *testing.T) {
+	compiled, err := compileTemplate(t, "@includeWhen($ok, 'partials.alert', ['type' => 'error', 'count' => $n + 1])")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{{ if toBool (.ok) }}{{ template "partials.alert" (merge . (dict "type" "error" "count" (add .n 1))) }}{{ end }}`
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_IncludeUnlessArrayData(t *testing.T) {
+	compiled, err := compileTemplate(t, "@includeUnless($user->isAdmin(), 'partials.notice', ['tags' => ['a', 'b']])")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{{ if not (toBool (.user.IsAdmin)) }}{{ template "partials.notice" (merge . (dict "tags" (list "a" "b"))) }}{{ end }}`
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_IncludeInLoop(t *testing.T) {
+	compiled, err := compileTemplate(t, "@foreach($items as $item)@include('partials.item', ['title' => $title])@endforeach")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{{ template "partials.item" (merge (merge $ (dict "item" $item "loop" $loop)) (dict "title" $.title)) }}`
+	if !strings.Contains(compiled, expected) {
+		t.Errorf("expected %q in %q", expected, compiled)
+	}
+}
+
+func TestCompiler_ComponentDefaultSlotConflict(t *testing.T) {
+	_, err := compileTemplate(t, `@component("card")Body@slot("default")Other@endslot@endcomponent`)
+	if err == nil {
+		t.Fatal("expected error for body content alongside @slot('default')")
+	}
+
+	compiled, err := compileTemplate(t, `@component("card") @slot("default")Other@endslot@endcomponent`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected, err := compileTemplate(t, `@component("card")Other@endcomponent`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if compiled != expected {
+		t.Errorf("expected named default slot to be used like body content, got %q", compiled)
+	}
+}
+
+func TestCompiler_IfTruthiness(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"@if($flag)x@endif", "{{ if toBool (.flag) }}x{{ end }}"},
+		{"@if($a)x@elseif($b->items)y@endif", "{{ if toBool (.a) }}x{{ else if toBool (.b.items) }}y{{ end }}"},
+		{"@if($a == 1)x@endif", "{{ if (eq .a 1) }}x{{ end }}"},
+		{"@if(!$a)x@endif", "{{ if (not .a) }}x{{ end }}"},
+		{"@if(true)x@endif", "{{ if true }}x{{ end }}"},
+		{"@if($a && $b)x@endif", "{{ if toBool (and .a .b) }}x{{ end }}"},
+		{"@unless($flag)x@endunless", "{{ if not (toBool (.flag)) }}x{{ end }}"},
+		{"@unless($a == 1)x@endunless", "{{ if not (eq .a 1) }}x{{ end }}"},
+	}
+
+	for _, tt := range tests {
+		compiled, err := compileTemplate(t, tt.input)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.input, err)
+		}
+		if compiled != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, compiled)
+		}
+	}
+
+	compiled, err := compileTemplate(t, "@foreach($items as $item)@break($item)@continue($skip)@endforeach")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "{{ if toBool ($item) }}{{ break }}{{ end }}{{ if toBool ($.skip) }}{{ continue }}{{ end }}"
+	if !strings.Contains(compiled, expected) {
+		t.Errorf("expected %q in %q", expected, compiled)
+	}
+}
+
+func TestCompiler_SwitchBreak(t *testing.T) {
+	compiled, err := compileTemplate(t, "@switch($x)@case(1)one@break@case(2)two@break@default other@endswitch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "{{ if eq .x 1 }}one{{ else if eq .x 2 }}two{{ else }} other{{ end }}"
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_SwitchFallthrough(t *testing.T) {
+	compiled, err := compileTemplate(t, "@switch($x)@case(1)one@case(2)two@break@case(3)three@default other@endswitch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "{{ if (eq .x 1) }}one{{ end }}" +
+		"{{ if (or (eq .x 1) (eq .x 2)) }}two{{ end }}" +
+		"{{ if (eq .x 3) }}three{{ end }}" +
+		"{{ if or (not (or (eq .x 1) (eq .x 2) (eq .x 3))) (eq .x 3) }} other{{ end }}"
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_SwitchStackedCases(t *testing.T) {
+	compiled, err := compileTemplate(t, "@switch($x)\n  @case(1)\n  @case(2)low@break@case(3)high@break@endswitch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "{{ if or (eq .x 1) (eq .x 2) }}low{{ else if eq .x 3 }}high{{ end }}"
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_ForelseBreak(t *testing.T) {
+	compiled, err := compileTemplate(t, "@foreach($groups as $g)@forelse($g as $i)@break($loop->index > 2){{ $i }}@empty @continue@endforelse@endforeach")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(compiled, "{{ if (gt $loop.Index 2) }}{{ break }}{{ end }}") {
+		t.Errorf("expected $loop->index to resolve to $loop.Index, got %q", compiled)
+	}
+	if !strings.Contains(compiled, "{{ end }}{{ else }} {{ continue }}{{ end }}{{ end }}") {
+		t.Errorf("expected @continue in the empty branch to follow the range, got %q", compiled)
+	}
+}
+
+func TestCompiler_Comments(t *testing.T) {
+	compiled, err := compileTemplate(t, "a{{-- hidden --}}b{{--! kept -- note --}}c")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `ab{{ raw "<!-- kept - - note -->" }}c`
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+}
+
+func TestCompiler_ChainedMethodCalls(t *testing.T) {
+	compiled, err := compileTemplate(t, "{{ collect($users)->where('active', true)->pluck('name')->count() }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{{ html ((((collect .users).Where "active" true).Pluck "name").Count) }}`
+	if compiled != expected {
+		t.Errorf("expected %q, got %q", expected, compiled)
+	}
+
+	compiled, err = compileTemplate(t, "{{ collect($users)->where('name', 'a)->b(')->count() }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = `{{ html (((collect .users).Where "name" "a).b(").Count) }}`
+	if compiled != expected {
+		t.Errorf("expected quoted text to be left alone, got %q", compiled)
+	}
+}
+
+func TestCompiler_PseudoMethods(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"@if($users->count() > 0)x@endif", "{{ if (gt (length .users) 0) }}x{{ end }}"},
+		{"@if($users->isEmpty())x@endif", "{{ if (empty .users) }}x{{ end }}"},
+		{"@if($user->posts->isNotEmpty())x@endif", "{{ if (not (empty .user.posts)) }}x{{ end }}"},
+		{"@if($user->posts->exists())x@endif", "{{ if (exists .user.posts) }}x{{ end }}"},
+		{"{{ $users->count('active') }}", `{{ html (.users.Count "active") }}`},
+	}
+
+	for _, tt := range tests {
+		compiled, err := compileTemplate(t, tt.input)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.input, err)
+		}
+		if compiled != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, compiled)
+		}
+	}
+}
+
+func TestCompiler_SourceMarks(t *testing.T) {
+	tokens, err := lexer.New("a\n@if($x)\n  {{ $y }}\n@elseif($z)\n@endif").Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+	ast, err := parser.New(tokens).Parse()
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	c := New()
+	c.SetSourceMarks("page")
+	compiled, err := c.Compile(ast)
+	if err != nil {
+		t.Fatalf("compiler error: %v", err)
+	}
+
+	clean, sourceMap := StripMarks(compiled)
+	if strings.Contains(clean, markDelim) {
+		t.Fatalf("marks left in %q", clean)
+	}
+
+	tests := []struct {
+		fragment string
+		line     int
+		column   int
+	}{
+		{"{{ if", 2, 1},
+		{"{{ html .y }}", 3, 3},
+		{"{{ else if", 4, 1},
+	}
+	for _, tt := range tests {
+		offset := strings.Index(clean, tt.fragment)
+		line := strings.Count(clean[:offset], "\n") + 1
+		column := offset - (strings.LastIndex(clean[:offset], "\n") + 1)
+
+		source, pos, ok := sourceMap.Lookup(line, column)
+		if !ok || source != "page" || pos.Line != tt.line || pos.Column != tt.column {
+			t.Errorf("%s: expected page:%d:%d, got %s:%d:%d (%v)", tt.fragment, tt.line, tt.column, source, pos.Line, pos.Column, ok)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package compiler
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/codingersid/legit-template/parser"
+)
+
+// exprTokenKind identifies the role of a token in an infix expression
+type exprTokenKind int
+
+const (
+	exprOperand exprTokenKind = iota
+	exprUnary
+	exprBinary
+)
+
+// exprToken is an operand or operator in an infix expression
+type exprToken struct {
+	kind  exprTokenKind
+	value string
+}
+
+// binaryOperator maps an infix operator to its template function
+type binaryOperator struct {
+	fn         string
+	precedence int
+}
+
+// binaryOperators lists the supported infix operators, following PHP precedence
+var binaryOperators = map[string]binaryOperator{
+	"||":  {"or", 1},
+	"&&":  {"and", 2},
+	"===": {"eq", 3},
+	"!==": {"ne", 3},
+	"==":  {"eq", 3},
+	"!=":  {"ne", 3},
+	"<":   {"lt", 4},
+	"<=":  {"lte", 4},
+	">":   {"gt", 4},
+	">=":  {"gte", 4},
+	"+":   {"add", 5},
+	"-":   {"sub", 5},
+	"*":   {"mul", 6},
+	"/":   {"div", 6},
+	"%":   {"mod", 6},
+}
+
+// unaryOperators maps a prefix operator to the call applying it. A minus
+// before anything but a number literal is tokenized as "neg".
+var unaryOperators = map[string]string{
+	"!":   "not",
+	"neg": "sub 0",
+}
+
+// operatorSymbols lists operator spellings, longest first
+var operatorSymbols = []string{
+	"===", "!==", "==", "!=", "<=", ">=", "&&", "||",
+	"<", ">", "+", "-", "*", "/", "%", "!",
+}
+
+// rewriteOperators converts infix operators into nested prefix calls using
+// a shunting-yard pass, e.g. ".a + .b * .c > 10" becomes
+// "(gt (add .a (mul .b .c)) 10)". Expressions without operators are
+// returned unchanged apart from rewriting parenthesized groups.
+func rewriteOperators(expr string) string {
+	tokens := tokenizeExpression(expr)
+
+	hasOperator := false
+	for _, tok := range tokens {
+		if tok.kind != exprOperand {
+			hasOperator = true
+			break
+		}
+	}
+	if !hasOperator {
+		expr = strings.TrimSpace(expr)
+		if isQuoted(expr) {
+			return operandExpr(expr)
+		}
+		return rewriteGroups(expr)
+	}
+
+	var output, ops []string
+	reduce := func() bool {
+		op := ops[len(ops)-1]
+		ops = ops[:len(ops)-1]
+
+		if fn, ok := unaryOperators[op]; ok {
+			if len(output) < 1 {
+				return false
+			}
+			output[len(output)-1] = "(" + fn + " " + output[len(output)-1] + ")"
+			return true
+		}
+
+		if len(output) < 2 {
+			return false
+		}
+		left, right := output[len(output)-2], output[len(output)-1]
+		output = append(output[:len(output)-2], "("+binaryOperators[op].fn+" "+left+" "+right+")")
+		return true
+	}
+
+	for _, tok := range tokens {
+		switch tok.kind {
+		case exprOperand:
+			output = append(output, operandExpr(tok.value))
+		case exprUnary:
+			ops = append(ops, tok.value)
+		case exprBinary:
+			prec := binaryOperators[tok.value].precedence
+			for len(ops) > 0 {
+				top := ops[len(ops)-1]
+				if _, unary := unaryOperators[top]; !unary && binaryOperators[top].precedence < prec {
+					break
+				}
+				if !reduce() {
+					return expr
+				}
+			}
+			ops = append(ops, tok.value)
+		}
+	}
+
+	for len(ops) > 0 {
+		if !reduce() {
+			return expr
+		}
+	}
+
+	if len(output) != 1 {
+		return expr
+	}
+	return output[0]
+}
+
+// tokenizeExpression splits an expression into operands and top-level
+// operators. Quoted strings and parenthesized groups stay inside operands,
+// and a leading + or - is kept as the sign of a following number literal.
+// A leading - before any other operand is a unary minus.
+func tokenizeExpression(expr string) []exprToken {
+	var tokens []exprToken
+	var operand strings.Builder
+	var quote byte
+	depth := 0
+
+	flush := func() {
+		if value := strings.TrimSpace(operand.String()); value != "" {
+			tokens = append(tokens, exprToken{kind: exprOperand, value: value})
+		}
+		operand.Reset()
+	}
+
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+
+		if quote != 0 {
+			if ch == quote && expr[i-1] != '\\' {
+				quote = 0
+			}
+			operand.WriteByte(ch)
+			i++
+			continue
+		}
+
+		switch ch {
+		case '"', '\'', '`':
+			quote = ch
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		}
+
+		if depth == 0 && !strings.HasPrefix(expr[i:], "->") {
+			if word, op := matchWordOperator(expr, i); word != "" {
+				expectOperand := strings.TrimSpace(operand.String()) == ""
+				switch {
+				case op == "!" && expectOperand:
+					tokens = append(tokens, exprToken{kind: exprUnary, value: op})
+					i += len(word)
+					continue
+				case op != "!" && !expectOperand:
+					flush()
+					tokens = append(tokens, exprToken{kind: exprBinary, value: op})
+					i += len(word)
+					continue
+				}
+			}
+			if op := matchOperator(expr[i:]); op != "" {
+				expectOperand := strings.TrimSpace(operand.String()) == ""
+				switch {
+				case op == "!" && expectOperand:
+					tokens = append(tokens, exprToken{kind: exprUnary, value: op})
+					i++
+					continue
+				case op == "-" && expectOperand && (i+1 >= len(expr) || expr[i+1] < '0' || expr[i+1] > '9'):
+					tokens = append(tokens, exprToken{kind: exprUnary, value: "neg"})
+					i++
+					continue
+				case (op == "+" || op == "-") && expectOperand:
+					// Sign of a numeric literal
+				case op != "!" && !expectOperand:
+					flush()
+					tokens = append(tokens, exprToken{kind: exprBinary, value: op})
+					i += len(op)
+					continue
+				}
+			}
+		}
+
+		operand.WriteByte(ch)
+		i++
+	}
+	flush()
+
+	return tokens
+}
+
+// matchOperator returns the operator at the start of s, if any
+func matchOperator(s string) string {
+	for _, op := range operatorSymbols {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// wordOperators maps PHP keyword operators to their symbolic form
+var wordOperators = map[string]string{"and": "&&", "or": "||", "not": "!"}
+
+// matchWordOperator returns the keyword operator starting at expr[i], if
+// it stands alone as a word. Used in prefix position, "and"/"or" are left
+// alone so Go template calls like "and .a .b" keep working.
+func matchWordOperator(expr string, i int) (string, string) {
+	if i > 0 && !strings.ContainsRune(" \t)", rune(expr[i-1])) {
+		return "", ""
+	}
+	for word, op := range wordOperators {
+		end := i + len(word)
+		if strings.HasPrefix(expr[i:], word) && end < len(expr) && strings.ContainsRune(" \t(", rune(expr[end])) {
+			return word, op
+		}
+	}
+	return "", ""
+}
+
+// operandExpr prepares an operand for use as a function argument,
+// parenthesizing multi-word operands such as "upper .name" and turning
+// single-quoted strings into Go string literals
+func operandExpr(operand string) string {
+	if isQuoted(operand) && operand[0] == '\'' {
+		return strconv.Quote(strings.ReplaceAll(operand[1:len(operand)-1], `\'`, `'`))
+	}
+	operand = rewriteGroups(operand)
+	if strings.HasPrefix(operand, "(") && closingParen(operand, 0) == len(operand)-1 {
+		return operand
+	}
+	if strings.ContainsAny(operand, " \t") && !isQuoted(operand) {
+		return "(" + operand + ")"
+	}
+	return operand
+}
+
+// rewriteGroups rewrites operators inside each top-level parenthesized group
+func rewriteGroups(expr string) string {
+	var result strings.Builder
+	for i := 0; i < len(expr); i++ {
+		ch := expr[i]
+		if ch == '"' || ch == '\'' || ch == '`' {
+			end := closingQuote(expr, i)
+			result.WriteString(expr[i : end+1])
+			i = end
+			continue
+		}
+		if ch == '[' && !strings.HasSuffix(strings.TrimSpace(result.String()), ".") {
+			end := closingBracket(expr, i)
+			if end == -1 {
+				result.WriteString(expr[i:])
+				break
+			}
+			result.WriteString(arrayLiteral(expr[i+1 : end]))
+			i = end
+			continue
+		}
+		if ch == '(' {
+			end := closingParen(expr, i)
+			if end == -1 {
+				result.WriteString(expr[i:])
+				break
+			}
+			if m := funcNameRe.FindStringSubmatch(result.String()); m != nil {
+				// PHP-style call fn(a, b) becomes (fn a b)
+				prefix := strings.TrimSuffix(result.String(), m[1])
+				result.Reset()
+				result.WriteString(prefix)
+				result.WriteString(callExpr(m[1], expr[i+1:end]))
+				i = end
+				continue
+			}
+			inner := rewriteOperators(expr[i+1 : end])
+			if strings.HasPrefix(inner, "(") && closingParen(inner, 0) == len(inner)-1 {
+				result.WriteString(inner)
+			} else {
+				result.WriteString("(" + inner + ")")
+			}
+			i = end
+			continue
+		}
+		result.WriteByte(ch)
+	}
+	return result.String()
+}
+
+// funcNameRe matches a function name directly before an opening parenthesis
+var funcNameRe = regexp.MustCompile(`(?:^|[^.$\w])([a-zA-Z_][a-zA-Z0-9_]*)$`)
+
+// dataFunctions lists the template functions that receive the render data
+// as their first argument
+var dataFunctions = map[string]bool{
+	"old":  true,
+	"slot": true,
+}
+
+// callExpr builds a prefix function call from comma-separated arguments
+func callExpr(name, args string) string {
+	parts := []string{name}
+	if dataFunctions[name] {
+		parts = append(parts, "$")
+	}
+	for _, arg := range parser.SplitArgs(args) {
+		parts = append(parts, operandExpr(rewriteOperators(arg)))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// arrayLiteral converts the body of a PHP array literal into a dict call
+// for ['k' => $v] or a list call for ['a', 'b']
+func arrayLiteral(body string) string {
+	items := parser.SplitArgs(body)
+	if len(items) == 0 {
+		return "(list)"
+	}
+
+	fn := "list"
+	parts := make([]string, 0, len(items)*2)
+	for _, item := range items {
+		if key, value, ok := splitPair(item); ok {
+			fn = "dict"
+			parts = append(parts, operandExpr(rewriteOperators(key)), operandExpr(rewriteOperators(value)))
+			continue
+		}
+		parts = append(parts, operandExpr(rewriteOperators(item)))
+	}
+
+	return "(" + fn + " " + strings.Join(parts, " ") + ")"
+}
+
+// splitPair splits an array item on its top-level "=>"
+func splitPair(item string) (string, string, bool) {
+	depth := 0
+	for i := 0; i < len(item)-1; i++ {
+		switch item[i] {
+		case '"', '\'', '`':
+			i = closingQuote(item, i)
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case '=':
+			if depth == 0 && item[i+1] == '>' {
+				return strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+2:]), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// closingParen returns the index of the parenthesis closing the one at start
+func closingParen(expr string, start int) int {
+	depth := 0
+	for i := start; i < len(expr); i++ {
+		switch expr[i] {
+		case '"', '\'', '`':
+			i = closingQuote(expr, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// openingParen returns the index of the parenthesis opened by the one at end
+func openingParen(expr string, end int) int {
+	for i := end - 1; i >= 0; i-- {
+		if expr[i] == '(' && closingParen(expr, i) == end {
+			return i
+		}
+	}
+	return -1
+}
+
+// closingBracket returns the index of the bracket closing the one at start
+func closingBracket(expr string, start int) int {
+	depth := 0
+	for i := start; i < len(expr); i++ {
+		switch expr[i] {
+		case '"', '\'', '`':
+			i = closingQuote(expr, i)
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// closingQuote returns the index of the quote closing the one at start
+func closingQuote(expr string, start int) int {
+	quote := expr[start]
+	for i := start + 1; i < len(expr); i++ {
+		if expr[i] == quote && expr[i-1] != '\\' {
+			return i
+		}
+	}
+	return len(expr) - 1
+}
+
+// isQuoted checks if s is a single quoted string literal
+func isQuoted(s string) bool {
+	return len(s) >= 2 && strings.ContainsRune("\"'`", rune(s[0])) && closingQuote(s, 0) == len(s)-1
+}
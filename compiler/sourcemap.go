@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codingersid/legit-template/lexer"
+)
+
+// markDelim delimits the source marks emitted with SetSourceMarks. Marks
+// look like "\x00line:column:source\x00" and never reach html/template.
+const markDelim = "\x00"
+
+// SourceMap maps positions in compiled Go template source back to the
+// .legit source the output was compiled from
+type SourceMap struct {
+	entries    []sourceEntry
+	lineStarts []int
+}
+
+// sourceEntry records that compiled output from offset onwards was
+// produced by the node at pos in source
+type sourceEntry struct {
+	offset int
+	source string
+	pos    lexer.Position
+}
+
+// StripMarks removes the source marks from compiled template source and
+// returns the clean source together with its source map
+func StripMarks(compiled string) (string, *SourceMap) {
+	m := &SourceMap{lineStarts: []int{0}}
+
+	var result strings.Builder
+	rest := compiled
+	for {
+		start := strings.Index(rest, markDelim)
+		if start == -1 {
+			break
+		}
+		end := strings.Index(rest[start+1:], markDelim)
+		if end == -1 {
+			break
+		}
+		end += start + 1
+
+		result.WriteString(rest[:start])
+		if entry, ok := parseMark(rest[start+1 : end]); ok {
+			entry.offset = result.Len()
+			m.entries = append(m.entries, entry)
+		}
+		rest = rest[end+1:]
+	}
+	result.WriteString(rest)
+
+	clean := result.String()
+	for i := 0; i < len(clean); i++ {
+		if clean[i] == '\n' {
+			m.lineStarts = append(m.lineStarts, i+1)
+		}
+	}
+
+	return clean, m
+}
+
+// Lookup returns the source name and position of the node that produced
+// the compiled output at line and column, as reported in Go template
+// errors (1-based line, 0-based byte column)
+func (m *SourceMap) Lookup(line, column int) (string, lexer.Position, bool) {
+	if m == nil || line < 1 || line > len(m.lineStarts) {
+		return "", lexer.Position{}, false
+	}
+	offset := m.lineStarts[line-1] + column
+
+	i := sort.Search(len(m.entries), func(i int) bool {
+		return m.entries[i].offset > offset
+	})
+	if i == 0 {
+		return "", lexer.Position{}, false
+	}
+	entry := m.entries[i-1]
+	return entry.source, entry.pos, true
+}
+
+// SetSourceMarks emits a source mark before the output of every node,
+// recording its position in the named source. The marks must be removed
+// with StripMarks before the output is parsed.
+func (c *Compiler) SetSourceMarks(source string) {
+	c.sourceMarks = true
+	c.source = source
+}
+
+// mark returns the source mark for a node position, or "" when source
+// marks are disabled
+func (c *Compiler) mark(pos lexer.Position) string {
+	if !c.sourceMarks {
+		return ""
+	}
+	return markDelim + strconv.Itoa(pos.Line) + ":" + strconv.Itoa(pos.Column) + ":" + c.source + markDelim
+}
+
+// parseMark parses the body of a source mark
+func parseMark(body string) (sourceEntry, bool) {
+	parts := strings.SplitN(body, ":", 3)
+	if len(parts) != 3 {
+		return sourceEntry{}, false
+	}
+	line, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return sourceEntry{}, false
+	}
+	column, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return sourceEntry{}, false
+	}
+	return sourceEntry{source: parts[2], pos: lexer.Position{Line: line, Column: column}}, true
+}
+
+// unmark removes source marks, for comparing compiled output
+func unmark(s string) string {
+	if !strings.Contains(s, markDelim) {
+		return s
+	}
+	clean, _ := StripMarks(s)
+	return clean
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"reflect"
+
+	"github.com/codingersid/legit-template/runtime"
+)
+
+// numbersOf returns the items of a slice or array as numbers. With a key,
+// each item's map entry or struct field is used and items without it are
+// skipped.
+func numbersOf(v interface{}, key ...string) []float64 {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+
+	numbers := make([]float64, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		item := rv.Index(i).Interface()
+		if len(key) > 0 {
+			if item = runtime.Field(item, key[0]); item == nil {
+				continue
+			}
+		}
+		numbers = append(numbers, toFloat64(item))
+	}
+	return numbers
+}
+
+// sum adds up the numbers of a slice
+func sum(v interface{}) float64 {
+	total := 0.0
+	for _, n := range numbersOf(v) {
+		total += n
+	}
+	return total
+}
+
+// sumBy adds up a key of each item
+func sumBy(v interface{}, key string) float64 {
+	total := 0.0
+	for _, n := range numbersOf(v, key) {
+		total += n
+	}
+	return total
+}
+
+// avgBy returns the average of a key over the items that have it, or 0
+func avgBy(v interface{}, key string) float64 {
+	numbers := numbersOf(v, key)
+	if len(numbers) == 0 {
+		return 0
+	}
+	return sumBy(v, key) / float64(len(numbers))
+}
+
+// countBy counts the items that have a key
+func countBy(v interface{}, key string) int {
+	return len(numbersOf(v, key))
+}
+
+// minBy returns the smallest value of a key, or nil when no item has it
+func minBy(v interface{}, key string) interface{} {
+	numbers := numbersOf(v, key)
+	if len(numbers) == 0 {
+		return nil
+	}
+	minVal := numbers[0]
+	for _, n := range numbers[1:] {
+		if n < minVal {
+			minVal = n
+		}
+	}
+	return minVal
+}
+
+// maxBy returns the largest value of a key, or nil when no item has it
+func maxBy(v interface{}, key string) interface{} {
+	numbers := numbersOf(v, key)
+	if len(numbers) == 0 {
+		return nil
+	}
+	maxVal := numbers[0]
+	for _, n := range numbers[1:] {
+		if n > maxVal {
+			maxVal = n
+		}
+	}
+	return maxVal
+}
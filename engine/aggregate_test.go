@@ -0,0 +1,62 @@
+package engine
+
+import "testing"
+
+func TestAggregate_SumAndAvg(t *testing.T) {
+	type item struct {
+		Name  string
+		Price float64
+	}
+	items := []item{{"a", 1.5}, {"b", 2}, {"c", 4.5}}
+	if got := sumBy(items, "Price"); got != 8 {
+		t.Errorf("sumBy: expected 8, got %v", got)
+	}
+	if got := sumBy(&items, "Price"); got != 0 {
+		t.Errorf("sumBy: expected 0 for a non-slice, got %v", got)
+	}
+
+	rows := []map[string]interface{}{
+		{"score": 90},
+		{"score": "70"},
+		{"name": "absent"},
+		{"score": 80.0},
+	}
+	if got := avgBy(rows, "score"); got != 80 {
+		t.Errorf("avgBy: expected 80, got %v", got)
+	}
+	if got := countBy(rows, "score"); got != 3 {
+		t.Errorf("countBy: expected 3, got %v", got)
+	}
+	if got := minBy(rows, "score"); got != 70.0 {
+		t.Errorf("minBy: expected 70, got %v", got)
+	}
+	if got := maxBy(rows, "score"); got != 90.0 {
+		t.Errorf("maxBy: expected 90, got %v", got)
+	}
+	if avgBy(rows, "missing") != 0 || minBy(rows, "missing") != nil || maxBy(nil, "score") != nil {
+		t.Error("expected zero values when no item has the key")
+	}
+
+	if got := sum([]int{1, 2, 3}); got != 6 {
+		t.Errorf("sum: expected 6, got %v", got)
+	}
+	if got := sum([]interface{}{1, 2.5, "3"}); got != 6.5 {
+		t.Errorf("sum: expected 6.5, got %v", got)
+	}
+}
+
+func TestEngine_Aggregate(t *testing.T) {
+	tpl := `{{ sumBy($items, 'price') }}/{{ avgBy($items, 'price') }}/{{ sum($scores) }}`
+	data := map[string]interface{}{
+		"items":  []map[string]interface{}{{"price": 10}, {"price": 20}},
+		"scores": []int{1, 2, 3},
+	}
+
+	out, err := New(t.TempDir()).RenderTemplate(tpl, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "30/15/6" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
@@ -1,11 +1,13 @@
 package engine
 
 import (
+	"container/list"
 	"crypto/md5"
 	"encoding/hex"
 	"html/template"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,38 +16,104 @@ type CachedTemplate struct {
 	Template *template.Template
 	ModTime  time.Time
 	Checksum string
+
+	// Every file compiled into the template, such as the layouts it
+	// extends and the partials it includes, by path. Nil when only the
+	// template file itself is known.
+	Dependencies map[string]Dependency
+
+	sources sourceMaps
+}
+
+// Dependency records the state of a file when it was compiled into a
+// cached template. The zero value records an optional include that did
+// not exist.
+type Dependency struct {
+	ModTime  time.Time
+	Checksum string
 }
 
 // TemplateCache manages template caching
 type TemplateCache struct {
 	templates map[string]*CachedTemplate
+	order     *list.List
+	elements  map[string]*list.Element
+	limit     int
 	mu        sync.RWMutex
 	disabled  bool
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// CacheStats holds template cache counters
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
 }
 
 // NewTemplateCache creates a new template cache
 func NewTemplateCache() *TemplateCache {
 	return &TemplateCache{
 		templates: make(map[string]*CachedTemplate),
+		order:     list.New(),
+		elements:  make(map[string]*list.Element),
 		disabled:  false,
 	}
 }
 
+// emptyCopy creates an empty cache with the same limit and state
+func (c *TemplateCache) emptyCopy() *TemplateCache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	copied := NewTemplateCache()
+	copied.limit = c.limit
+	copied.disabled = c.disabled
+	return copied
+}
+
+// SetLimit sets the maximum number of cached templates (0 means unlimited).
+// Least-recently-used templates are evicted when the limit is exceeded.
+func (c *TemplateCache) SetLimit(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = n
+	c.evict()
+}
+
 // Get retrieves a cached template if it exists and is valid
 func (c *TemplateCache) Get(name string) (*CachedTemplate, bool) {
 	if c.disabled {
 		return nil, false
 	}
 
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	cached, ok := c.templates[name]
+	if ok {
+		c.order.MoveToFront(c.elements[name])
+	}
 	return cached, ok
 }
 
 // Set stores a template in the cache
 func (c *TemplateCache) Set(name string, tmpl *template.Template, modTime time.Time, checksum string) {
+	c.store(name, &CachedTemplate{
+		Template: tmpl,
+		ModTime:  modTime,
+		Checksum: checksum,
+	})
+}
+
+// store adds a cached template, evicting the least recently used ones
+// beyond the limit
+func (c *TemplateCache) store(name string, cached *CachedTemplate) {
 	if c.disabled {
 		return
 	}
@@ -53,10 +121,62 @@ func (c *TemplateCache) Set(name string, tmpl *template.Template, modTime time.T
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.templates[name] = &CachedTemplate{
-		Template: tmpl,
-		ModTime:  modTime,
-		Checksum: checksum,
+	c.templates[name] = cached
+
+	if el, ok := c.elements[name]; ok {
+		c.order.MoveToFront(el)
+	} else {
+		c.elements[name] = c.order.PushFront(name)
+	}
+
+	c.evict()
+}
+
+// refresh replaces a cached template with an updated copy, unless it was
+// replaced or removed in the meantime
+func (c *TemplateCache) refresh(name string, old, updated *CachedTemplate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.templates[name] == old {
+		c.templates[name] = updated
+	}
+}
+
+// evict removes least-recently-used templates beyond the limit.
+// The caller must hold the write lock.
+func (c *TemplateCache) evict() {
+	if c.limit <= 0 {
+		return
+	}
+
+	for c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		name := oldest.Value.(string)
+		c.order.Remove(oldest)
+		delete(c.elements, name)
+		delete(c.templates, name)
+		c.evictions.Add(1)
+	}
+}
+
+// RecordHit counts a lookup served from the cache
+func (c *TemplateCache) RecordHit() {
+	c.hits.Add(1)
+}
+
+// RecordMiss counts a lookup that required compiling the template
+func (c *TemplateCache) RecordMiss() {
+	c.misses.Add(1)
+}
+
+// Stats returns the cache counters
+func (c *TemplateCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      c.Size(),
 	}
 }
 
@@ -66,6 +186,10 @@ func (c *TemplateCache) Delete(name string) {
 	defer c.mu.Unlock()
 
 	delete(c.templates, name)
+	if el, ok := c.elements[name]; ok {
+		c.order.Remove(el)
+		delete(c.elements, name)
+	}
 }
 
 // Clear removes all templates from the cache
@@ -74,6 +198,8 @@ func (c *TemplateCache) Clear() {
 	defer c.mu.Unlock()
 
 	c.templates = make(map[string]*CachedTemplate)
+	c.order.Init()
+	c.elements = make(map[string]*list.Element)
 }
 
 // Disable disables caching
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"html/template"
+	"sort"
+	"testing"
+	"time"
+)
+
+func setCached(c *TemplateCache, names ...string) {
+	for _, name := range names {
+		c.Set(name, template.New(name), time.Now(), Checksum([]byte(name)))
+	}
+}
+
+func TestTemplateCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewTemplateCache()
+	c.SetLimit(2)
+
+	setCached(c, "a", "b", "c")
+
+	if c.Size() != 2 {
+		t.Fatalf("expected size 2, got %d", c.Size())
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected 'a' to be evicted first")
+	}
+
+	names := c.Names()
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "b" || names[1] != "c" {
+		t.Errorf("expected [b c], got %v", names)
+	}
+}
+
+func TestTemplateCache_RecentlyAccessedSurvives(t *testing.T) {
+	c := NewTemplateCache()
+	c.SetLimit(2)
+
+	setCached(c, "a", "b")
+	c.Get("a")
+	setCached(c, "c")
+
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected recently accessed 'a' to survive")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected 'b' to be evicted")
+	}
+}
+
+func TestTemplateCache_ClearResetsOrder(t *testing.T) {
+	c := NewTemplateCache()
+	c.SetLimit(1)
+
+	setCached(c, "a")
+	c.Clear()
+	setCached(c, "b")
+
+	if c.Size() != 1 {
+		t.Fatalf("expected size 1, got %d", c.Size())
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error("expected 'b' to be cached after clear")
+	}
+}
+
+func TestTemplateCache_Stats(t *testing.T) {
+	c := NewTemplateCache()
+	c.SetLimit(1)
+	setCached(c, "a", "b")
+
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Size != 1 {
+		t.Errorf("expected 1 eviction and size 1, got %+v", stats)
+	}
+}
+
+func TestEngine_CacheStatsColdThenWarm(t *testing.T) {
+	e := newTestEngine(t, map[string]string{"page.legit": "hi"})
+
+	for i := 0; i < 2; i++ {
+		if _, err := e.RenderString("page", nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	stats := e.CacheStats()
+	if stats.Misses != 1 || stats.Hits != 1 {
+		t.Errorf("expected 1 miss and 1 hit, got %+v", stats)
+	}
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"html/template"
+	"time"
+)
+
+// WithClock sets the clock that now, ago and the date functions read the
+// current time from (default: time.Now), e.g. a fixed time in tests
+func WithClock(clock func() time.Time) Option {
+	return func(e *Engine) {
+		e.clock = clock
+	}
+}
+
+// clockFunctions returns the date functions that depend on the current
+// time, reading it from clock
+func clockFunctions(clock func() time.Time) template.FuncMap {
+	return template.FuncMap{
+		"now": clock,
+		"date": func(format string, t ...interface{}) string {
+			return formatDateAt(clock(), format, t...)
+		},
+		"ago": func(t interface{}) string {
+			return agoFrom(clock(), t)
+		},
+		"addDate": func(t interface{}, years, months, days int) time.Time {
+			return addDateAt(clock(), t, years, months, days)
+		},
+		"subDate": func(t interface{}, years, months, days int) time.Time {
+			return addDateAt(clock(), t, -years, -months, -days)
+		},
+		"timestamp": func(t ...interface{}) int64 {
+			return timestampAt(clock(), t...)
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"html/template"
+	"testing"
+	"time"
+)
+
+func TestEngine_WithClock(t *testing.T) {
+	fixed := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	e := New(t.TempDir(), WithClock(func() time.Time { return fixed }))
+
+	data := map[string]interface{}{
+		"posted":  fixed.Add(-90 * time.Minute),
+		"created": fixed.AddDate(0, 0, -3),
+	}
+	tpl := "{{ ago($posted) }}|{{ ago($created) }}|{{ date('Y-m-d H:i') }}|{{ timestamp() }}|{{ date('Y-m-d', addDate(now(), 0, 1, 0)) }}"
+
+	for i := 0; i < 2; i++ {
+		out, err := e.RenderTemplate(tpl, data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := "1 hour ago|3 days ago|2024-06-15 12:00|1718452800|2024-07-15"; out != expected {
+			t.Errorf("expected %q, got %q", expected, out)
+		}
+	}
+
+	later := fixed.Add(10 * time.Minute)
+	clone := e.WithOverrides(WithClock(func() time.Time { return later }))
+	if out, err := clone.RenderTemplate("{{ ago($posted) }}", data); err != nil || out != "1 hour ago" {
+		t.Errorf("unexpected clone output %q (%v)", out, err)
+	}
+	if out, _ := clone.RenderTemplate("{{ date('H:i') }}", nil); out != "12:10" {
+		t.Errorf("expected the clone's clock, got %q", out)
+	}
+}
+
+func TestEngine_WithClockKeepsUserFunctions(t *testing.T) {
+	fixed := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	e := New(t.TempDir(), WithClock(func() time.Time { return fixed }), WithFunctions(template.FuncMap{
+		"date": func(format string, t ...interface{}) string { return "custom date" },
+	}))
+	e.AddFunction("ago", func(t interface{}) string { return "custom ago" })
+
+	tpl := "{{ date('Y') }}|{{ ago(now()) }}|{{ timestamp() }}"
+	expected := "custom date|custom ago|1718452800"
+	if out, err := e.RenderTemplate(tpl, nil); err != nil || out != expected {
+		t.Errorf("expected %q, got %q (%v)", expected, out, err)
+	}
+
+	clone := e.WithOverrides(WithClock(func() time.Time { return fixed }))
+	if out, err := clone.RenderTemplate(tpl, nil); err != nil || out != expected {
+		t.Errorf("expected the overrides to keep user functions, got %q (%v)", out, err)
+	}
+}
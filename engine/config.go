@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"html/template"
+	"io/fs"
+	"time"
+)
+
+// Config configures an engine with a struct instead of options. Zero
+// values keep the defaults. Compiled templates are cached in memory only,
+// so there is no cache directory to configure.
+type Config struct {
+	ViewsPath          string
+	Extension          string   // Template file extension (default: .legit)
+	Extensions         []string // Template file extensions tried in order, overriding Extension
+	FileSystem         fs.FS    // Load templates from fsys instead of the OS
+	Development        bool     // Disable caching
+	StrictDirectives   bool     // Reject unknown directives
+	CollapseWhitespace bool     // Collapse whitespace-only text
+	KeepComments       bool     // Render {{-- --}} comments
+	CacheLimit         int      // Maximum number of cached templates (0: unlimited)
+	ChecksumValidation bool     // Validate cached templates by checksum
+	CSRFFieldName      string   // Input name rendered by @csrf (default: _token)
+	ComponentsPath     string   // Directory of @component templates (default: components)
+	CSRFTokenResolver  CSRFResolver
+	FeatureResolver    FeatureResolver // Reports enabled @feature flags
+	RawGuard           RawGuard        // Sanitizes {!! !!} output
+	Functions          template.FuncMap
+	FragmentCache      FragmentCache    // Store for @cache blocks (default: in memory)
+	Delimiters         [2]string        // Escaped echo delimiters, e.g. {"[[", "]]"} (default: {{ }})
+	Clock              func() time.Time // Current time for the date functions (default: time.Now)
+}
+
+// NewWithConfig creates a new template engine from a Config
+func NewWithConfig(cfg Config) *Engine {
+	return New(cfg.ViewsPath, cfg.Options()...)
+}
+
+// Options returns the options equivalent to the config
+func (cfg Config) Options() []Option {
+	var opts []Option
+
+	if cfg.Extension != "" {
+		opts = append(opts, WithExtension(cfg.Extension))
+	}
+	if len(cfg.Extensions) > 0 {
+		opts = append(opts, WithExtensions(cfg.Extensions...))
+	}
+	if cfg.FileSystem != nil {
+		opts = append(opts, WithFileSystem(cfg.FileSystem))
+	}
+	if cfg.Development {
+		opts = append(opts, WithDevelopment(true))
+	}
+	if cfg.StrictDirectives {
+		opts = append(opts, WithStrictDirectives(true))
+	}
+	if cfg.CollapseWhitespace {
+		opts = append(opts, WithCollapseWhitespace(true))
+	}
+	if cfg.KeepComments {
+		opts = append(opts, WithKeepComments(true))
+	}
+	if cfg.CacheLimit > 0 {
+		opts = append(opts, WithCacheLimit(cfg.CacheLimit))
+	}
+	if cfg.ChecksumValidation {
+		opts = append(opts, WithChecksumValidation(true))
+	}
+	if cfg.CSRFFieldName != "" {
+		opts = append(opts, WithCSRFFieldName(cfg.CSRFFieldName))
+	}
+	if cfg.ComponentsPath != "" {
+		opts = append(opts, WithComponentsPath(cfg.ComponentsPath))
+	}
+	if cfg.CSRFTokenResolver != nil {
+		opts = append(opts, WithCSRFTokenResolver(cfg.CSRFTokenResolver))
+	}
+	if cfg.FeatureResolver != nil {
+		opts = append(opts, WithFeatureResolver(cfg.FeatureResolver))
+	}
+	if cfg.RawGuard != nil {
+		opts = append(opts, WithRawGuard(cfg.RawGuard))
+	}
+	if cfg.Functions != nil {
+		opts = append(opts, WithFunctions(cfg.Functions))
+	}
+	if cfg.FragmentCache != nil {
+		opts = append(opts, WithFragmentCache(cfg.FragmentCache))
+	}
+	if cfg.Delimiters[0] != "" && cfg.Delimiters[1] != "" {
+		opts = append(opts, WithDelims(cfg.Delimiters[0], cfg.Delimiters[1]))
+	}
+	if cfg.Clock != nil {
+		opts = append(opts, WithClock(cfg.Clock))
+	}
+
+	return opts
+}
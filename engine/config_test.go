@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"html/template"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestNewWithConfig_MatchesOptions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"page.html": {Data: []byte("@csrf {{ shout($name) }}\n\n  @if(true)<b>x</b>@endif")},
+	}
+	funcs := template.FuncMap{"shout": func(s string) string { return s + "!" }}
+
+	fromOptions := New("",
+		WithFileSystem(fsys),
+		WithExtension("html"),
+		WithCollapseWhitespace(true),
+		WithCSRFFieldName("csrf"),
+		WithFunctions(funcs),
+	)
+	fromConfig := NewWithConfig(Config{
+		FileSystem:         fsys,
+		Extension:          "html",
+		CollapseWhitespace: true,
+		CSRFFieldName:      "csrf",
+		Functions:          funcs,
+	})
+
+	data := map[string]interface{}{"name": "hi", "csrf_token": "t0k"}
+	expected, err := fromOptions.RenderString("page", data)
+	if err != nil {
+		t.Fatalf("options: unexpected error: %v", err)
+	}
+	got, err := fromConfig.RenderString("page", data)
+	if err != nil {
+		t.Fatalf("config: unexpected error: %v", err)
+	}
+
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if expected != "<input type=\"hidden\" name=\"csrf\" value=\"t0k\"> hi!\n<b>x</b>" {
+		t.Errorf("unexpected output %q", expected)
+	}
+}
+
+func TestNewWithConfig_Delimiters(t *testing.T) {
+	e := NewWithConfig(Config{ViewsPath: t.TempDir(), Delimiters: [2]string{"[[", "]]"}})
+
+	out, err := e.RenderTemplate("[[ $name ]] {{ name }}", map[string]interface{}{"name": "<b>"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "&lt;b&gt; {{ name }}" {
+		t.Errorf("expected config delimiters to apply, got %q", out)
+	}
+}
+
+func TestNewWithConfig_Clock(t *testing.T) {
+	fixed := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	e := NewWithConfig(Config{ViewsPath: t.TempDir(), Clock: func() time.Time { return fixed }})
+
+	out, err := e.RenderTemplate("{{ date('Y-m-d') }}", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "2024-06-15" {
+		t.Errorf("expected the config clock to apply, got %q", out)
+	}
+}
+
+func TestNewWithConfig_Defaults(t *testing.T) {
+	e := NewWithConfig(Config{ViewsPath: "views"})
+
+	if e.viewsPath != "views" || e.extension != ".legit" || e.csrfField != "_token" || e.development {
+		t.Errorf("expected defaults, got path %q extension %q csrf %q development %v",
+			e.viewsPath, e.extension, e.csrfField, e.development)
+	}
+}
@@ -2,11 +2,14 @@ package engine
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,38 +24,114 @@ import (
 type Engine struct {
 	viewsPath   string
 	extension   string
+	extensions  []string // Extensions tried in order, set by WithExtensions
 	cache       *TemplateCache
 	functions   template.FuncMap
+	userFuncs   map[string]bool // Functions set or removed by the user
 	shared      *runtime.SharedData
+	services    map[string]interface{}
+	fsys        fs.FS
 	development bool
+	strict      bool
+	checksum    bool
+	collapse    bool
+	delims      [2]string // Escaped echo delimiters, set by WithDelims
+	clock       func() time.Time
+	comments    bool
+	fragments   FragmentCache
 	mutex       sync.RWMutex
 
+	// CSRF
+	csrfField    string
+	csrfResolver CSRFResolver
+
+	// Permission checks for @allow
+	gate Gate
+
+	// Feature flags for @feature
+	featureResolver FeatureResolver
+
+	// Directory of @component templates
+	componentsPath string
+
+	// Sanitizer for {!! !!} output
+	rawGuard RawGuard
+
+	// Additional view locations
+	paths      []string
+	namespaces map[string][]string
+
 	// Custom directives
-	directives map[string]DirectiveHandler
+	directives      map[string]DirectiveHandler
+	rawDirectives   map[string]bool // Directives whose output is trusted HTML
+	blockDirectives map[string]BlockDirectiveHandler
 }
 
-// DirectiveHandler is a function that handles custom directives
+// DirectiveHandler is a function that handles custom directives.
+// Handlers run at render time: args holds the directive arguments as
+// written in the template (e.g. "$ts") and data is the render data. The
+// returned string is escaped like {{ }} output, unless the directive was
+// added with AddRawDirective.
 type DirectiveHandler func(args string, data map[string]interface{}) string
 
+// BlockDirectiveHandler is a function that handles custom block directives
+// (@name(args)...@endname). It runs at compile time: args holds the
+// directive arguments translated to Go template syntax, inner the compiled
+// content and data is always nil. The returned string is substituted into
+// the compiled template, so it may itself contain Go template actions.
+type BlockDirectiveHandler func(args string, inner string, data map[string]interface{}) string
+
+// CSRFResolver returns the CSRF token for the data being rendered
+type CSRFResolver func(data map[string]interface{}) string
+
+// Gate decides whether a permission checked with @allow is granted for
+// the data being rendered
+type Gate func(permission string, data map[string]interface{}) bool
+
+// FeatureResolver reports whether a feature flag checked with @feature is
+// enabled
+type FeatureResolver func(flag string) bool
+
+// RawGuard sanitizes the content of a {!! !!} echo. It returns the content
+// to emit unescaped, or false to have the original content escaped.
+type RawGuard func(content string) (string, bool)
+
 // Option configures the engine
 type Option func(*Engine)
 
 // New creates a new template engine
 func New(viewsPath string, opts ...Option) *Engine {
 	e := &Engine{
-		viewsPath:   viewsPath,
-		extension:   ".legit",
-		cache:       NewTemplateCache(),
-		functions:   DefaultFunctions(),
-		shared:      runtime.NewSharedData(),
-		development: false,
-		directives:  make(map[string]DirectiveHandler),
+		viewsPath:       viewsPath,
+		extension:       ".legit",
+		cache:           NewTemplateCache(),
+		functions:       DefaultFunctions(),
+		userFuncs:       make(map[string]bool),
+		shared:          runtime.NewSharedData(),
+		services:        make(map[string]interface{}),
+		development:     false,
+		csrfField:       "_token",
+		componentsPath:  "components",
+		namespaces:      make(map[string][]string),
+		directives:      make(map[string]DirectiveHandler),
+		rawDirectives:   make(map[string]bool),
+		blockDirectives: make(map[string]BlockDirectiveHandler),
 	}
 
 	for _, opt := range opts {
 		opt(e)
 	}
 
+	if e.fragments == nil {
+		fragments := NewMemoryFragmentCache()
+		if e.clock != nil {
+			fragments.SetClock(e.clock)
+		}
+		e.fragments = fragments
+	}
+
+	e.bindFunctions()
+
 	if e.development {
 		e.cache.Disable()
 	}
@@ -60,14 +139,157 @@ func New(viewsPath string, opts ...Option) *Engine {
 	return e
 }
 
+// bindFunctions registers the template functions bound to the engine
+func (e *Engine) bindFunctions() {
+	e.functions["csrfToken"] = e.csrfToken
+	e.functions["each"] = e.each
+	e.functions["includeFirst"] = e.includeFirst
+	e.functions["shared"] = e.sharedValue
+	e.functions["allow"] = e.allow
+	e.functions["feature"] = e.feature
+	e.functions["sanitizeRaw"] = e.sanitizeRaw
+	e.functions["customDirective"] = e.customDirective
+
+	// Date functions read the injected clock, if any, unless the user
+	// replaced them
+	if e.clock != nil {
+		for name, fn := range clockFunctions(e.clock) {
+			if !e.userFuncs[name] {
+				e.functions[name] = fn
+			}
+		}
+	}
+}
+
+// Clone returns a copy of the engine for per-request or per-tenant
+// variants. The copy has its own shared data, functions, directives and
+// view paths. It compiles its own templates, since compiled templates
+// hold the functions of the engine that compiled them.
+func (e *Engine) Clone() *Engine {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	c := &Engine{
+		viewsPath:       e.viewsPath,
+		extension:       e.extension,
+		extensions:      append([]string(nil), e.extensions...),
+		cache:           e.cache.emptyCopy(),
+		functions:       make(template.FuncMap, len(e.functions)),
+		userFuncs:       make(map[string]bool, len(e.userFuncs)),
+		shared:          e.shared.Clone(),
+		services:        make(map[string]interface{}, len(e.services)),
+		fsys:            e.fsys,
+		development:     e.development,
+		strict:          e.strict,
+		checksum:        e.checksum,
+		collapse:        e.collapse,
+		delims:          e.delims,
+		clock:           e.clock,
+		comments:        e.comments,
+		fragments:       e.fragments,
+		csrfField:       e.csrfField,
+		csrfResolver:    e.csrfResolver,
+		gate:            e.gate,
+		featureResolver: e.featureResolver,
+		componentsPath:  e.componentsPath,
+		rawGuard:        e.rawGuard,
+		paths:           append([]string(nil), e.paths...),
+		namespaces:      make(map[string][]string, len(e.namespaces)),
+		directives:      make(map[string]DirectiveHandler, len(e.directives)),
+		rawDirectives:   make(map[string]bool, len(e.rawDirectives)),
+		blockDirectives: make(map[string]BlockDirectiveHandler, len(e.blockDirectives)),
+	}
+
+	for name, fn := range e.functions {
+		c.functions[name] = fn
+	}
+	for name := range e.userFuncs {
+		c.userFuncs[name] = true
+	}
+	for name, service := range e.services {
+		c.services[name] = service
+	}
+	for namespace, dirs := range e.namespaces {
+		c.namespaces[namespace] = append([]string(nil), dirs...)
+	}
+	for name, handler := range e.directives {
+		c.directives[name] = handler
+	}
+	for name := range e.rawDirectives {
+		c.rawDirectives[name] = true
+	}
+	for name, handler := range e.blockDirectives {
+		c.blockDirectives[name] = handler
+	}
+	c.bindFunctions()
+
+	return c
+}
+
+// WithOverrides returns a clone of the engine with options applied
+func (e *Engine) WithOverrides(opts ...Option) *Engine {
+	c := e.Clone()
+	if len(opts) == 0 {
+		return c
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.bindFunctions()
+
+	if c.development {
+		c.cache.Disable()
+	}
+
+	return c
+}
+
+// templateCache returns the template cache
+func (e *Engine) templateCache() *TemplateCache {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.cache
+}
+
 // WithExtension sets the template file extension
 func WithExtension(ext string) Option {
 	return func(e *Engine) {
-		if !strings.HasPrefix(ext, ".") {
-			ext = "." + ext
+		e.extension = normalizeExtension(ext)
+		e.extensions = nil
+	}
+}
+
+// WithExtensions sets the template file extensions, tried in order when
+// resolving a template name, e.g. ".legit" then ".blade.php"
+func WithExtensions(exts ...string) Option {
+	return func(e *Engine) {
+		if len(exts) == 0 {
+			return
+		}
+		e.extensions = make([]string, len(exts))
+		for i, ext := range exts {
+			e.extensions[i] = normalizeExtension(ext)
 		}
-		e.extension = ext
+		e.extension = e.extensions[0]
+	}
+}
+
+// normalizeExtension adds the leading dot to a file extension
+func normalizeExtension(ext string) string {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
 	}
+	return ext
+}
+
+// templateExtensions returns the template file extensions in the order
+// they are tried
+func (e *Engine) templateExtensions() []string {
+	if len(e.extensions) > 0 {
+		return e.extensions
+	}
+	return []string{e.extension}
 }
 
 // WithDevelopment enables development mode (disables caching)
@@ -77,45 +299,323 @@ func WithDevelopment(dev bool) Option {
 	}
 }
 
+// WithStrictDirectives makes unknown directives a compile error
+// instead of compiling them to a template function call
+func WithStrictDirectives(strict bool) Option {
+	return func(e *Engine) {
+		e.strict = strict
+	}
+}
+
+// WithCollapseWhitespace collapses text between directives and tags that
+// is only whitespace, and trims the newline at the start and end of block
+// @section bodies. Leave it off where exact whitespace matters, such as
+// plain text emails.
+func WithCollapseWhitespace(collapse bool) Option {
+	return func(e *Engine) {
+		e.collapse = collapse
+	}
+}
+
+// WithDelims changes the delimiters of escaped echoes from {{ }}, so
+// templates can leave {{ }} to a JavaScript framework such as Vue:
+//
+//	engine.New("./views", engine.WithDelims("[[", "]]"))
+//
+// {{ }} is then output as text. Comments and {!! !!} are unchanged.
+func WithDelims(left, right string) Option {
+	return func(e *Engine) {
+		e.delims = [2]string{left, right}
+	}
+}
+
+// WithKeepComments renders {{-- --}} comments as HTML comments, which
+// helps when debugging the rendered source. Comments written as
+// {{--! --}} are always rendered.
+func WithKeepComments(keep bool) Option {
+	return func(e *Engine) {
+		e.comments = keep
+	}
+}
+
+// WithCacheLimit limits the number of cached templates, evicting the
+// least recently used ones when the limit is exceeded
+func WithCacheLimit(n int) Option {
+	return func(e *Engine) {
+		e.cache.SetLimit(n)
+	}
+}
+
+// WithChecksumValidation validates cached templates by content checksum
+// instead of trusting modification times
+func WithChecksumValidation(enabled bool) Option {
+	return func(e *Engine) {
+		e.checksum = enabled
+	}
+}
+
+// WithCSRFFieldName sets the input name rendered by @csrf (default: _token)
+func WithCSRFFieldName(name string) Option {
+	return func(e *Engine) {
+		e.csrfField = name
+	}
+}
+
+// WithCSRFTokenResolver sets the function that provides the token rendered
+// by @csrf. By default the token is read from the csrf_token data key.
+func WithCSRFTokenResolver(resolver CSRFResolver) Option {
+	return func(e *Engine) {
+		e.csrfResolver = resolver
+	}
+}
+
+// WithFeatureResolver sets the function that reports whether a feature
+// flag is enabled. Without a resolver every flag is off.
+func WithFeatureResolver(resolver FeatureResolver) Option {
+	return func(e *Engine) {
+		e.featureResolver = resolver
+	}
+}
+
+// WithComponentsPath sets the directory @component templates are loaded
+// from, relative to the views path (default: components). Dotted
+// component names resolve to subdirectories.
+func WithComponentsPath(dir string) Option {
+	return func(e *Engine) {
+		e.componentsPath = dir
+	}
+}
+
+// WithRawGuard runs the content of every {!! !!} echo through guard
+// before it is emitted, e.g. to enforce HTML sanitization
+func WithRawGuard(guard RawGuard) Option {
+	return func(e *Engine) {
+		e.rawGuard = guard
+	}
+}
+
 // WithFunctions adds custom template functions
 func WithFunctions(funcs template.FuncMap) Option {
 	return func(e *Engine) {
 		for name, fn := range funcs {
 			e.functions[name] = fn
+			e.userFuncs[name] = true
 		}
 	}
 }
 
-// AddFunction adds a custom template function
+// AddFunction adds a custom template function.
+// Cached templates are discarded so a replaced function takes effect.
 func (e *Engine) AddFunction(name string, fn interface{}) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 	e.functions[name] = fn
+	e.userFuncs[name] = true
+	e.cache.Clear()
 }
 
-// AddDirective adds a custom directive handler
+// AddFunctions adds multiple custom template functions.
+// Cached templates are discarded so replaced functions take effect.
+func (e *Engine) AddFunctions(funcs template.FuncMap) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for name, fn := range funcs {
+		e.functions[name] = fn
+		e.userFuncs[name] = true
+	}
+	e.cache.Clear()
+}
+
+// RemoveFunction removes a template function, including built-ins.
+// Cached templates are discarded since they were parsed with the function bound.
+func (e *Engine) RemoveFunction(name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.functions, name)
+	e.userFuncs[name] = true
+	e.cache.Clear()
+}
+
+// HasFunction checks if a template function is registered
+func (e *Engine) HasFunction(name string) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	_, ok := e.functions[name]
+	return ok
+}
+
+// FunctionNames returns the sorted names of the registered template
+// functions, including functions added with AddFunction
+func (e *Engine) FunctionNames() []string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	names := make([]string, 0, len(e.functions))
+	for name := range e.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// funcMap returns a snapshot of the registered template functions
+func (e *Engine) funcMap() template.FuncMap {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	funcs := make(template.FuncMap, len(e.functions))
+	for name, fn := range e.functions {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// AddDirective adds a custom directive handler whose output is escaped.
+// A directive with the name of a built-in one replaces it. Cached
+// templates are discarded so the directive applies on next render.
 func (e *Engine) AddDirective(name string, handler DirectiveHandler) {
+	e.addDirective(name, handler, false)
+}
+
+// AddRawDirective adds a custom directive handler whose output is trusted
+// HTML and written without escaping, like {!! !!}
+func (e *Engine) AddRawDirective(name string, handler DirectiveHandler) {
+	e.addDirective(name, handler, true)
+}
+
+// addDirective registers a directive handler and whether its output is raw
+func (e *Engine) addDirective(name string, handler DirectiveHandler, raw bool) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 	e.directives[name] = handler
+	if raw {
+		e.rawDirectives[name] = true
+	} else {
+		delete(e.rawDirectives, name)
+	}
+	e.cache.Clear()
 }
 
-// Share adds data that will be available to all templates
+// AddBlockDirective adds a custom block directive handler.
+// The directive's content is collected until @end<name>. A directive with
+// the name of a built-in one replaces it.
+func (e *Engine) AddBlockDirective(name string, handler BlockDirectiveHandler) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.blockDirectives[name] = handler
+	e.cache.Clear()
+}
+
+// Share adds data that will be available to all templates, both at the
+// top level of the data, where call data with the same key wins, and
+// through $shared and the shared function
 func (e *Engine) Share(key string, value interface{}) {
 	e.shared.Set(key, value)
 }
 
+// SetGate sets the permission checker used by @allow. Without a gate
+// every permission is denied.
+func (e *Engine) SetGate(gate Gate) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.gate = gate
+}
+
+// allow checks a permission with the gate
+func (e *Engine) allow(data interface{}, permission string) bool {
+	e.mutex.RLock()
+	gate := e.gate
+	e.mutex.RUnlock()
+
+	if gate == nil {
+		return false
+	}
+	d, _ := data.(map[string]interface{})
+	return gate(permission, d)
+}
+
+// feature checks a feature flag with the resolver
+func (e *Engine) feature(flag string) bool {
+	if e.featureResolver == nil {
+		return false
+	}
+	return e.featureResolver(flag)
+}
+
+// sanitizeRaw emits raw content through the raw guard, escaping content
+// the guard rejects
+func (e *Engine) sanitizeRaw(v interface{}) template.HTML {
+	content := string(raw(v))
+	if e.rawGuard == nil {
+		return template.HTML(content)
+	}
+	if sanitized, ok := e.rawGuard(content); ok {
+		return template.HTML(sanitized)
+	}
+	return template.HTML(template.HTMLEscapeString(content))
+}
+
+// sharedValue returns a value added with Share, ignoring call data
+func (e *Engine) sharedValue(key string) interface{} {
+	return e.shared.Get(key)
+}
+
 // Render renders a template to the given writer
 func (e *Engine) Render(w io.Writer, name string, data interface{}) error {
-	tmpl, err := e.getTemplate(name)
+	return e.render(w, name, data, nil)
+}
+
+// render renders a template with optional request-local data
+func (e *Engine) render(w io.Writer, name string, data interface{}, local map[string]interface{}) (err error) {
+	defer recoverPanic(name, &err)
+
+	cached, err := e.getTemplate(name)
 	if err != nil {
 		return err
 	}
 
+	return e.execute(w, cached, data, local)
+}
+
+// execute renders a parsed template, filling in stacks and removing
+// whitespace in @spaceless blocks
+func (e *Engine) execute(w io.Writer, cached *CachedTemplate, data interface{}, local map[string]interface{}) error {
+	data, err := decodeData(data)
+	if err != nil {
+		return err
+	}
+
+	// Stacks are filled in by the outermost render, once every nested
+	// render has pushed its content
+	nested := stacksFrom(data) != nil
+
 	// Prepare data
-	renderData := e.prepareData(data)
+	renderData := e.prepareData(data, local)
+
+	var buf bytes.Buffer
+	if err := cached.Template.Execute(&buf, renderData); err != nil {
+		return sourceError(err, cached.sources.lookup)
+	}
+
+	out := buf.String()
+	if !nested {
+		out = processStacks(out, renderData)
+	}
 
-	return tmpl.Execute(w, renderData)
+	_, err = io.WriteString(w, processSpaceless(out))
+	return err
+}
+
+// RenderContext renders a template with the data of a runtime.Context.
+// Its validation errors are available as $errors (and to @error), its old
+// input to @old and its flash messages to @flash, replacing any "errors",
+// "old" or "flash" data values.
+func (e *Engine) RenderContext(w io.Writer, name string, ctx *runtime.Context) error {
+	data := ctx.Data()
+	data["errors"] = ctx.GetErrors()
+	data["old"] = ctx.OldInput()
+	data["flash"] = ctx.FlashMessages()
+	return e.render(w, name, data, nil)
 }
 
 // RenderString renders a template and returns the result as a string
@@ -125,105 +625,314 @@ func (e *Engine) RenderString(name string, data interface{}) (string, error) {
 	return buf.String(), err
 }
 
+// RenderEach renders the partial once per item of a slice, array or map,
+// writing each to w in turn. Each render receives data with the item bound
+// to varName and its index or map key bound to "key", like @each, unless
+// data has a "key" of its own. Maps are visited in key order; other values
+// render nothing.
+func (e *Engine) RenderEach(w io.Writer, partial string, items interface{}, varName string, data map[string]interface{}) error {
+	switch reflect.Indirect(reflect.ValueOf(items)).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+	default:
+		return nil
+	}
+
+	_, ownKey := data["key"]
+	for _, pair := range runtime.Iterate(items) {
+		itemData := make(map[string]interface{}, len(data)+2)
+		for k, v := range data {
+			itemData[k] = v
+		}
+		itemData[varName] = pair.Value
+		if !ownKey {
+			itemData["key"] = pair.Key
+		}
+
+		if err := e.Render(w, partial, itemData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderResponse renders a template and returns the body together with the
+// status code and headers it declared with @status and @header. A
+// "__status" value in the data sets the initial status code.
+func (e *Engine) RenderResponse(name string, data interface{}) (*runtime.Response, error) {
+	data, err := decodeData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := runtime.NewResponse()
+	if d, ok := data.(map[string]interface{}); ok && d["__status"] != nil {
+		resp.Status = toInt(d["__status"])
+	}
+
+	var buf bytes.Buffer
+	if err := e.render(&buf, name, data, map[string]interface{}{responseKey: resp}); err != nil {
+		return nil, err
+	}
+	resp.Body = buf.String()
+
+	return resp, nil
+}
+
+// RenderToResponse renders a template and writes it to w with the status
+// code and headers the template declared. Nothing is written on error.
+func (e *Engine) RenderToResponse(w http.ResponseWriter, name string, data interface{}) error {
+	resp, err := e.RenderResponse(name, data)
+	if err != nil {
+		return err
+	}
+	return resp.Write(w)
+}
+
 // RenderTemplate renders a template string directly (not from file)
 func (e *Engine) RenderTemplate(templateStr string, data interface{}) (string, error) {
-	compiled, err := e.compileString(templateStr)
+	return e.renderTemplate(templateStr, data, nil)
+}
+
+// renderTemplate renders a template string with optional request-local data
+func (e *Engine) renderTemplate(templateStr string, data interface{}, local map[string]interface{}) (out string, err error) {
+	defer recoverPanic("inline", &err)
+
+	compiled, _, err := e.compile("inline", templateStr)
 	if err != nil {
 		return "", err
 	}
 
-	tmpl, err := template.New("inline").Funcs(e.functions).Parse(compiled)
+	sources := make(sourceMaps)
+	tmpl, err := parseCompiled(e.newTemplate("inline"), compiled, sources)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse compiled template: %w", err)
 	}
+	if err := e.associatePartials(tmpl, compiled, map[string]bool{"inline": true}, nil, sources); err != nil {
+		return "", err
+	}
 
-	renderData := e.prepareData(data)
+	data, err = decodeData(data)
+	if err != nil {
+		return "", err
+	}
+
+	renderData := e.prepareData(data, local)
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, renderData); err != nil {
+		err = sourceError(err, sources.lookup)
 		return "", fmt.Errorf("failed to execute template: %w", err)
 	}
 
-	return buf.String(), nil
+	return processSpaceless(processStacks(buf.String(), renderData)), nil
 }
 
 // ClearCache clears the template cache
 func (e *Engine) ClearCache() {
-	e.cache.Clear()
+	e.templateCache().Clear()
+}
+
+// CacheStats returns template cache hit/miss counters
+func (e *Engine) CacheStats() CacheStats {
+	return e.templateCache().Stats()
 }
 
 // getTemplate retrieves or compiles a template
-func (e *Engine) getTemplate(name string) (*template.Template, error) {
-	filePath := e.resolvePath(name)
+func (e *Engine) getTemplate(name string) (*CachedTemplate, error) {
+	name = normalizeName(name)
+	filePath, err := e.resolvePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.cachedTemplate(name, filePath, e.compileFile)
+}
+
+// cachedTemplate retrieves the template cached under key, compiling
+// filePath with compileFn when it is missing or stale
+func (e *Engine) cachedTemplate(key, filePath string, compileFn func(key, filePath string) (*CachedTemplate, error)) (*CachedTemplate, error) {
+	cache := e.templateCache()
 
 	// Check cache
-	if cached, ok := e.cache.Get(name); ok {
-		if e.cache.IsValid(name, filePath) {
-			return cached.Template, nil
+	if cached, ok := cache.Get(key); ok {
+		if fresh, ok := e.isCacheValid(cached, filePath); ok {
+			if fresh != cached {
+				cache.refresh(key, cached, fresh)
+			}
+			cache.RecordHit()
+			return cached, nil
 		}
 	}
+	cache.RecordMiss()
 
 	// Compile template
-	tmpl, modTime, err := e.compileFile(name, filePath)
+	compiled, err := compileFn(key, filePath)
 	if err != nil {
 		return nil, err
 	}
 
 	// Cache compiled template
-	content, _ := os.ReadFile(filePath)
-	e.cache.Set(name, tmpl, modTime, Checksum(content))
+	cache.store(key, compiled)
 
-	return tmpl, nil
+	return compiled, nil
 }
 
-// compileFile compiles a template file
-func (e *Engine) compileFile(name, filePath string) (*template.Template, time.Time, error) {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("failed to read template %s: %w", name, err)
+// newCachedTemplate creates the cache entry for a template set compiled
+// from filePath
+func newCachedTemplate(tmpl *template.Template, filePath string, deps map[string]Dependency, sources sourceMaps) *CachedTemplate {
+	return &CachedTemplate{
+		Template:     tmpl,
+		ModTime:      deps[filePath].ModTime,
+		Checksum:     deps[filePath].Checksum,
+		Dependencies: deps,
+		sources:      sources,
+	}
+}
+
+// isCacheValid checks if a cached template is still fresh: the template
+// file and every layout and partial compiled into it must be unchanged.
+// When files were only touched, a copy of cached recording their new
+// modification times is returned, so later checks skip rereading them.
+func (e *Engine) isCacheValid(cached *CachedTemplate, filePath string) (*CachedTemplate, bool) {
+	deps := cached.Dependencies
+	if deps == nil {
+		deps = map[string]Dependency{filePath: {ModTime: cached.ModTime, Checksum: cached.Checksum}}
+	}
+
+	var touched map[string]Dependency
+	for path, dep := range deps {
+		current, ok := e.dependencyValid(path, dep)
+		if !ok {
+			return nil, false
+		}
+		if current != dep {
+			if touched == nil {
+				touched = make(map[string]Dependency, len(deps))
+				for p, d := range deps {
+					touched[p] = d
+				}
+			}
+			touched[path] = current
+		}
 	}
+	if touched == nil {
+		return cached, true
+	}
+
+	fresh := *cached
+	fresh.Dependencies = touched
+	fresh.ModTime = touched[filePath].ModTime
+	return &fresh, true
+}
 
-	info, err := os.Stat(filePath)
+// dependencyValid checks if a file is unchanged since it was compiled.
+// Checksums are compared when checksum validation is enabled, when the
+// file system has no modification times (e.g. embed.FS) or when the
+// modification time changed, so touching a file does not force a
+// recompile. An optional include that did not exist must still be missing.
+// The dependency is returned with the current modification time.
+func (e *Engine) dependencyValid(path string, dep Dependency) (Dependency, bool) {
+	info, err := e.statFile(path)
 	if err != nil {
-		return nil, time.Time{}, err
+		return dep, dep == Dependency{}
+	}
+
+	modTime := info.ModTime()
+	if !e.checksum && !modTime.IsZero() && modTime.Equal(dep.ModTime) {
+		return dep, true
+	}
+
+	content, err := e.readFile(path)
+	if err != nil || Checksum(content) != dep.Checksum {
+		return dep, false
+	}
+	dep.ModTime = modTime
+	return dep, true
+}
+
+// recordDependency adds a file compiled into a template to deps, if deps
+// is not nil
+func (e *Engine) recordDependency(deps map[string]Dependency, path string, content []byte) error {
+	if deps == nil {
+		return nil
 	}
 
-	compiled, extendsTemplate, sections, err := e.compile(string(content))
+	info, err := e.statFile(path)
 	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("failed to compile template %s: %w", name, err)
+		return err
 	}
+	deps[path] = Dependency{ModTime: info.ModTime(), Checksum: Checksum(content)}
+	return nil
+}
 
-	// Handle template inheritance
-	if extendsTemplate != "" {
-		return e.compileWithInheritance(name, compiled, extendsTemplate, sections)
+// compileFile compiles a template file together with the partials and
+// components it includes, recording every file compiled into it
+func (e *Engine) compileFile(name, filePath string) (*CachedTemplate, error) {
+	deps := make(map[string]Dependency)
+	compiled, err := e.compileSource(name, filePath, deps)
+	if err != nil {
+		return nil, err
 	}
 
-	tmpl, err := template.New(name).Funcs(e.functions).Parse(compiled)
+	sources := make(sourceMaps)
+	tmpl, err := parseCompiled(e.newTemplate(name), compiled, sources)
 	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("failed to parse compiled template %s: %w", name, err)
+		return nil, fmt.Errorf("failed to parse compiled template %s: %w", name, err)
 	}
 
-	return tmpl, info.ModTime(), nil
+	if err := e.associatePartials(tmpl, compiled, map[string]bool{name: true}, deps, sources); err != nil {
+		return nil, err
+	}
+
+	return newCachedTemplate(tmpl, filePath, deps, sources), nil
 }
 
-// compileWithInheritance handles @extends directive
-func (e *Engine) compileWithInheritance(name, childCompiled, parentName string, childSections map[string]string) (*template.Template, time.Time, error) {
-	parentPath := e.resolvePath(parentName)
-	parentContent, err := os.ReadFile(parentPath)
+// compileSource compiles a template file, including the layouts it
+// extends, to Go template source. The files read are added to deps.
+func (e *Engine) compileSource(name, filePath string, deps map[string]Dependency) (string, error) {
+	content, err := e.readFile(filePath)
 	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("failed to read parent template %s: %w", parentName, err)
+		return "", fmt.Errorf("failed to read template %s: %w", name, err)
+	}
+	if err := e.recordDependency(deps, filePath, content); err != nil {
+		return "", err
 	}
 
-	parentInfo, err := os.Stat(parentPath)
+	compiled, c, err := e.compile(name, string(content))
 	if err != nil {
-		return nil, time.Time{}, err
+		return "", fmt.Errorf("failed to compile template %s: %w", name, &templateSourceError{name: name, source: string(content), err: err})
 	}
 
-	parentCompiled, parentExtends, parentSections, err := e.compile(string(parentContent))
+	// Handle template inheritance
+	if c.GetExtends() != "" {
+		return e.compileWithInheritance(name, compiled, c.GetExtendsData(), c.GetExtends(), c.GetSections(), deps)
+	}
+
+	return compiled, nil
+}
+
+// compileWithInheritance handles @extends directive. childData holds the
+// statements merging the data passed with @extends, which run before the
+// outermost layout's content; data passed by a child overrides data its
+// parent passes further up. The layouts read are added to deps.
+func (e *Engine) compileWithInheritance(name, childCompiled, childData, parentName string, childSections map[string]string, deps map[string]Dependency) (string, error) {
+	parentPath, err := e.resolvePath(parentName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read parent template %s: %w", parentName, err)
+	}
+	parentContent, err := e.readFile(parentPath)
 	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("failed to compile parent template %s: %w", parentName, err)
+		return "", fmt.Errorf("failed to read parent template %s: %w", parentName, err)
+	}
+	if err := e.recordDependency(deps, parentPath, parentContent); err != nil {
+		return "", err
 	}
 
+	parentCompiled, pc, err := e.compile(parentName, string(parentContent))
+	if err != nil {
+		return "", fmt.Errorf("failed to compile parent template %s: %w", parentName, &templateSourceError{name: parentName, source: string(parentContent), err: err})
+	}
+	parentSections := pc.GetSections()
+
 	// Merge sections (child overrides parent)
 	for name, content := range parentSections {
 		if _, ok := childSections[name]; !ok {
@@ -278,159 +987,505 @@ func (e *Engine) compileWithInheritance(name, childCompiled, parentName string,
 		}
 	}
 
+	// Keep the child's slot definitions, which its sections refer to
+	parentCompiled += childCompiled
+
 	// If parent also extends another template, recurse
-	if parentExtends != "" {
-		return e.compileWithInheritance(name, parentCompiled, parentExtends, childSections)
+	if parentExtends := pc.GetExtends(); parentExtends != "" {
+		return e.compileWithInheritance(name, parentCompiled, pc.GetExtendsData()+childData, parentExtends, childSections, deps)
 	}
 
-	tmpl, err := template.New(name).Funcs(e.functions).Parse(parentCompiled)
-	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("failed to parse compiled template %s: %w", name, err)
-	}
+	return childData + parentCompiled, nil
+}
 
-	return tmpl, parentInfo.ModTime(), nil
+// compile compiles template content, returning the compiler for its
+// @extends and @section details. The output carries source marks naming
+// source and must be parsed with parseCompiled.
+func (e *Engine) compile(source, content string) (string, *compiler.Compiler, error) {
+	return e.compileWith(source, content, false)
 }
 
-// compile compiles template content
-func (e *Engine) compile(content string) (string, string, map[string]string, error) {
+// compileWith compiles template content. With inlineSections, a child
+// template compiles to its own sections in place instead of the parts its
+// layout needs.
+func (e *Engine) compileWith(source, content string, inlineSections bool) (string, *compiler.Compiler, error) {
 	// Tokenize
-	lex := lexer.New(content)
+	lex := e.newLexer(content)
+	lex.SetMarkWhitespace(e.collapse)
 	tokens, err := lex.Tokenize()
 	if err != nil {
-		return "", "", nil, fmt.Errorf("lexer error: %w", err)
+		return "", nil, fmt.Errorf("lexer error: %w", err)
 	}
 
 	// Parse
 	p := parser.New(tokens)
+	e.configureParser(p)
 	ast, err := p.Parse()
 	if err != nil {
-		return "", "", nil, fmt.Errorf("parser error: %w", err)
+		return "", nil, fmt.Errorf("parser error: %w", err)
 	}
 
 	// Compile
 	c := compiler.New()
+	c.SetCSRFField(e.csrfField)
+	c.SetComponentsPath(e.componentsPath)
+	c.SetCollapseWhitespace(e.collapse)
+	c.SetKeepComments(e.comments)
+	c.SetRawGuard(e.rawGuard != nil)
+	c.SetInlineSections(inlineSections)
+	c.SetSourceMarks(source)
+	e.registerDirectives(c)
 	compiled, err := c.Compile(ast)
 	if err != nil {
-		return "", "", nil, fmt.Errorf("compiler error: %w", err)
+		return "", nil, fmt.Errorf("compiler error: %w", err)
 	}
 
-	// Add stack function
-	compiled = e.processStacks(compiled, c)
+	// A child template only contributes its sections, stack pushes and
+	// definitions
+	if c.GetExtends() != "" && !inlineSections {
+		compiled = c.GetPushes() + c.GetDefinitions()
+	}
 
-	return compiled, c.GetExtends(), c.GetSections(), nil
+	return compiled, c, nil
+}
+
+// newTemplate creates a template with the registered functions and the
+// renderSlot, cacheFragment and templateExists functions bound to the new
+// template set
+func (e *Engine) newTemplate(name string) *template.Template {
+	tmpl := template.New(name).Funcs(e.funcMap())
+	tmpl.Funcs(template.FuncMap{
+		"templateExists": func(name string) bool {
+			return tmpl.Lookup(name) != nil
+		},
+		"renderSlot": func(slot string, data interface{}) (template.HTML, error) {
+			var buf bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&buf, slot, data); err != nil {
+				return "", err
+			}
+			return template.HTML(buf.String()), nil
+		},
+		"cacheFragment": func(key, ttl interface{}, vary map[string]interface{}, slot string, data interface{}) (template.HTML, error) {
+			return e.cacheFragment(tmpl, key, ttl, vary, slot, data)
+		},
+	})
+	return tmpl
+}
+
+// Tokens returns the token stream of a template, with positions, for
+// debugging and editor tooling
+func (e *Engine) Tokens(name string) ([]lexer.Token, error) {
+	filePath, err := e.resolvePath(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := e.readFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template %s: %w", name, err)
+	}
+
+	tokens, err := e.newLexer(string(content)).Tokenize()
+	if err != nil {
+		return nil, fmt.Errorf("lexer error: %w", err)
+	}
+	return tokens, nil
+}
+
+// newLexer creates a lexer with the engine's echo delimiters
+func (e *Engine) newLexer(content string) *lexer.Lexer {
+	e.mutex.RLock()
+	delims := e.delims
+	e.mutex.RUnlock()
+
+	lex := lexer.New(content)
+	lex.SetDelims(delims[0], delims[1])
+	return lex
+}
+
+// SetDelims changes the delimiters of escaped echoes like WithDelims.
+// Cached templates are discarded so the delimiters apply on next render.
+func (e *Engine) SetDelims(left, right string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.delims = [2]string{left, right}
+	e.cache.Clear()
+}
+
+// Lint checks a template for unbalanced and unknown directives without
+// compiling it
+func (e *Engine) Lint(name string) ([]parser.LintIssue, error) {
+	tokens, err := e.Tokens(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(tokens)
+	e.configureParser(p)
+	return p.Lint(e.HasFunction), nil
+}
+
+// registerDirectives registers custom directive handlers with the compiler
+func (e *Engine) registerDirectives(c *compiler.Compiler) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	for name := range e.directives {
+		c.RegisterRuntimeDirective(name)
+	}
+
+	for name, handler := range e.blockDirectives {
+		handler := handler
+		c.RegisterBlockDirective(name, func(args, inner string) string {
+			return handler(args, inner, nil)
+		})
+	}
+}
+
+// configureParser tells the parser about custom directives and strict mode
+func (e *Engine) configureParser(p *parser.Parser) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	p.SetStrict(e.strict)
+	for name := range e.directives {
+		p.RegisterDirective(name)
+	}
+	for name := range e.blockDirectives {
+		p.RegisterBlockDirective(name)
+	}
 }
 
 // compileString compiles a template string
 func (e *Engine) compileString(content string) (string, error) {
-	compiled, _, _, err := e.compile(content)
-	return compiled, err
+	compiled, _, err := e.compile("inline", content)
+	if err != nil {
+		return "", err
+	}
+	compiled, _ = compiler.StripMarks(compiled)
+	return compiled, nil
 }
 
-// processStacks replaces @stack placeholders with actual content
-func (e *Engine) processStacks(compiled string, c *compiler.Compiler) string {
-	// This is a simple implementation - real implementation would be more sophisticated
-	// to handle runtime stack evaluation
+// decodeData decodes render data passed as JSON bytes into a map
+func decodeData(data interface{}) (interface{}, error) {
+	var raw []byte
+	switch d := data.(type) {
+	case []byte:
+		raw = d
+	case json.RawMessage:
+		raw = d
+	default:
+		return data, nil
+	}
 
-	// Add stack function that returns empty string (stacks are evaluated at runtime)
-	return compiled
+	var result map[string]interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, &EngineError{Message: fmt.Sprintf("render data must be a JSON object: %v", err)}
+	}
+	if result == nil {
+		return nil, &EngineError{Message: "render data must be a JSON object, got null"}
+	}
+	return result, nil
 }
 
 // prepareData prepares the render data
-func (e *Engine) prepareData(data interface{}) map[string]interface{} {
+// Values are layered as: defaults < shared < request-local < call data
+func (e *Engine) prepareData(data interface{}, local map[string]interface{}) map[string]interface{} {
 	result := make(map[string]interface{})
 
-	// Add shared data
-	for k, v := range e.shared.All() {
-		result[k] = v
-	}
+	// Add shared data and provided services
+	MergeData(result, e.shared.All())
+	MergeData(result, e.providedServices())
+
+	// Add request-local data
+	MergeData(result, local)
+	_, ownShared := local["shared"]
 
 	// Merge provided data
 	if data != nil {
 		switch d := data.(type) {
 		case map[string]interface{}:
-			for k, v := range d {
-				result[k] = v
-			}
+			MergeData(result, d)
+			_, ok := d["shared"]
+			ownShared = ownShared || ok
 		case map[string]string:
 			for k, v := range d {
 				result[k] = v
 			}
+			_, ok := d["shared"]
+			ownShared = ownShared || ok
 		}
 	}
 
-	// Add stack function
-	result["__stacks"] = make(map[string][]string)
+	// Shared values stay reachable when call data uses the same key,
+	// unless the call data has a "shared" key of its own
+	if !ownShared {
+		result["shared"] = e.shared.All()
+	}
+
+	// Content pushed to stacks during the render. Nested renders such as
+	// @includeFirst receive the caller's data and share its stacks.
+	if _, ok := result[stacksKey].(map[string][]string); !ok {
+		result[stacksKey] = make(map[string][]string)
+	}
+
+	// Expose validation errors as $errors bag
+	if errors, ok := result["errors"].(map[string][]string); ok {
+		result["errors"] = runtime.NewErrorBag(errors)
+	}
 
 	return result
 }
 
-// resolvePath resolves template name to file path
-func (e *Engine) resolvePath(name string) string {
-	// Replace dots with path separator
-	name = strings.ReplaceAll(name, ".", string(filepath.Separator))
+// csrfToken resolves the CSRF token for the root render data
+func (e *Engine) csrfToken(data interface{}) string {
+	d, _ := data.(map[string]interface{})
+	if e.csrfResolver != nil {
+		return e.csrfResolver(d)
+	}
+	if token, ok := d["csrf_token"]; ok && token != nil {
+		return fmt.Sprint(token)
+	}
+	return ""
+}
 
-	// Add extension if not present
-	if !strings.HasSuffix(name, e.extension) {
-		name = name + e.extension
+// customDirective runs the handler of a custom directive with the render
+// data. The output is a string, so the template escapes it, or
+// template.HTML for raw directives. A panicking handler is returned as a
+// handlerPanic error.
+func (e *Engine) customDirective(name, args string, data interface{}) (out interface{}, err error) {
+	e.mutex.RLock()
+	handler, ok := e.directives[name]
+	raw := e.rawDirectives[name]
+	e.mutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown directive @%s", name)
 	}
 
-	return filepath.Join(e.viewsPath, name)
+	defer func() {
+		if r := recover(); r != nil {
+			out, err = "", &handlerPanic{value: r}
+		}
+	}()
+
+	d, _ := data.(map[string]interface{})
+	result := handler(args, d)
+	if raw {
+		return template.HTML(result), nil
+	}
+	return result, nil
 }
 
-// Exists checks if a template exists
-func (e *Engine) Exists(name string) bool {
-	filePath := e.resolvePath(name)
-	_, err := os.Stat(filePath)
-	return err == nil
+// each renders the view once per item with the item bound to itemVar and
+// its index or map key bound to "key". When items is empty the empty view
+// is rendered instead, if given.
+func (e *Engine) each(view string, items interface{}, itemVar, emptyView string) (template.HTML, error) {
+	var buf strings.Builder
+
+	renderItem := func(key, item interface{}) error {
+		out, err := e.RenderString(view, map[string]interface{}{itemVar: item, "key": key})
+		buf.WriteString(out)
+		return err
+	}
+
+	rv := reflect.ValueOf(items)
+	count := 0
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		count = rv.Len()
+		for i := 0; i < count; i++ {
+			if err := renderItem(i, rv.Index(i).Interface()); err != nil {
+				return "", err
+			}
+		}
+	case reflect.Map:
+		keys := rv.MapKeys()
+		count = len(keys)
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			if err := renderItem(key.Interface(), rv.MapIndex(key).Interface()); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if count == 0 && emptyView != "" {
+		out, err := e.RenderString(emptyView, nil)
+		return template.HTML(out), err
+	}
+
+	return template.HTML(buf.String()), nil
 }
 
-// Load pre-compiles all templates in the views directory
-func (e *Engine) Load() error {
-	return filepath.Walk(e.viewsPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// includeFirst renders the first of the given views that exists
+func (e *Engine) includeFirst(views interface{}, data interface{}) (template.HTML, error) {
+	var names []string
+	rv := reflect.ValueOf(views)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			names = append(names, fmt.Sprint(rv.Index(i).Interface()))
 		}
+	case reflect.String:
+		names = append(names, rv.String())
+	}
 
-		if info.IsDir() {
-			return nil
+	for _, name := range names {
+		if e.Exists(name) {
+			out, err := e.RenderString(name, data)
+			return template.HTML(out), err
 		}
+	}
 
-		if !strings.HasSuffix(path, e.extension) {
-			return nil
+	return "", &EngineError{Message: fmt.Sprintf("none of the views [%s] exist", strings.Join(names, ", "))}
+}
+
+// MergeData merges src into dst. Nested map[string]interface{} values
+// present on both sides are merged recursively, with src winning on leaf
+// conflicts. Nested maps are copied rather than modified in place.
+func MergeData(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			if dstMap, ok := dst[k].(map[string]interface{}); ok {
+				merged := make(map[string]interface{}, len(dstMap)+len(srcMap))
+				MergeData(merged, dstMap)
+				MergeData(merged, srcMap)
+				dst[k] = merged
+				continue
+			}
 		}
+		dst[k] = v
+	}
+}
 
-		// Get template name from path
-		name := strings.TrimPrefix(path, e.viewsPath+string(filepath.Separator))
-		name = strings.TrimSuffix(name, e.extension)
-		name = strings.ReplaceAll(name, string(filepath.Separator), ".")
+// NamespaceSeparator separates a view namespace from the template name
+const NamespaceSeparator = "::"
 
-		// Compile and cache
-		_, err = e.getTemplate(name)
-		return err
-	})
+// AddNamespace registers a directory for templates referenced as
+// "namespace::name". Registering a namespace again adds a fallback
+// directory searched after the earlier ones.
+func (e *Engine) AddNamespace(namespace, dir string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.namespaces[namespace] = append(e.namespaces[namespace], dir)
+	e.cache.Clear()
 }
 
-// Templates returns all available template names
-func (e *Engine) Templates() ([]string, error) {
-	var templates []string
+// AddPath adds a fallback directory for templates without a namespace.
+// Directories are searched in order, starting with the views path.
+func (e *Engine) AddPath(dir string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.paths = append(e.paths, dir)
+	e.cache.Clear()
+}
 
-	err := filepath.Walk(e.viewsPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// searchPaths returns the directories searched for a namespace
+func (e *Engine) searchPaths(namespace string) []string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
 
-		if info.IsDir() {
-			return nil
+	if namespace == "" {
+		return append([]string{e.viewsPath}, e.paths...)
+	}
+	return append([]string(nil), e.namespaces[namespace]...)
+}
+
+// splitNamespace splits "namespace::name" into its parts
+func splitNamespace(name string) (string, string) {
+	if idx := strings.Index(name, NamespaceSeparator); idx != -1 {
+		return name[:idx], name[idx+len(NamespaceSeparator):]
+	}
+	return "", name
+}
+
+// normalizeName converts the / separators of a template name, as Fiber
+// handlers pass them, to the dots Templates returns, so both forms share a
+// cache entry
+func normalizeName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// resolvePath resolves template name to file path.
+// The first search path containing the template wins, trying each
+// extension in order; if none does, the path under the first search path
+// with the first extension is returned. A namespace that was never
+// registered is an error.
+func (e *Engine) resolvePath(name string) (string, error) {
+	namespace, name := splitNamespace(normalizeName(name))
+
+	// Replace dots with path separator
+	name = strings.ReplaceAll(name, ".", e.separator())
+
+	// Add extension if not present
+	var files []string
+	for _, ext := range e.templateExtensions() {
+		if strings.HasSuffix(name, ext) {
+			files = []string{name}
+			break
 		}
+		files = append(files, name+ext)
+	}
+
+	dirs := e.searchPaths(namespace)
+	if len(dirs) == 0 {
+		return "", fmt.Errorf("namespace %q not registered", namespace)
+	}
 
-		if !strings.HasSuffix(path, e.extension) {
-			return nil
+	for _, dir := range dirs {
+		for _, file := range files {
+			path := e.joinPath(dir, file)
+			if _, err := e.statFile(path); err == nil {
+				return path, nil
+			}
 		}
+	}
 
-		name := strings.TrimPrefix(path, e.viewsPath+string(filepath.Separator))
-		name = strings.TrimSuffix(name, e.extension)
-		name = strings.ReplaceAll(name, string(filepath.Separator), ".")
+	return e.joinPath(dirs[0], files[0]), nil
+}
+
+// Exists checks if a template exists
+func (e *Engine) Exists(name string) bool {
+	filePath, err := e.resolvePath(name)
+	if err != nil {
+		return false
+	}
+	_, err = e.statFile(filePath)
+	return err == nil
+}
 
+// ModTime returns the modification time of a template file. It is zero
+// when the file system has no modification times, such as embed.FS.
+func (e *Engine) ModTime(name string) (time.Time, error) {
+	filePath, err := e.resolvePath(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := e.statFile(filePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Load pre-compiles all templates in the views directories
+func (e *Engine) Load() error {
+	return e.walkTemplates(e.Compile)
+}
+
+// Compile compiles and caches a template without rendering it, so syntax
+// errors surface without data the template needs
+func (e *Engine) Compile(name string) error {
+	_, err := e.getTemplate(name)
+	return err
+}
+
+// Templates returns all available template names
+func (e *Engine) Templates() ([]string, error) {
+	var templates []string
+
+	err := e.walkTemplates(func(name string) error {
 		templates = append(templates, name)
 		return nil
 	})
@@ -438,6 +1493,57 @@ func (e *Engine) Templates() ([]string, error) {
 	return templates, err
 }
 
+// walkTemplates calls fn for each template name found in the search paths
+// of every namespace. Templates shadowed by an earlier path are skipped.
+func (e *Engine) walkTemplates(fn func(name string) error) error {
+	e.mutex.RLock()
+	namespaces := make([]string, 0, len(e.namespaces))
+	for namespace := range e.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	e.mutex.RUnlock()
+	sort.Strings(namespaces)
+
+	seen := make(map[string]bool)
+	for _, namespace := range append([]string{""}, namespaces...) {
+		prefix := ""
+		if namespace != "" {
+			prefix = namespace + NamespaceSeparator
+		}
+
+		for _, dir := range e.searchPaths(namespace) {
+			err := e.walkFiles(dir, func(rel string) error {
+				ext := ""
+				for _, candidate := range e.templateExtensions() {
+					if strings.HasSuffix(rel, candidate) {
+						ext = candidate
+						break
+					}
+				}
+				if ext == "" {
+					return nil
+				}
+
+				// Get template name from path
+				name := strings.TrimSuffix(rel, ext)
+				name = prefix + strings.ReplaceAll(name, e.separator(), ".")
+
+				if seen[name] {
+					return nil
+				}
+				seen[name] = true
+
+				return fn(name)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // EngineError represents a template engine error
 type EngineError struct {
 	Message  string
@@ -445,12 +1551,44 @@ type EngineError struct {
 	Line     int
 	Column   int
 	Near     string
+	Err      error // Underlying error, if any
 }
 
 func (e *EngineError) Error() string {
+	if e.Template != "" && e.Line == 0 {
+		return fmt.Sprintf("%s in %s", e.Message, e.Template)
+	}
 	if e.Template != "" {
 		return fmt.Sprintf("%s in %s at line %d, column %d\n%s",
 			e.Message, e.Template, e.Line, e.Column, e.Near)
 	}
 	return e.Message
 }
+
+// Unwrap returns the underlying error
+func (e *EngineError) Unwrap() error {
+	return e.Err
+}
+
+// handlerPanic is a panic recovered from a custom directive handler while
+// the template was executing
+type handlerPanic struct {
+	value interface{}
+}
+
+func (p *handlerPanic) Error() string {
+	return fmt.Sprintf("panic: %v", p.value)
+}
+
+// recoverPanic converts a panic while compiling or rendering the named
+// template, e.g. in a custom directive handler, into an EngineError.
+// Panics in template functions are already returned as execution errors
+// naming the function.
+func recoverPanic(name string, err *error) {
+	if r := recover(); r != nil {
+		*err = &EngineError{
+			Message:  fmt.Sprintf("panic: %v", r),
+			Template: name,
+		}
+	}
+}
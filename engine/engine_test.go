@@ -0,0 +1,2217 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/codingersid/legit-template/lexer"
+	"github.com/codingersid/legit-template/runtime"
+)
+
+// newTestEngine creates an engine backed by a temporary views directory
+func newTestEngine(t *testing.T, files map[string]string, opts ...Option) *Engine {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	return New(dir, opts...)
+}
+
+func TestEngine_RequestDataLayering(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "{{ $a }}-{{ $b }}-{{ $c }}",
+	})
+	e.Share("a", "shared")
+	e.Share("b", "shared")
+	e.Share("c", "shared")
+
+	scope := e.WithRequestData(map[string]interface{}{"b": "local", "c": "local"})
+	out, err := scope.RenderString("page", map[string]interface{}{"c": "call"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out != "shared-local-call" {
+		t.Errorf("expected 'shared-local-call', got %q", out)
+	}
+}
+
+func TestEngine_RequestDataDoesNotPersist(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"user.legit": "[@isset($user){{ $user }}@endisset]",
+	})
+
+	out, err := e.WithRequestData(map[string]interface{}{"user": "alice"}).RenderString("user", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "[alice]" {
+		t.Errorf("expected '[alice]', got %q", out)
+	}
+
+	out, err = e.RenderString("user", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "[]" {
+		t.Errorf("expected request data not to persist, got %q", out)
+	}
+
+	out, err = e.WithRequestData(nil).Share("user", "bob").RenderTemplate("[{{ $user }}]", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "[bob]" {
+		t.Errorf("expected '[bob]', got %q", out)
+	}
+}
+
+func TestEngine_PrepareDataDeepMerge(t *testing.T) {
+	e := New(t.TempDir())
+	shared := map[string]interface{}{"a": 1}
+	e.Share("config", shared)
+
+	data := e.prepareData(map[string]interface{}{
+		"config": map[string]interface{}{"b": 2},
+	}, nil)
+
+	config, ok := data["config"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected config map, got %T", data["config"])
+	}
+	if config["a"] != 1 || config["b"] != 2 {
+		t.Errorf("expected {a:1 b:2}, got %v", config)
+	}
+
+	if _, ok := shared["b"]; ok {
+		t.Error("expected shared map not to be modified")
+	}
+}
+
+func TestEngine_PrepareDataDeepMergeLeafConflict(t *testing.T) {
+	e := New(t.TempDir())
+	e.Share("config", map[string]interface{}{
+		"db": map[string]interface{}{"host": "localhost", "port": 5432},
+	})
+
+	data := e.prepareData(map[string]interface{}{
+		"config": map[string]interface{}{
+			"db": map[string]interface{}{"host": "db.internal"},
+		},
+	}, nil)
+
+	db := data["config"].(map[string]interface{})["db"].(map[string]interface{})
+	if db["host"] != "db.internal" {
+		t.Errorf("expected call data to win, got %v", db["host"])
+	}
+	if db["port"] != 5432 {
+		t.Errorf("expected shared port to survive, got %v", db["port"])
+	}
+}
+
+func TestEngine_CustomDirective(t *testing.T) {
+	e := New(t.TempDir())
+	e.AddDirective("datetime", func(args string, data map[string]interface{}) string {
+		ts, _ := data[strings.TrimPrefix(args, "$")].(time.Time)
+		return ts.Format("2006-01-02")
+	})
+
+	tpl := "Posted @datetime($ts)@foreach($items as $item)@datetime($ts)@endforeach"
+	for _, day := range []int{5, 6} {
+		ts := time.Date(2024, time.March, day, 10, 0, 0, 0, time.UTC)
+		out, err := e.RenderTemplate(tpl, map[string]interface{}{"ts": ts, "items": []int{1}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := fmt.Sprintf("Posted 2024-03-0%[1]d2024-03-0%[1]d", day)
+		if out != expected {
+			t.Errorf("expected %q, got %q", expected, out)
+		}
+	}
+}
+
+func TestEngine_CustomDirectiveOverridesBuiltin(t *testing.T) {
+	e := New(t.TempDir())
+	e.AddBlockDirective("feature", func(args, inner string, data map[string]interface{}) string {
+		return "[" + inner + "]"
+	})
+	e.AddDirective("csrf", func(args string, data map[string]interface{}) string {
+		return "token"
+	})
+
+	out, err := e.RenderTemplate("@feature('beta')on@endfeature @csrf", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "[on] token" {
+		t.Errorf("expected custom directives to replace the built-ins, got %q", out)
+	}
+}
+
+func TestEngine_CustomDirectiveEscaping(t *testing.T) {
+	e := New(t.TempDir())
+	badge := func(args string, data map[string]interface{}) string {
+		return fmt.Sprintf("<b>%v</b>", data["name"])
+	}
+	e.AddDirective("badge", badge)
+	e.AddRawDirective("rawbadge", badge)
+
+	out, err := e.RenderTemplate("@badge() @rawbadge()", map[string]interface{}{"name": "ada"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "&lt;b&gt;ada&lt;/b&gt; <b>ada</b>"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	e.AddDirective("rawbadge", badge)
+	if out, _ := e.RenderTemplate("@rawbadge()", map[string]interface{}{"name": "ada"}); out != "&lt;b&gt;ada&lt;/b&gt;" {
+		t.Errorf("expected re-added directive to be escaped, got %q", out)
+	}
+}
+
+func TestEngine_CustomBlockDirective(t *testing.T) {
+	e := New(t.TempDir())
+	enabled := map[string]bool{"beta": true}
+	e.AddBlockDirective("featureflag", func(args, inner string, data map[string]interface{}) string {
+		if enabled[strings.Trim(args, `'"`)] {
+			return inner
+		}
+		return ""
+	})
+
+	out, err := e.RenderTemplate("@featureflag('beta')<b>{{ $name }}</b>@endfeatureflag|@featureflag('alpha')hidden@endfeatureflag", map[string]interface{}{"name": "new"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out != "<b>new</b>|" {
+		t.Errorf("expected '<b>new</b>|', got %q", out)
+	}
+}
+
+func TestEngine_AddAndRemoveFunctions(t *testing.T) {
+	e := New(t.TempDir())
+	e.AddFunctions(template.FuncMap{
+		"greet": func(s string) string { return "hi " + s },
+		"shout": func(s string) string { return strings.ToUpper(s) + "!" },
+	})
+
+	if !e.HasFunction("greet") || !e.HasFunction("shout") {
+		t.Fatal("expected added functions to be registered")
+	}
+
+	out, err := e.RenderTemplate("@greet(\"bob\")", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "hi bob" {
+		t.Errorf("expected 'hi bob', got %q", out)
+	}
+
+	e.RemoveFunction("greet")
+	if e.HasFunction("greet") {
+		t.Error("expected greet to be removed")
+	}
+	if _, err := e.RenderTemplate("@greet(\"bob\")", nil); err == nil {
+		t.Error("expected error rendering removed function")
+	}
+}
+
+func TestEngine_AddFunctionsReplacesCached(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"greet.legit": `@greet("bob")`,
+	})
+	e.AddFunction("greet", func(s string) string { return "hi " + s })
+
+	var buf bytes.Buffer
+	if err := e.Render(&buf, "greet", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "hi bob" {
+		t.Fatalf("expected 'hi bob', got %q", buf.String())
+	}
+
+	e.AddFunctions(template.FuncMap{
+		"greet": func(s string) string { return "hello " + s },
+	})
+
+	buf.Reset()
+	if err := e.Render(&buf, "greet", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "hello bob" {
+		t.Errorf("expected 'hello bob', got %q", buf.String())
+	}
+}
+
+func TestEngine_FunctionNames(t *testing.T) {
+	e := New(t.TempDir())
+	e.AddFunction("greet", func(s string) string { return "hi " + s })
+	e.RemoveFunction("upper")
+
+	names := e.FunctionNames()
+	if !sort.StringsAreSorted(names) {
+		t.Error("expected sorted function names")
+	}
+
+	has := func(name string) bool {
+		i := sort.SearchStrings(names, name)
+		return i < len(names) && names[i] == name
+	}
+	if !has("greet") || !has("lower") || !has("csrfToken") {
+		t.Errorf("expected added, built-in and bound functions, got %v", names)
+	}
+	if has("upper") {
+		t.Error("expected removed function not to be listed")
+	}
+}
+
+func TestEngine_RemoveBuiltinFunction(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "@lower(\"ABC\")",
+	})
+
+	if _, err := e.RenderString("page", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	e.RemoveFunction("upper")
+	if e.HasFunction("upper") {
+		t.Error("expected upper to be removed")
+	}
+
+	out, err := e.RenderString("page", nil)
+	if err != nil {
+		t.Fatalf("unexpected error after removal: %v", err)
+	}
+	if out != "abc" {
+		t.Errorf("expected 'abc', got %q", out)
+	}
+
+	if _, ok := DefaultFunctions()["upper"]; !ok {
+		t.Error("expected removal not to affect default functions")
+	}
+	if !New(t.TempDir()).HasFunction("upper") {
+		t.Error("expected removal not to affect other engines")
+	}
+}
+
+func TestEngine_Spaceless(t *testing.T) {
+	e := New(t.TempDir())
+
+	tmpl := "@spaceless\n<ul>\n  <li>Hello  World</li>\n  <li>{{ $name }}</li>\n</ul>\n<pre>\n  <b>kept</b>\n</pre>\n@endspaceless\n<p>\n</p>"
+	out, err := e.RenderTemplate(tmpl, map[string]interface{}{"name": "Bob"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "\n<ul><li>Hello  World</li><li>Bob</li></ul><pre>\n  <b>kept</b>\n</pre>\n\n<p>\n</p>"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_SpacelessLoop(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"list.legit": "@spaceless<ul>\n@foreach($items as $item)\n  <li>item</li>\n@endforeach\n</ul>@endspaceless",
+	})
+
+	out, err := e.RenderString("list", map[string]interface{}{"items": []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out != "<ul><li>item</li><li>item</li></ul>" {
+		t.Errorf("expected '<ul><li>item</li><li>item</li></ul>', got %q", out)
+	}
+}
+
+func TestEngine_StrictDirectives(t *testing.T) {
+	lenient := New(t.TempDir())
+	lenient.AddFunction("wibble", func() string { return "ok" })
+	if _, err := lenient.RenderTemplate("@wibble", nil); err != nil {
+		t.Fatalf("expected unknown directive to be tolerated, got %v", err)
+	}
+
+	strict := New(t.TempDir(), WithStrictDirectives(true))
+	strict.AddFunction("wibble", func() string { return "ok" })
+	_, err := strict.RenderTemplate("@wibble", nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown directive @wibble") {
+		t.Fatalf("expected unknown directive error, got %v", err)
+	}
+
+	strict.AddDirective("wibble", func(args string, data map[string]interface{}) string {
+		return "registered"
+	})
+	out, err := strict.RenderTemplate("@wibble", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "registered" {
+		t.Errorf("expected 'registered', got %q", out)
+	}
+}
+
+func TestEngine_ErrorMultipleMessages(t *testing.T) {
+	e := New(t.TempDir())
+	errors := map[string][]string{
+		"email": {"Email is required", "Email is invalid"},
+	}
+
+	out, err := e.RenderTemplate("@error('email'){{ $message }}|@foreach($messages as $msg)[{{ $msg }}]@endforeach@enderror", map[string]interface{}{"errors": errors})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out != "Email is required|[Email is required][Email is invalid]" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestEngine_ErrorMultipleFields(t *testing.T) {
+	e := New(t.TempDir())
+	errors := map[string][]string{
+		"password": {"Password is too short"},
+	}
+
+	out, err := e.RenderTemplate("@error(['email', 'password']){{ $message }}@enderror", map[string]interface{}{"errors": errors})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out != "Password is too short" {
+		t.Errorf("expected 'Password is too short', got %q", out)
+	}
+}
+
+func TestEngine_ErrorWildcard(t *testing.T) {
+	e := New(t.TempDir())
+	tmpl := "@error('attachments.*'){{ $message }} ({{ length($messages) }})@enderror|@error('images.*')images@enderror"
+
+	errors := map[string][]string{
+		"attachments.1": {"Second file is too large"},
+		"attachments.0": {"First file is not a PDF"},
+	}
+	for _, data := range []map[string]interface{}{
+		{"errors": errors},
+		{"errors": map[string]interface{}{"attachments.1": "Second file is too large", "attachments.0": "First file is not a PDF"}},
+	} {
+		out, err := e.RenderTemplate(tmpl, data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := "First file is not a PDF (2)|"; out != expected {
+			t.Errorf("expected %q, got %q", expected, out)
+		}
+	}
+}
+
+func TestEngine_ErrorNilErrors(t *testing.T) {
+	e := New(t.TempDir())
+
+	for _, data := range []map[string]interface{}{
+		nil,
+		{"errors": nil},
+		{"errors": map[string][]string(nil)},
+	} {
+		out, err := e.RenderTemplate("[@error('email'){{ $message }}@enderror]", data)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", data, err)
+		}
+		if out != "[]" {
+			t.Errorf("expected '[]', got %q", out)
+		}
+	}
+}
+
+func TestEngine_ErrorMessageScope(t *testing.T) {
+	e := New(t.TempDir())
+	errors := map[string][]string{"email": {"bad"}}
+
+	out, err := e.RenderTemplate("@error('email'){{ $message }}@enderror|{{ $message }}", map[string]interface{}{
+		"errors":  errors,
+		"message": "outer",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out != "bad|outer" {
+		t.Errorf("expected 'bad|outer', got %q", out)
+	}
+}
+
+func TestEngine_ErrorBag(t *testing.T) {
+	e := New(t.TempDir())
+	data := map[string]interface{}{
+		"errors": map[string][]string{
+			"email": {"Email is required", "Email is invalid"},
+			"name":  {"Name is required"},
+		},
+	}
+
+	tmpl := "@if($errors->any()){{ $errors->count() }}@endif|{{ $errors->first('email') }}|@if($errors->has('name'))name@endif|@foreach($errors->all() as $msg)[{{ $msg }}]@endforeach|@error('name'){{ $message }}@enderror"
+	out, err := e.RenderTemplate(tmpl, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "3|Email is required|name|[Email is required][Email is invalid][Name is required]|Name is required"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_ErrorInLoop(t *testing.T) {
+	e := New(t.TempDir())
+	data := map[string]interface{}{
+		"fields": []string{"email", "name"},
+		"errors": map[string][]string{"name": {"Name is required"}},
+	}
+
+	out, err := e.RenderTemplate("@foreach($fields as $field)@error('name')[{{ $field }}: {{ $message }}]@enderror@endforeach", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "[email: Name is required][name: Name is required]"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_CSRF(t *testing.T) {
+	e := New(t.TempDir())
+
+	out, err := e.RenderTemplate("@foreach($items as $item)@csrf@endforeach", map[string]interface{}{
+		"csrf_token": `"><script>x</script>`,
+		"items":      []int{1},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `<input type="hidden" name="_token" value="&#34;&gt;&lt;script&gt;x&lt;/script&gt;">`
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_CSRFFieldNameAndResolver(t *testing.T) {
+	e := New(t.TempDir(),
+		WithCSRFFieldName("csrf"),
+		WithCSRFTokenResolver(func(data map[string]interface{}) string {
+			return fmt.Sprintf("token-for-%v", data["user"])
+		}),
+	)
+
+	out, err := e.RenderTemplate("@csrf", map[string]interface{}{"user": "bob"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `<input type="hidden" name="csrf" value="token-for-bob">`
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_Namespaces(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"home.legit": "home",
+	})
+
+	adminDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(adminDir, "partials"), 0755); err != nil {
+		t.Fatalf("mkdir error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(adminDir, "partials", "nav.legit"), []byte("admin nav"), 0644); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	e.AddNamespace("admin", adminDir)
+
+	if path, err := e.resolvePath("admin::partials.nav"); err != nil || path != filepath.Join(adminDir, "partials", "nav.legit") {
+		t.Errorf("unexpected resolved path %q (%v)", path, err)
+	}
+
+	if !e.Exists("admin::partials.nav") || e.Exists("partials.nav") || e.Exists("admin::home") {
+		t.Error("unexpected Exists result for namespaced templates")
+	}
+
+	out, err := e.RenderString("admin::partials.nav", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "admin nav" {
+		t.Errorf("expected 'admin nav', got %q", out)
+	}
+
+	if _, err := e.RenderString("shop::home", nil); err == nil || !strings.Contains(err.Error(), `namespace "shop" not registered`) {
+		t.Errorf("expected unregistered namespace error, got %v", err)
+	}
+	if e.Exists("shop::home") {
+		t.Error("expected template in an unregistered namespace not to exist")
+	}
+
+	templates, err := e.Templates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(templates) != 2 || templates[0] != "home" || templates[1] != "admin::partials.nav" {
+		t.Errorf("unexpected templates %v", templates)
+	}
+}
+
+func TestEngine_FallbackPaths(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "primary page",
+	})
+
+	fallback := t.TempDir()
+	for name, content := range map[string]string{
+		"page.legit":  "fallback page",
+		"extra.legit": "fallback extra",
+	} {
+		if err := os.WriteFile(filepath.Join(fallback, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	e.AddPath(fallback)
+
+	for name, expected := range map[string]string{
+		"page":  "primary page",
+		"extra": "fallback extra",
+	} {
+		out, err := e.RenderString(name, nil)
+		if err != nil {
+			t.Fatalf("unexpected error rendering %s: %v", name, err)
+		}
+		if out != expected {
+			t.Errorf("expected %q, got %q", expected, out)
+		}
+	}
+
+	templates, err := e.Templates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(templates) != 2 {
+		t.Errorf("expected shadowed template to be listed once, got %v", templates)
+	}
+}
+
+func TestEngine_FileSystem(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/layouts/app.legit": {Data: []byte("<main>@yield('content')</main>")},
+		"views/pages/home.legit":  {Data: []byte("@extends('layouts.app')@section('content')Hello {{ $name }}@endsection")},
+		"views/plain.legit":       {Data: []byte("plain")},
+	}
+	e := New("views", WithFileSystem(fsys))
+
+	out, err := e.RenderString("pages.home", map[string]interface{}{"name": "Bob"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<main>Hello Bob</main>" {
+		t.Errorf("expected '<main>Hello Bob</main>', got %q", out)
+	}
+
+	if !e.Exists("plain") || e.Exists("missing") {
+		t.Error("unexpected Exists result")
+	}
+
+	templates, err := e.Templates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(templates) != 3 {
+		t.Errorf("expected 3 templates, got %v", templates)
+	}
+
+	if err := e.Load(); err != nil {
+		t.Fatalf("unexpected load error: %v", err)
+	}
+}
+
+func TestEngine_FileSystemCacheWithoutModTime(t *testing.T) {
+	fsys := fstest.MapFS{
+		"page.legit": {Data: []byte("v1")},
+	}
+	e := New(".", WithFileSystem(fsys))
+
+	out, err := e.RenderString("page", nil)
+	if err != nil || out != "v1" {
+		t.Fatalf("expected 'v1', got %q (%v)", out, err)
+	}
+
+	fsys["page.legit"] = &fstest.MapFile{Data: []byte("v2")}
+
+	out, err = e.RenderString("page", nil)
+	if err != nil || out != "v2" {
+		t.Fatalf("expected changed content to invalidate cache, got %q (%v)", out, err)
+	}
+}
+
+func TestEngine_ChecksumDetectsSameModTimeChange(t *testing.T) {
+	e := newTestEngine(t, map[string]string{"page.legit": "v1"}, WithChecksumValidation(true))
+	path, _ := e.resolvePath("page")
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, stamp, stamp); err != nil {
+		t.Fatalf("chtimes error: %v", err)
+	}
+
+	if out, _ := e.RenderString("page", nil); out != "v1" {
+		t.Fatalf("expected 'v1', got %q", out)
+	}
+
+	if err := os.WriteFile(path, []byte("v2"), 0644); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if err := os.Chtimes(path, stamp, stamp); err != nil {
+		t.Fatalf("chtimes error: %v", err)
+	}
+
+	if out, _ := e.RenderString("page", nil); out != "v2" {
+		t.Errorf("expected same-modtime change to invalidate, got %q", out)
+	}
+}
+
+func TestEngine_TouchKeepsCache(t *testing.T) {
+	e := newTestEngine(t, map[string]string{"page.legit": "v1"})
+	path, _ := e.resolvePath("page")
+
+	if _, err := e.RenderString("page", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first, _ := e.cache.Get("page")
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("chtimes error: %v", err)
+	}
+
+	if _, err := e.RenderString("page", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := e.cache.Get("page")
+
+	if first.Template != second.Template {
+		t.Error("expected unchanged touch to keep the cached template")
+	}
+	if !second.Dependencies[path].ModTime.Equal(later) || !second.ModTime.Equal(later) {
+		t.Error("expected the touched modtime to be recorded")
+	}
+
+	if err := os.WriteFile(path, []byte("v2"), 0644); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	changed := later.Add(time.Minute)
+	if err := os.Chtimes(path, changed, changed); err != nil {
+		t.Fatalf("chtimes error: %v", err)
+	}
+	if out, _ := e.RenderString("page", nil); out != "v2" {
+		t.Errorf("expected a changed file to invalidate, got %q", out)
+	}
+}
+
+func TestEngine_CacheTracksDependencies(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"layout.legit":       "<main>@yield('content')</main>",
+		"page.legit":         "@extends('layout')@section('content')@include('partials.nav')@includeIf('partials.extra')@endsection",
+		"partials/nav.legit": "nav",
+	})
+
+	render := func() string {
+		t.Helper()
+		out, err := e.RenderString("page", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out
+	}
+	stamp := time.Now().Add(time.Hour)
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(e.viewsPath, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+		stamp = stamp.Add(time.Second)
+		if err := os.Chtimes(path, stamp, stamp); err != nil {
+			t.Fatalf("chtimes error: %v", err)
+		}
+	}
+
+	if out := render(); out != "<main>nav</main>" {
+		t.Fatalf("unexpected first render %q", out)
+	}
+
+	write("layout.legit", "<body>@yield('content')</body>")
+	if out := render(); out != "<body>nav</body>" {
+		t.Errorf("expected a layout change to invalidate, got %q", out)
+	}
+
+	write("partials/nav.legit", "menu")
+	if out := render(); out != "<body>menu</body>" {
+		t.Errorf("expected a partial change to invalidate, got %q", out)
+	}
+
+	write("partials/extra.legit", "+")
+	if out := render(); out != "<body>menu+</body>" {
+		t.Errorf("expected a new optional include to invalidate, got %q", out)
+	}
+
+	if stats := e.CacheStats(); stats.Hits != 0 || stats.Misses != 4 {
+		t.Errorf("expected every change to recompile, got %+v", stats)
+	}
+}
+
+func TestEngine_PhpAssignment(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"inline.legit": "@php($total = $qty * $price)Total: {{ $total }}",
+		"block.legit":  "@php\n$a = 2;\n$b = $a + 3;\n@endphp{{ $a }}-{{ $b }}",
+	})
+
+	out, err := e.RenderString("inline", map[string]interface{}{"qty": 3, "price": 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "Total: 15" {
+		t.Errorf("expected 'Total: 15', got %q", out)
+	}
+
+	out, err = e.RenderString("block", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "2-5" {
+		t.Errorf("expected '2-5', got %q", out)
+	}
+
+	out, err = e.RenderTemplate("@if($flag)@php($x = 1){{ $x }}@endif@php($x = 2){{ $x }}", map[string]interface{}{"flag": true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "12" {
+		t.Errorf("expected assignment after @if to declare the variable, got %q", out)
+	}
+}
+
+func TestEngine_ArithmeticExpressions(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"calc.legit": "{{ $price * $qty }}|@if($a + $b > 10)big@else small@endif|@if($a % 2 == 1)odd@endif|{{ -$a }}",
+	})
+
+	out, err := e.RenderString("calc", map[string]interface{}{"price": 2.5, "qty": 4, "a": 7, "b": 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "10|big|odd|-7" {
+		t.Errorf("expected '10|big|odd|-7', got %q", out)
+	}
+}
+
+func TestEngine_EachPopulated(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"list.legit":          "<ul>@each('partials.item', $users, 'user', 'partials.none')</ul>",
+		"partials/item.legit": "<li>{{ $key }}:{{ $user }}</li>",
+		"partials/none.legit": "<li>none</li>",
+	})
+
+	out, err := e.RenderString("list", map[string]interface{}{"users": []string{"Ann", "Bob"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<ul><li>0:Ann</li><li>1:Bob</li></ul>" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestEngine_EachEmpty(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"list.legit":          "<ul>@each('partials.item', $users, 'user', 'partials.none')</ul>",
+		"partials/item.legit": "<li>{{ $user }}</li>",
+		"partials/none.legit": "<li>none</li>",
+	})
+
+	out, err := e.RenderString("list", map[string]interface{}{"users": []string{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<ul><li>none</li></ul>" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestEngine_RenderEach(t *testing.T) {
+	type product struct {
+		Name  string
+		Price int
+	}
+	e := newTestEngine(t, map[string]string{
+		"partials/product.legit": "<li>{{ $key }}. {{ $product->Name }} {{ $currency }}{{ $product->Price }}</li>",
+	})
+
+	var buf bytes.Buffer
+	items := []product{{"Pen", 2}, {"Ink", 5}}
+	err := e.RenderEach(&buf, "partials.product", items, "product", map[string]interface{}{"currency": "$"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<li>0. Pen $2</li><li>1. Ink $5</li>"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	err = e.RenderEach(&buf, "partials.product", items, "product", map[string]interface{}{"currency": "$", "key": "#"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<li>#. Pen $2</li><li>#. Ink $5</li>"; buf.String() != expected {
+		t.Errorf("expected data key to be kept, got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := e.RenderEach(&buf, "partials.missing", items, "product", nil); err == nil {
+		t.Error("expected error for missing partial")
+	}
+}
+
+func TestEngine_IncludeFirst(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":            "[@includeFirst(['custom.header', 'partials.header'], ['title' => 'Home'])]",
+		"partials/header.legit": "<h1>{{ $title }}</h1>",
+		"missing.legit":         "@includeFirst(['a', 'b'])",
+	})
+
+	out, err := e.RenderString("page", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "[<h1>Home</h1>]" {
+		t.Errorf("expected '[<h1>Home</h1>]', got %q", out)
+	}
+
+	if _, err := e.RenderString("missing", nil); err == nil || !strings.Contains(err.Error(), "none of the views") {
+		t.Errorf("expected missing views error, got %v", err)
+	}
+}
+
+// executeSet compiles the given sources into one template set and renders
+// the first name with data
+func executeSet(t *testing.T, e *Engine, names []string, sources map[string]string, data map[string]interface{}) string {
+	t.Helper()
+
+	var tmpl *template.Template
+	for _, name := range names {
+		compiled, err := e.compileString(sources[name])
+		if err != nil {
+			t.Fatalf("compile %s: %v", name, err)
+		}
+		if tmpl == nil {
+			tmpl = e.newTemplate(name)
+		} else {
+			tmpl = tmpl.New(name)
+		}
+		if _, err := tmpl.Parse(compiled); err != nil {
+			t.Fatalf("parse %s: %v", name, err)
+		}
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Lookup(names[0]).Execute(&buf, e.prepareData(data, nil)); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	return buf.String()
+}
+
+func TestEngine_SectionTerminators(t *testing.T) {
+	layout := "@section('sidebar')<a>Home</a>@show"
+	tests := []struct {
+		child    string
+		expected string
+	}{
+		{"@section('sidebar')<a>Child</a>@stop", "<a>Child</a>"},
+		{"@section('sidebar')<a>Child</a>@append", "<a>Home</a><a>Child</a>"},
+		{"@section('sidebar')@parent<a>Child</a>@overwrite", "<a>Child</a>"},
+		{"@section('sidebar')@parent<a>Child</a>@endsection", "<a>Home</a><a>Child</a>"},
+	}
+
+	for _, tt := range tests {
+		e := newTestEngine(t, map[string]string{
+			"layout.legit": layout,
+			"page.legit":   "@extends('layout')" + tt.child,
+		})
+		out, err := e.RenderString("page", nil)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.child, err)
+		}
+		if out != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.child, tt.expected, out)
+		}
+	}
+}
+
+func TestEngine_SectionTrimNewlines(t *testing.T) {
+	files := map[string]string{
+		"layout.legit": "<main>@yield('content')</main><title>@yield('title')</title>",
+		"page.legit":   "@extends('layout')\n@section('title', '\nHome\n')\n@section('content')\n    <h1>{{ $name }}</h1>\n@endsection\n",
+	}
+	data := map[string]interface{}{"name": "Ada"}
+
+	out, err := newTestEngine(t, files).RenderString("page", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<main>\n    <h1>Ada</h1>\n</main><title>\nHome\n</title>"; out != expected {
+		t.Errorf("expected untrimmed section, got %q", out)
+	}
+
+	out, err = newTestEngine(t, files, WithCollapseWhitespace(true)).RenderString("page", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<main>    <h1>Ada</h1></main><title>\nHome\n</title>"; out != expected {
+		t.Errorf("expected trimmed section, got %q", out)
+	}
+}
+
+func TestEngine_ExtendsWithData(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"layouts/base.legit": "<body class=\"{{ $bodyClass }}\" data-theme=\"{{ $theme }}\">@yield('nav')<main>@yield('content')</main></body>",
+		"layouts/app.legit":  "@extends('layouts.base', ['theme' => 'dark', 'bodyClass' => 'app'])@section('nav')<nav></nav>@endsection",
+		"home.legit":         "@extends('layouts.app', ['bodyClass' => $variant])@section('content')Hi {{ $name }}@endsection",
+		"plain.legit":        "@extends('layouts.app')@section('content')Plain@endsection",
+	})
+	data := map[string]interface{}{"name": "Ada", "variant": "wide"}
+
+	out, err := e.RenderString("home", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `<body class="wide" data-theme="dark"><nav></nav><main>Hi Ada</main></body>`; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	out, err = e.RenderString("plain", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `<body class="app" data-theme="dark"><nav></nav><main>Plain</main></body>`; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_AwareInheritsParentComponentData(t *testing.T) {
+	e := New(t.TempDir())
+	sources := map[string]string{
+		"page":              `@component("panel", ["color" => $color])@endcomponent`,
+		"components/panel":  `<div>@component("button")Click@endcomponent</div>`,
+		"components/button": `@aware(["color" => "gray"])<button class="{{ $color }}">{!! $slot !!}</button>`,
+	}
+	names := []string{"page", "components/panel", "components/button"}
+
+	out := executeSet(t, e, names, sources, map[string]interface{}{"color": "red"})
+	if out != `<div><button class="red">Click</button></div>` {
+		t.Errorf("expected parent color, got %q", out)
+	}
+
+	sources["page"] = `@component("panel")@endcomponent`
+	out = executeSet(t, e, names, sources, nil)
+	if out != `<div><button class="gray">Click</button></div>` {
+		t.Errorf("expected default color, got %q", out)
+	}
+
+	sources["page"] = `@component("panel", ["color" => "red"])@endcomponent`
+	sources["components/panel"] = `<div>@component("button", ["color" => "blue"])Click@endcomponent</div>`
+	out = executeSet(t, e, names, sources, nil)
+	if out != `<div><button class="blue">Click</button></div>` {
+		t.Errorf("expected explicitly passed color, got %q", out)
+	}
+}
+
+func TestEngine_PropsPrecedence(t *testing.T) {
+	e := New(t.TempDir())
+	sources := map[string]string{
+		"page":              `@component("button")@endcomponent|@component("panel", ["size" => "lg"])@endcomponent|@component("button", ["size" => "sm", "id" => "go"])@endcomponent`,
+		"components/panel":  `@component("button")@endcomponent`,
+		"components/button": `@props(["size" => "md", "color"])<button class="{{ $size }}">{{ json($attributes) }}</button>`,
+	}
+
+	out := executeSet(t, e, []string{"page", "components/panel", "components/button"}, sources, map[string]interface{}{"size": "xl"})
+	expected := `<button class="md">{}</button>|<button class="lg">{}</button>|<button class="sm">{&#34;id&#34;:&#34;go&#34;}</button>`
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_NamedSlotAttributes(t *testing.T) {
+	e := New(t.TempDir())
+	sources := map[string]string{
+		"page":            `@component("card")@slot("title", ["class" => $size])Hello@endslot Body@endcomponent`,
+		"components/card": `<h1 class="{{ $title->attributes['class'] }}">{{ $title }}</h1><p>{{ $slot }}</p>`,
+	}
+
+	out := executeSet(t, e, []string{"page", "components/card"}, sources, map[string]interface{}{"size": "big"})
+	if out != `<h1 class="big">Hello</h1><p> Body</p>` {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestEngine_ComponentWithoutBody(t *testing.T) {
+	e := New(t.TempDir())
+	sources := map[string]string{
+		"page":             `@component("alert")<p>after</p>`,
+		"components/alert": `<div>[{{ $slot }}]</div>`,
+	}
+
+	out := executeSet(t, e, []string{"page", "components/alert"}, sources, nil)
+	if out != `<div>[]</div><p>after</p>` {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestEngine_ComponentSlotWithBackticks(t *testing.T) {
+	e := New(t.TempDir())
+	sources := map[string]string{
+		"page":            "@component(\"code\")run `go test`@slot(\"title\")`x`@endslot@endcomponent",
+		"components/code": `<pre title="{{ $title }}">{{ $slot }}</pre>`,
+	}
+
+	out := executeSet(t, e, []string{"page", "components/code"}, sources, nil)
+	if out != "<pre title=\"`x`\">run `go test`</pre>" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestEngine_ComponentSlotRenderedAtRuntime(t *testing.T) {
+	e := New(t.TempDir())
+	sources := map[string]string{
+		"page":           "@foreach($posts as $post)@component(\"doc\")<h2>{{ $post }}</h2>\n```go\nfmt.Println(\"{{ $greeting }}\")\n```@slot(\"footer\")<em>{{ $post }}</em>@endslot@endcomponent@endforeach",
+		"components/doc": "<article>{!! $slot !!}<footer>{!! $footer !!}</footer></article>",
+	}
+
+	out := executeSet(t, e, []string{"page", "components/doc"}, sources, map[string]interface{}{
+		"posts":    []string{"First"},
+		"greeting": "hi",
+	})
+	expected := "<article><h2>First</h2>\n```go\nfmt.Println(\"hi\")\n```<footer><em>First</em></footer></article>"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_ComponentSlotDefault(t *testing.T) {
+	e := New(t.TempDir())
+	component := `<div class="card"><h4>{!! slot("title", "Default Title") !!}</h4>{{ $slot }}</div>`
+
+	tests := []struct {
+		page     string
+		expected string
+	}{
+		{`@component("card")Body@endcomponent`, `<div class="card"><h4>Default Title</h4>Body</div>`},
+		{`@component("card")Body@slot("title")<b>Custom</b>@endslot@endcomponent`, `<div class="card"><h4><b>Custom</b></h4>Body</div>`},
+		{`@component("card")Body@slot("title")@endslot@endcomponent`, `<div class="card"><h4>Default Title</h4>Body</div>`},
+	}
+
+	for _, tt := range tests {
+		sources := map[string]string{"page": tt.page, "components/card": component}
+		out := executeSet(t, e, []string{"page", "components/card"}, sources, nil)
+		if out != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.page, tt.expected, out)
+		}
+	}
+}
+
+func TestEngine_GroupByOrdered(t *testing.T) {
+	tpl := "@foreach(groupByOrdered($posts, 'cat') as $g)[{{ $g->Key }}:@foreach($g->Items as $p){{ $p['title'] }};@endforeach]@endforeach"
+	data := map[string]interface{}{
+		"posts": []map[string]interface{}{
+			{"title": "a", "cat": "news"},
+			{"title": "b", "cat": "blog"},
+			{"title": "c", "cat": "news"},
+			{"title": "d", "cat": "about"},
+		},
+	}
+
+	for i := 0; i < 5; i++ {
+		out, err := New(t.TempDir()).RenderTemplate(tpl, data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := "[news:a;c;][blog:b;][about:d;]"; out != expected {
+			t.Fatalf("expected %q, got %q", expected, out)
+		}
+	}
+}
+
+func TestEngine_Lint(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "@if($x)\n{{ upper(\"a\") }}\n@upper(\"b\")\n@unknownThing",
+	})
+
+	issues, err := e.Lint("page")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(issues) != 2 {
+		t.Fatalf("expected 2 issues, got %v", issues)
+	}
+	if issues[0].Position.Line != 4 || issues[1].Position.Line != 1 {
+		t.Errorf("unexpected issues: %v", issues)
+	}
+}
+
+func TestEngine_RenderToResponseStatus(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"errors/404.legit": "@status(404)@header('X-Reason', 'missing')Not found: {{ $path }}",
+		"home.legit":       "Home",
+	})
+
+	rec := httptest.NewRecorder()
+	if err := e.RenderToResponse(rec, "errors.404", map[string]interface{}{"path": "/nope"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rec.Code)
+	}
+	if rec.Header().Get("X-Reason") != "missing" {
+		t.Errorf("expected X-Reason header, got %v", rec.Header())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected HTML content type, got %q", rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != "Not found: /nope" {
+		t.Errorf("unexpected body %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	if err := e.RenderToResponse(rec, "home", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+
+	// Plain renders ignore the directives
+	out, err := e.RenderString("errors.404", map[string]interface{}{"path": "/x"})
+	if err != nil || out != "Not found: /x" {
+		t.Errorf("expected plain render, got %q (%v)", out, err)
+	}
+}
+
+func TestEngine_RenderResponseDataStatus(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":  "Gone",
+		"loop.legit":  "@foreach($codes as $code)@if($code > 400)@status($code)@endif@endforeach",
+		"inner.legit": "@status(503)",
+		"outer.legit": "@includeFirst(['inner'])down",
+	})
+
+	resp, err := e.RenderResponse("page", map[string]interface{}{"__status": 410})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != 410 || resp.Body != "Gone" {
+		t.Errorf("unexpected response %d %q", resp.Status, resp.Body)
+	}
+
+	resp, err = e.RenderResponse("loop", map[string]interface{}{"codes": []int{200, 418}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != 418 {
+		t.Errorf("expected status 418 from inside the loop, got %d", resp.Status)
+	}
+
+	resp, err = e.RenderResponse("outer", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != 503 {
+		t.Errorf("expected status 503 from the include, got %d", resp.Status)
+	}
+}
+
+func TestEngine_IfTruthiness(t *testing.T) {
+	e := New(t.TempDir())
+
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"0", "no"},
+		{"", "no"},
+		{[]string{}, "no"},
+		{map[string]interface{}{}, "no"},
+		{"1", "yes"},
+		{[]string{"a"}, "yes"},
+	}
+
+	for _, tt := range tests {
+		out, err := e.RenderTemplate("@if($value)yes@elseif($value)maybe@else no@endif", map[string]interface{}{"value": tt.value})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.TrimSpace(out) != tt.expected {
+			t.Errorf("%#v: expected %q, got %q", tt.value, tt.expected, out)
+		}
+	}
+}
+
+func TestEngine_ConditionTruthiness(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":          "@unless($zero)unless@endunless|@includeWhen($zero, 'partials.note')@includeUnless($zero, 'partials.note')|@if($one && $zero)and@endif|@foreach($items as $item)@break($zero){{ $item }}@endforeach",
+		"partials/note.legit": "note",
+	})
+
+	out, err := e.RenderString("page", map[string]interface{}{"zero": "0", "one": "1", "items": []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "unless|note||ab"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_SwitchFallthrough(t *testing.T) {
+	e := New(t.TempDir())
+	tpl := "@switch($x)@case(1)one,@case(2)two,@break@case(3)three,@default other@endswitch"
+
+	tests := map[int]string{1: "one,two,", 2: "two,", 3: "three, other", 4: " other"}
+	for x, expected := range tests {
+		out, err := e.RenderTemplate(tpl, map[string]interface{}{"x": x})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out != expected {
+			t.Errorf("x=%d: expected %q, got %q", x, expected, out)
+		}
+	}
+}
+
+func TestEngine_RenderJSONData(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"hello.legit": "Hello {{ $name }} ({{ $user->role }})",
+	})
+
+	for _, data := range []interface{}{
+		[]byte(`{"name": "Ada", "user": {"role": "admin"}}`),
+		json.RawMessage(`{"name": "Ada", "user": {"role": "admin"}}`),
+	} {
+		out, err := e.RenderString("hello", data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out != "Hello Ada (admin)" {
+			t.Errorf("expected 'Hello Ada (admin)', got %q", out)
+		}
+	}
+
+	out, err := e.RenderTemplate("{{ $name }}", []byte(`{"name": "inline"}`))
+	if err != nil || out != "inline" {
+		t.Errorf("expected 'inline', got %q (%v)", out, err)
+	}
+
+	for _, data := range []string{`["a", "b"]`, `null`, `{broken`} {
+		if _, err := e.RenderString("hello", []byte(data)); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+}
+
+func TestEngine_CollapseWhitespace(t *testing.T) {
+	tpl := "<p>@if($a)\n    {{ $a }}   {{ $a }}\n  @endif</p>"
+	data := map[string]interface{}{"a": "x"}
+
+	out, err := New(t.TempDir()).RenderTemplate(tpl, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<p>\n    x   x\n  </p>" {
+		t.Errorf("expected whitespace to be preserved by default, got %q", out)
+	}
+
+	out, err = New(t.TempDir(), WithCollapseWhitespace(true)).RenderTemplate(tpl, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<p>\nx x\n</p>" {
+		t.Errorf("expected collapsed whitespace, got %q", out)
+	}
+}
+
+func TestEngine_Delims(t *testing.T) {
+	data := map[string]interface{}{"name": "Ada"}
+
+	out, err := New(t.TempDir()).RenderTemplate("@verbatim<p>{{ msg }}</p>@endverbatim{{ $name }}", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<p>{{ msg }}</p>Ada" {
+		t.Errorf("expected verbatim braces to be kept, got %q", out)
+	}
+
+	e := New(t.TempDir(), WithDelims("[[", "]]"))
+	out, err = e.RenderTemplate("<p>{{ msg }}</p><a title=\"{{ x }}\">[[ $name ]]</a>", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<p>{{ msg }}</p><a title=\"{{ x }}\">Ada</a>" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestEngine_KeepComments(t *testing.T) {
+	tpl := "<p>{{-- todo: {{ $x }} --}}Hi{{--! build 42 --}}</p>"
+
+	out, err := New(t.TempDir()).RenderTemplate(tpl, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<p>Hi<!-- build 42 --></p>" {
+		t.Errorf("expected comments to be dropped by default, got %q", out)
+	}
+
+	out, err = New(t.TempDir(), WithKeepComments(true)).RenderTemplate(tpl, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<p><!-- todo: {{ $x }} -->Hi<!-- build 42 --></p>" {
+		t.Errorf("expected comments to be kept, got %q", out)
+	}
+}
+
+func TestEngine_Tokens(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "Hi {{ $name }}\n@if($x)y@endif",
+	})
+
+	tokens, err := e.Tokens("page")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []struct {
+		typ          lexer.TokenType
+		line, column int
+	}{
+		{lexer.TOKEN_TEXT, 1, 1},
+		{lexer.TOKEN_ECHO_ESCAPED, 1, 4},
+		{lexer.TOKEN_TEXT, 1, 15},
+		{lexer.TOKEN_DIRECTIVE_ARGS, 2, 1},
+		{lexer.TOKEN_TEXT, 2, 8},
+		{lexer.TOKEN_DIRECTIVE, 2, 9},
+		{lexer.TOKEN_EOF, 0, 0},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %+v", len(expected), tokens)
+	}
+	for i, want := range expected {
+		got := tokens[i]
+		if got.Type != want.typ || (want.line != 0 && (got.Position.Line != want.line || got.Position.Column != want.column)) {
+			t.Errorf("token %d: expected %s at %d:%d, got %s at %d:%d",
+				i, want.typ, want.line, want.column, got.Type, got.Position.Line, got.Position.Column)
+		}
+	}
+
+	if _, err := e.Tokens("missing"); err == nil {
+		t.Error("expected error for missing template")
+	}
+}
+
+func TestEngine_ForeachMapAndStruct(t *testing.T) {
+	type product struct {
+		Name  string
+		Price int
+	}
+	e := New(t.TempDir())
+
+	out, err := e.RenderTemplate("@foreach($stock as $name => $qty){{ $name }}={{ $qty }}@if(!$loop->Last),@endif@endforeach", map[string]interface{}{
+		"stock": map[string]int{"pear": 2, "apple": 5, "fig": 1},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "apple=5,fig=1,pear=2" {
+		t.Errorf("expected sorted keys, got %q", out)
+	}
+
+	out, err = e.RenderTemplate("@foreach($item as $field => $value)[{{ $field }}: {{ $value }}]@endforeach", map[string]interface{}{
+		"item": product{Name: "Lamp", Price: 30},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "[Name: Lamp][Price: 30]" {
+		t.Errorf("expected struct fields, got %q", out)
+	}
+
+	out, err = e.RenderTemplate("@foreach($list as $i => $v){{ $i }}{{ $v }}@endforeach", map[string]interface{}{
+		"list": []string{"a", "b"},
+	})
+	if err != nil || out != "0a1b" {
+		t.Errorf("expected slice iteration, got %q (%v)", out, err)
+	}
+}
+
+func TestEngine_Paginate(t *testing.T) {
+	e := New(t.TempDir())
+	tpl := "@php($p = paginate($posts, 2, $page))@foreach($p->Items as $post){{ $post }} @endforeach{{ $p->CurrentPage }}/{{ $p->LastPage }}"
+
+	out, err := e.RenderTemplate(tpl, map[string]interface{}{"posts": []string{"a", "b", "c"}, "page": 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "c 2/2" {
+		t.Errorf("expected 'c 2/2', got %q", out)
+	}
+}
+
+func TestEngine_CollectChaining(t *testing.T) {
+	e := New(t.TempDir())
+	data := map[string]interface{}{
+		"users": []map[string]interface{}{
+			{"name": "Ada", "active": true},
+			{"name": "Bob", "active": false},
+			{"name": "Cy", "active": true},
+		},
+	}
+
+	tests := map[string]string{
+		"{{ collect($users)->where('active', true)->pluck('name')->count() }}":                      "2",
+		`{{ (((collect .users).Where "active" true).Pluck "name").Count }}`:                         "2",
+		"{{ collect($users)->sortBy('name', 'desc')->pluck('name')->first() }}":                     "Cy",
+		"@foreach(collect($users)->where('active', false)->all() as $u){{ $u['name'] }}@endforeach": "Bob",
+	}
+	for tpl, expected := range tests {
+		out, err := e.RenderTemplate(tpl, data)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tpl, err)
+		}
+		if out != expected {
+			t.Errorf("%s: expected %q, got %q", tpl, expected, out)
+		}
+	}
+}
+
+// failingUser has a method that always fails when rendered
+type failingUser struct{}
+
+func (failingUser) Name() (string, error) {
+	return "", errors.New("lookup failed")
+}
+
+func TestEngine_ExecutionErrorSourcePosition(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":   "<h1>Title</h1>\n@if($show)\n  <p>{{ $user->name() }}</p>\n@endif\n",
+		"layout.legit": "<html>\n@yield('content')\n</html>",
+		"child.legit":  "@extends('layout')\n\n@section('content')\n    {{ $user->name() }}\n@endsection",
+	})
+	data := map[string]interface{}{"show": true, "user": failingUser{}}
+
+	tests := []struct {
+		name     string
+		template string
+		line     int
+		column   int
+	}{
+		{"page", "page", 3, 6},
+		{"child", "child", 4, 5},
+	}
+	for _, tt := range tests {
+		_, err := e.RenderString(tt.name, data)
+
+		var engineErr *EngineError
+		if !errors.As(err, &engineErr) {
+			t.Fatalf("%s: expected EngineError, got %v", tt.name, err)
+		}
+		if engineErr.Template != tt.template || engineErr.Line != tt.line || engineErr.Column != tt.column {
+			t.Errorf("%s: expected %s:%d:%d, got %s:%d:%d", tt.name, tt.template, tt.line, tt.column,
+				engineErr.Template, engineErr.Line, engineErr.Column)
+		}
+		if !strings.Contains(engineErr.Message, "lookup failed") {
+			t.Errorf("%s: expected underlying message, got %q", tt.name, engineErr.Message)
+		}
+	}
+
+	if _, err := e.RenderTemplate("ok\n\n{{ $user->name() }}", data); err == nil || !strings.Contains(err.Error(), "line 3, column 1") {
+		t.Errorf("expected inline error at line 3, got %v", err)
+	}
+}
+
+func TestEngine_SourceMapsFollowCachedTemplates(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"a.legit":             "a\n@include('partials.user')",
+		"b.legit":             "@include('partials.user')",
+		"partials/user.legit": "<p>\n  {{ $user->name() }}</p>",
+	}, WithCacheLimit(1))
+	data := map[string]interface{}{"user": failingUser{}}
+
+	for _, name := range []string{"a", "b", "a"} {
+		_, err := e.RenderString(name, data)
+
+		var engineErr *EngineError
+		if !errors.As(err, &engineErr) || engineErr.Template != "partials.user" || engineErr.Line != 2 || engineErr.Column != 3 {
+			t.Errorf("%s: expected the error at partials.user:2:3, got %v", name, err)
+		}
+	}
+	if stats := e.CacheStats(); stats.Evictions != 2 {
+		t.Errorf("expected each render to evict the other page, got %+v", stats)
+	}
+}
+
+func TestEngine_PushIf(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"layout.legit": "<head>@stack('scripts')</head>@yield('content')",
+		"page.legit":   "@extends('layout')@section('content')<main></main>@endsection\n@pushIf($needsChart, 'scripts')<script src=\"chart.js\"></script>@endPushIf",
+	})
+
+	tests := map[bool]string{
+		true:  `<head><script src="chart.js"></script></head><main></main>`,
+		false: `<head></head><main></main>`,
+	}
+	for needsChart, expected := range tests {
+		out, err := e.RenderString("page", map[string]interface{}{"needsChart": needsChart})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out != expected {
+			t.Errorf("needsChart=%v: expected %q, got %q", needsChart, expected, out)
+		}
+	}
+}
+
+func TestEngine_PushAndPrepend(t *testing.T) {
+	e := New(t.TempDir())
+	tpl := "[@stack('s')]@push('s')a@endpush@foreach($items as $i)@push('s'){{ $i }}@endpush@endforeach@prepend('s')p@endprepend"
+
+	out, err := e.RenderTemplate(tpl, map[string]interface{}{"items": []int{1, 2}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "[pa12]" {
+		t.Errorf("expected '[pa12]', got %q", out)
+	}
+}
+
+func TestEngine_StackDefault(t *testing.T) {
+	e := New(t.TempDir())
+
+	tests := map[string]string{
+		"[@stack('s')<i>none</i>@endstack]":                     "[<i>none</i>]",
+		"[@stack('s')<i>none</i>@endstack]@push('s')a@endpush":  "[a]",
+		"[@stack('s')]@stack('t'){{ $x }}@endstack":             "[]x",
+		"[@stack('s')]@push('s')a@endpush@stack('t')-@endstack": "[a]-",
+	}
+	for tpl, expected := range tests {
+		out, err := e.RenderTemplate(tpl, map[string]interface{}{"x": "x"})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tpl, err)
+		}
+		if out != expected {
+			t.Errorf("%s: expected %q, got %q", tpl, expected, out)
+		}
+	}
+}
+
+func TestEngine_StackOrderAcrossIncludes(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "@prepend('s')p1@endprepend[@stack('s')]@push('s')a@endpush" +
+			"@includeFirst(['part'])@push('s')c@endpush@prepend('s')p2@endprepend",
+		"part.legit": "@push('s')b@endpush@prepend('s')pi@endprepend|@stack('s')|",
+	})
+
+	out, err := e.RenderString("page", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "[p2pip1abc]|p2pip1abc|"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_RenderContext(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"form.legit": "{{ $title }}|@error('email'){{ $message }}@enderror|@error('name')x@enderror|@old('email')|@old('name')",
+	})
+
+	ctx := runtime.NewContext()
+	ctx.Set("title", "Sign up")
+	ctx.SetErrors(map[string][]string{"email": {"Email is invalid"}})
+	ctx.SetOld(map[string]interface{}{"email": "ada@example"})
+
+	var buf strings.Builder
+	if err := e.RenderContext(&buf, "form", ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "Sign up|Email is invalid||ada@example|"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestEngine_OldArrayInput(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"form.legit": "<input value=\"@old('name')\">" +
+			"@foreach($roles as $role)<option @selected(inArray($role, old('roles', [])))>{{ $role }}</option>@endforeach" +
+			"|{{ old('missing', 'none') }}",
+	})
+
+	ctx := runtime.NewContext()
+	ctx.Set("roles", []string{"admin", "editor", "viewer"})
+	ctx.SetOld(map[string]interface{}{
+		"name":  "Ada",
+		"roles": []string{"admin", "viewer"},
+	})
+
+	var buf strings.Builder
+	if err := e.RenderContext(&buf, "form", ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `<input value="Ada"><option selected>admin</option><option >editor</option><option selected>viewer</option>|none`
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	if ctx.GetOld("name") != "Ada" || len(ctx.GetOldValue("roles").([]string)) != 2 {
+		t.Error("unexpected old input accessors")
+	}
+}
+
+func TestEngine_Flash(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "@flash('success')<p>{{ $message }}</p>@endflash" +
+			"@flash('error')<p>{{ $message }}</p>@endflash" +
+			"@foreach($items as $item)@flash('success')[{{ $item }}]@endflash@endforeach",
+	})
+
+	ctx := runtime.NewContext()
+	ctx.Set("items", []string{"a"})
+	ctx.Flash("success", "Profile <saved>")
+
+	var buf strings.Builder
+	if err := e.RenderContext(&buf, "page", ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<p>Profile &lt;saved&gt;</p>[a]"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	out, err := e.RenderString("page", map[string]interface{}{"items": []string{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "" {
+		t.Errorf("expected no output without flash messages, got %q", out)
+	}
+}
+
+func TestEngine_SharedAccessors(t *testing.T) {
+	e := New(t.TempDir())
+	e.Share("title", "Site")
+
+	out, err := e.RenderTemplate("{{ $title }}|{{ shared('title') }}|{{ $shared['title'] }}", map[string]interface{}{"title": "Page"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "Page|Site|Site" {
+		t.Errorf("expected 'Page|Site|Site', got %q", out)
+	}
+
+	out, err = e.RenderTemplate("{{ $shared }}|{{ shared('title') }}", map[string]interface{}{"shared": "mine"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "mine|Site" {
+		t.Errorf("expected call data to keep its shared key, got %q", out)
+	}
+}
+
+func TestEngine_CloneEngineFunctions(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"site.legit": "{{ shared('site') }}",
+	})
+	e.Share("site", "main")
+
+	if out, err := e.RenderString("site", nil); err != nil || out != "main" {
+		t.Fatalf("expected original to render main, got %q (%v)", out, err)
+	}
+
+	clone := e.Clone()
+	clone.Share("site", "tenant")
+
+	if out, err := clone.RenderString("site", nil); err != nil || out != "tenant" {
+		t.Errorf("expected clone to render its own shared data, got %q (%v)", out, err)
+	}
+	if out, err := e.RenderString("site", nil); err != nil || out != "main" {
+		t.Errorf("expected original to keep its shared data, got %q (%v)", out, err)
+	}
+}
+
+func TestEngine_CloneIndependence(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":  "{{ $name }}",
+		"greet.legit": "{{ greet($name) }}",
+	})
+	e.Share("site", "main")
+
+	if _, err := e.RenderString("page", map[string]interface{}{"name": "a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clone := e.Clone()
+	if _, err := clone.RenderString("page", map[string]interface{}{"name": "a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clone.AddFunction("greet", func(s string) string { return "hello " + s })
+	clone.Share("site", "tenant")
+
+	out, err := clone.RenderString("greet", map[string]interface{}{"name": "ada"})
+	if err != nil || out != "hello ada" {
+		t.Errorf("expected clone to use its function, got %q (%v)", out, err)
+	}
+	if e.HasFunction("greet") {
+		t.Error("expected function added to the clone not to affect the original")
+	}
+	if _, err := e.RenderString("greet", map[string]interface{}{"name": "ada"}); err == nil {
+		t.Error("expected original to fail without the clone's function")
+	}
+	if out, _ := e.RenderTemplate("{{ $site }}", nil); out != "main" {
+		t.Errorf("expected original shared data to be unchanged, got %q", out)
+	}
+
+	e.AddFunction("shout", strings.ToUpper)
+	if clone.HasFunction("shout") {
+		t.Error("expected function added to the original not to affect the clone")
+	}
+}
+
+func TestEngine_WithOverrides(t *testing.T) {
+	e := newTestEngine(t, map[string]string{"page.legit": "@csrf"})
+	variant := e.WithOverrides(WithCSRFFieldName("csrf"))
+
+	data := map[string]interface{}{"csrf_token": "t"}
+	if out, _ := variant.RenderString("page", data); !strings.Contains(out, `name="csrf"`) {
+		t.Errorf("expected overridden field name, got %q", out)
+	}
+	if out, _ := e.RenderString("page", data); !strings.Contains(out, `name="_token"`) {
+		t.Errorf("expected original field name, got %q", out)
+	}
+}
+
+func TestEngine_Allow(t *testing.T) {
+	e := New(t.TempDir())
+	tmpl := "@allow('manage-users')<a>Users</a>@endallow|@allow($perm)<a>Billing</a>@endallow"
+
+	if out, _ := e.RenderTemplate(tmpl, map[string]interface{}{"perm": "billing"}); out != "|" {
+		t.Errorf("expected permissions denied without a gate, got %q", out)
+	}
+
+	e.SetGate(func(permission string, data map[string]interface{}) bool {
+		return permission == "manage-users" && data["role"] == "admin"
+	})
+
+	out, err := e.RenderTemplate(tmpl, map[string]interface{}{"role": "admin", "perm": "billing"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "<a>Users</a>|" {
+		t.Errorf("expected only manage-users allowed, got %q", out)
+	}
+
+	if out, _ := e.RenderTemplate(tmpl, map[string]interface{}{"role": "guest", "perm": "billing"}); out != "|" {
+		t.Errorf("expected permissions denied for guest, got %q", out)
+	}
+}
+
+func TestEngine_Feature(t *testing.T) {
+	flags := map[string]bool{"new-checkout": true}
+	e := New(t.TempDir(), WithFeatureResolver(func(flag string) bool { return flags[flag] }))
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"@feature('new-checkout')new@endfeature", "new"},
+		{"@feature('dark-mode')dark@endfeature", ""},
+		{"@feature('new-checkout')new@else old@endfeature", "new"},
+		{"@feature('dark-mode')new@else old@endfeature", " old"},
+		{"@feature('dark-mode')new@featureelse old@endfeature", " old"},
+		{"@feature('dark-mode')@if(true)a@else b@endif@else c@endfeature", " c"},
+	}
+
+	for _, tt := range tests {
+		out, err := e.RenderTemplate(tt.template, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.template, err)
+		}
+		if out != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.template, tt.expected, out)
+		}
+	}
+
+	if out, _ := New(t.TempDir()).RenderTemplate("@feature('new-checkout')new@else old@endfeature", nil); out != " old" {
+		t.Errorf("expected flags off without a resolver, got %q", out)
+	}
+}
+
+func TestEngine_Dump(t *testing.T) {
+	e := New(t.TempDir())
+	data := map[string]interface{}{
+		"user": map[string]interface{}{
+			"name": "<b>Ada</b>",
+			"tags": []string{"a", "b"},
+		},
+	}
+
+	out, err := e.RenderTemplate("@dump($user)", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "<pre>{\n" +
+		"  &#34;name&#34;: &#34;&lt;b&gt;Ada&lt;/b&gt;&#34;,\n" +
+		"  &#34;tags&#34;: [\n" +
+		"    &#34;a&#34;,\n" +
+		"    &#34;b&#34;\n" +
+		"  ]\n" +
+		"}</pre>"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_RawEcho(t *testing.T) {
+	e := New(t.TempDir())
+	data := map[string]interface{}{
+		"html":  "<b>bold</b>",
+		"safe":  template.HTML("<i>safe</i>"),
+		"count": 3,
+		"none":  nil,
+	}
+
+	out, err := e.RenderTemplate("{!! $html !!}|{!! $safe !!}|{!! $count !!}|{!! $none !!}|{{ $html }}", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<b>bold</b>|<i>safe</i>|3||&lt;b&gt;bold&lt;/b&gt;"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	sources := map[string]string{
+		"page":            `@component("card")body@slot("title")<em>{{ $name }}</em>@endslot@endcomponent`,
+		"components/card": "<h2>{!! $title !!}</h2>",
+	}
+	if out := executeSet(t, e, []string{"page", "components/card"}, sources, map[string]interface{}{"name": "<x>"}); out != "<h2><em>&lt;x&gt;</em></h2>" {
+		t.Errorf("expected named slot to be output raw, got %q", out)
+	}
+}
+
+func TestEngine_RawGuard(t *testing.T) {
+	scriptRe := regexp.MustCompile(`(?is)<script.*?</script>`)
+	e := New(t.TempDir(), WithRawGuard(func(content string) (string, bool) {
+		if strings.Contains(content, "javascript:") {
+			return "", false
+		}
+		return scriptRe.ReplaceAllString(content, ""), true
+	}))
+
+	data := map[string]interface{}{
+		"bio":  "<b>hi</b><script>alert(1)</script>",
+		"link": `<a href="javascript:x">x</a>`,
+	}
+	out, err := e.RenderTemplate("{!! $bio !!}|{!! $link !!}", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<b>hi</b>|&lt;a href=&#34;javascript:x&#34;&gt;x&lt;/a&gt;"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	if out, _ := New(t.TempDir()).RenderTemplate("{!! $bio !!}", data); out != data["bio"] {
+		t.Errorf("expected raw output without a guard, got %q", out)
+	}
+}
+
+func TestEngine_Include(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":            "<main>@include('partials.header', ['title' => 'Home'])@includeWhen($show, 'partials.note')@includeWhen($hide, 'partials.note')@includeIf('partials.missing')</main>",
+		"partials/header.legit": "<h1>{{ $title }}</h1>@include('partials.nav')",
+		"partials/nav.legit":    "<nav>{{ $user }}</nav>",
+		"partials/note.legit":   "<p>note</p>",
+	})
+
+	out, err := e.RenderString("page", map[string]interface{}{"user": "ada", "show": true, "hide": false})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<main><h1>Home</h1><nav>ada</nav><p>note</p></main>"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_IncludeInLoop(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":          "@foreach($items as $item)@include('partials.item')@include('partials.item', ['title' => $label])@endforeach",
+		"partials/item.legit": "[{{ $title }}:{{ $item }}]",
+	})
+
+	out, err := e.RenderString("page", map[string]interface{}{"title": "list", "label": "row", "items": []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "[list:a][row:a][list:b][row:b]"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_IncludeMissing(t *testing.T) {
+	e := newTestEngine(t, map[string]string{"page.legit": "@include('partials.missing')"})
+
+	if _, err := e.RenderString("page", nil); err == nil || !strings.Contains(err.Error(), "partials.missing") {
+		t.Errorf("expected error naming the missing partial, got %v", err)
+	}
+}
+
+func TestEngine_TemplateSet(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"layouts/app.legit":      "<body>@include('partials.nav')@yield('content')@include('partials.footer')</body>",
+		"page.legit":             "@extends('layouts.app')@section('content')@component('alert', ['type' => 'info'])Hi {{ $name }}@endcomponent@each('partials.item', $items, 'item')@endsection",
+		"partials/nav.legit":     "<nav>{{ $name }}</nav>",
+		"partials/footer.legit":  "<footer>@include('partials.nav')</footer>",
+		"partials/item.legit":    "<i>{{ $item }}</i>",
+		"components/alert.legit": "<div class=\"{{ $type }}\">{{ $slot }}</div>",
+	})
+
+	out, err := e.RenderString("page", map[string]interface{}{"name": "ada", "items": []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `<body><nav>ada</nav><div class="info">Hi ada</div><i>a</i><i>b</i><footer><nav>ada</nav></footer></body>`
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_TemplateSetInLoop(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":           "@foreach($groups as $group)<ul>@include('partials.group')@each('partials.tag', $tags, 'tag')</ul>@endforeach",
+		"partials/group.legit": "<li>{{ $group }}@include('partials.owner')</li>",
+		"partials/owner.legit": "<b>{{ $owner }}</b>",
+		"partials/tag.legit":   "<i>{{ $tag }}</i>",
+	})
+
+	data := map[string]interface{}{
+		"groups": []string{"a", "b"},
+		"tags":   []string{"x", "y"},
+		"owner":  "ada",
+	}
+	out, err := e.RenderString("page", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "<ul><li>a<b>ada</b></li><i>x</i><i>y</i></ul><ul><li>b<b>ada</b></li><i>x</i><i>y</i></ul>"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestEngine_ComponentsPath(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":             "@component('alert')Hi@endcomponent|@component('forms.input', ['name' => 'email'])@endcomponent",
+		"ui/alert.legit":         "<div>{{ $slot }}</div>",
+		"ui/forms/input.legit":   `<input name="{{ $name }}">`,
+		"components/alert.legit": "default path",
+	}, WithComponentsPath("ui/"))
+
+	out, err := e.RenderString("page", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `<div>Hi</div>|<input name="email">`; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	admin := newTestEngine(t, map[string]string{
+		"page.legit":           "@component('admin::badge')new@endcomponent",
+		"admin/ui/badge.legit": "<b>{{ $slot }}</b>",
+	}, WithComponentsPath("ui"))
+	admin.AddNamespace("admin", filepath.Join(admin.viewsPath, "admin"))
+
+	if out, err := admin.RenderString("page", nil); err != nil || out != "<b>new</b>" {
+		t.Errorf("expected namespaced component, got %q (%v)", out, err)
+	}
+}
+
+func TestEngine_Extensions(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit":         "page @include('partials.nav')",
+		"partials/nav.html":  "<nav>html</nav>",
+		"old/home.blade.php": "blade",
+		"old/home.html":      "shadowed",
+		"notes.txt":          "ignored",
+	}, WithExtensions(".legit", "html", ".blade.php"))
+
+	if !e.Exists("partials.nav") || e.Exists("notes") {
+		t.Error("unexpected Exists result")
+	}
+
+	out, err := e.RenderString("page", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "page <nav>html</nav>" {
+		t.Errorf("expected partial from the second extension, got %q", out)
+	}
+
+	if out, _ := e.RenderString("old.home", nil); out != "shadowed" {
+		t.Errorf("expected the earlier extension to win, got %q", out)
+	}
+
+	templates, err := e.Templates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(templates, ",") != "old.home,page,partials.nav" {
+		t.Errorf("unexpected templates %v", templates)
+	}
+}
+
+func TestEngine_PanicRecovery(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"func.legit":      "line\n{{ boom() }}",
+		"directive.legit": "@explode('x')",
+	})
+	e.AddFunction("boom", func() string { panic("kaboom") })
+	e.AddDirective("explode", func(args string, data map[string]interface{}) string { panic("bad directive") })
+
+	_, err := e.RenderString("func", nil)
+	var engineErr *EngineError
+	if !errors.As(err, &engineErr) || engineErr.Template != "func" || engineErr.Line != 2 || !strings.Contains(engineErr.Message, "boom") {
+		t.Errorf("expected error naming the template and function, got %v", err)
+	}
+
+	_, err = e.RenderString("directive", nil)
+	if !errors.As(err, &engineErr) || engineErr.Template != "directive" || !strings.Contains(engineErr.Message, "bad directive") {
+		t.Errorf("expected recovered panic naming the template, got %v", err)
+	}
+	if err.Error() != "panic: bad directive in directive" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+
+	if _, err := e.RenderTemplate("@explode('x')", nil); err == nil {
+		t.Error("expected recovered panic from RenderTemplate")
+	}
+}
+
+func TestEngine_PseudoMethods(t *testing.T) {
+	e := New(t.TempDir())
+	tmpl := "@if($users->count() > 1){{ $users->count() }}@endif" +
+		"@if($roles->isEmpty())none@endif" +
+		"@if($tags->isNotEmpty())tags@endif" +
+		"@php($active = collect($users))@if($active->isNotEmpty()){{ $active->count() }}@endif"
+	data := map[string]interface{}{
+		"users": []string{"ada", "bob"},
+		"roles": map[string]interface{}{},
+		"tags":  []interface{}{"go"},
+	}
+
+	out, err := e.RenderTemplate(tmpl, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "2nonetags2" {
+		t.Errorf("expected %q, got %q", "2nonetags2", out)
+	}
+}
+
+type presence struct{ found bool }
+
+func (p presence) Exists() bool { return p.found }
+
+func TestEngine_ExistsPseudoMethodUsesMethod(t *testing.T) {
+	e := New(t.TempDir())
+	tmpl := "@if($hit->exists())hit@endif|@if($miss->exists())miss@endif|@if($tags->exists())tags@endif"
+	data := map[string]interface{}{
+		"hit":  presence{found: true},
+		"miss": presence{found: false},
+		"tags": []string{"go"},
+	}
+
+	out, err := e.RenderTemplate(tmpl, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "hit||tags" {
+		t.Errorf("expected %q, got %q", "hit||tags", out)
+	}
+}
+
+func TestEngine_ForelseBreak(t *testing.T) {
+	e := New(t.TempDir())
+	tmpl := "@forelse($items as $item)@break($loop->index > 2){{ $item }}@empty none@endforelse"
+
+	tests := []struct {
+		items    []int
+		expected string
+	}{
+		{[]int{1, 2, 3, 4, 5}, "123"},
+		{[]int{1, 2}, "12"},
+		{[]int{}, " none"},
+	}
+	for _, tt := range tests {
+		out, err := e.RenderTemplate(tmpl, map[string]interface{}{"items": tt.items})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out != tt.expected {
+			t.Errorf("%v: expected %q, got %q", tt.items, tt.expected, out)
+		}
+	}
+
+	nested := "@foreach($groups as $g)[@forelse($g as $i)@continue($loop->first){{ $i }}@empty @continue empty@endforelse]@endforeach"
+	out, err := e.RenderTemplate(nested, map[string]interface{}{"groups": [][]int{{1, 2, 3}, {}, {4}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "[23][ []" {
+		t.Errorf("expected %q, got %q", "[23][ []", out)
+	}
+}
+
+func TestEngine_NestedLoopParent(t *testing.T) {
+	e := New(t.TempDir())
+	tmpl := "@foreach($rows as $row)@foreach($row as $cell)" +
+		"{{ $loop->parent->index }}.{{ $loop->index }}:{{ $loop->depth }} " +
+		"@endforeach@endforeach"
+
+	out, err := e.RenderTemplate(tmpl, map[string]interface{}{
+		"rows": [][]string{{"a", "b"}, {"c"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "0.0:2 0.1:2 1.0:2 "; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
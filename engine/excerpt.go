@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var tagRe = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]*>`)
+
+// voidElements are HTML elements without a closing tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// excerpt strips the tags from an HTML fragment and limits the text to n
+// words, appending suffix (default "...") when it was cut
+func excerpt(s string, n int, suffix ...string) string {
+	text := html.UnescapeString(tagRe.ReplaceAllString(s, " "))
+	return wordLimit(strings.Join(strings.Fields(text), " "), n, suffix...)
+}
+
+// limitHTML limits an HTML fragment to n characters of text, appending
+// suffix (default "...") when it was cut. Tags and entities are never split
+// and tags left open at the cut are closed. The fragment is trusted and
+// returned unescaped, as with raw.
+func limitHTML(s string, n int, suffix ...string) template.HTML {
+	var out strings.Builder
+	var open []string
+	count := 0
+
+	for i := 0; i < len(s); {
+		if count == n {
+			end := "..."
+			if len(suffix) > 0 {
+				end = suffix[0]
+			}
+			if strings.TrimSpace(tagRe.ReplaceAllString(s[i:], "")) == "" {
+				// Only markup remains, so nothing was cut
+				out.WriteString(s[i:])
+				return template.HTML(out.String())
+			}
+			out.WriteString(end)
+			for j := len(open) - 1; j >= 0; j-- {
+				out.WriteString("</" + open[j] + ">")
+			}
+			return template.HTML(out.String())
+		}
+
+		switch s[i] {
+		case '<':
+			loc := tagRe.FindStringIndex(s[i:])
+			if loc == nil || loc[0] != 0 {
+				out.WriteString("&lt;")
+				i++
+				count++
+				continue
+			}
+			tag := s[i : i+loc[1]]
+			open = trackTag(open, tag)
+			out.WriteString(tag)
+			i += loc[1]
+		case '&':
+			end := strings.IndexByte(s[i:], ';')
+			if end > 0 && end <= 10 && !strings.ContainsAny(s[i+1:i+end], " <&") {
+				out.WriteString(s[i : i+end+1])
+				i += end + 1
+			} else {
+				out.WriteByte('&')
+				i++
+			}
+			count++
+		default:
+			r, size := utf8.DecodeRuneInString(s[i:])
+			out.WriteRune(r)
+			i += size
+			count++
+		}
+	}
+
+	return template.HTML(out.String())
+}
+
+// trackTag updates the stack of open elements for a tag
+func trackTag(open []string, tag string) []string {
+	if strings.HasPrefix(tag, "<!") || strings.HasPrefix(tag, "<?") || strings.HasSuffix(tag, "/>") {
+		return open
+	}
+
+	closing := strings.HasPrefix(tag, "</")
+	name := strings.TrimLeft(tag, "</")
+	if end := strings.IndexAny(name, " \t\n\r/>"); end >= 0 {
+		name = name[:end]
+	}
+	name = strings.ToLower(name)
+	if name == "" || voidElements[name] {
+		return open
+	}
+
+	if !closing {
+		return append(open, name)
+	}
+	for j := len(open) - 1; j >= 0; j-- {
+		if open[j] == name {
+			return open[:j]
+		}
+	}
+	return open
+}
@@ -0,0 +1,34 @@
+package engine
+
+import "testing"
+
+func TestExcerpt(t *testing.T) {
+	got := excerpt("<p>The <strong>quick</strong> brown&nbsp;fox</p><p>jumps over</p>", 4)
+	if expected := "The quick brown fox..."; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if got := excerpt("<em>Short</em> text", 5); got != "Short text" {
+		t.Errorf("expected untouched text, got %q", got)
+	}
+}
+
+func TestLimitHTML(t *testing.T) {
+	tests := []struct {
+		input    string
+		n        int
+		expected string
+	}{
+		{"<p>Hello <strong>bold world</strong>!</p>", 10, "<p>Hello <strong>bold...</strong></p>"},
+		{"<p>Fish &amp; chips</p>", 6, "<p>Fish &amp;...</p>"},
+		{"a<br>b<img src=\"x.png\"/>cdef", 3, "a<br>b<img src=\"x.png\"/>c..."},
+		{"<p>Short</p>", 10, "<p>Short</p>"},
+		{"<p>Exact</p>", 5, "<p>Exact</p>"},
+		{"<p>日本語のテキスト</p>", 3, "<p>日本語...</p>"},
+	}
+
+	for _, tt := range tests {
+		if got := string(limitHTML(tt.input, tt.n)); got != tt.expected {
+			t.Errorf("limitHTML(%q, %d): expected %q, got %q", tt.input, tt.n, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WithFileSystem reads templates from the given file system (e.g. embed.FS)
+// instead of the OS file system. Template paths are resolved relative to
+// the root of fsys using slash-separated names. Cached templates are
+// validated by checksum since embedded files carry no modification times.
+func WithFileSystem(fsys fs.FS) Option {
+	return func(e *Engine) {
+		e.fsys = fsys
+		e.checksum = true
+	}
+}
+
+// readFile reads a template file
+func (e *Engine) readFile(name string) ([]byte, error) {
+	if e.fsys == nil {
+		return os.ReadFile(name)
+	}
+	return fs.ReadFile(e.fsys, name)
+}
+
+// statFile returns file info for a template file
+func (e *Engine) statFile(name string) (fs.FileInfo, error) {
+	if e.fsys == nil {
+		return os.Stat(name)
+	}
+	return fs.Stat(e.fsys, name)
+}
+
+// separator returns the path separator of the template file system
+func (e *Engine) separator() string {
+	if e.fsys == nil {
+		return string(filepath.Separator)
+	}
+	return "/"
+}
+
+// joinPath joins path elements for the template file system
+func (e *Engine) joinPath(elem ...string) string {
+	if e.fsys == nil {
+		return filepath.Join(elem...)
+	}
+	return path.Join(elem...)
+}
+
+// walkFiles calls fn with the path of each file under root,
+// relative to root and using the file system's separator
+func (e *Engine) walkFiles(root string, fn func(rel string) error) error {
+	if e.fsys == nil {
+		return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			return fn(rel)
+		})
+	}
+
+	root = path.Clean(root)
+	return fs.WalkDir(e.fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if root == "." {
+			return fn(p)
+		}
+		return fn(strings.TrimPrefix(p, root+"/"))
+	})
+}
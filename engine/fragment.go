@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+)
+
+// FragmentCache stores the rendered output of @cache blocks
+type FragmentCache interface {
+	// Get returns the cached output for key, if present and not expired
+	Get(key string) (string, bool)
+	// Set stores output for key. A ttl of zero or less never expires.
+	Set(key, value string, ttl time.Duration)
+}
+
+// DefaultFragmentLimit is the number of fragments a MemoryFragmentCache
+// holds before evicting the least recently used
+const DefaultFragmentLimit = 10000
+
+// MemoryFragmentCache is an in-memory FragmentCache. It holds at most
+// DefaultFragmentLimit fragments unless changed with SetLimit.
+type MemoryFragmentCache struct {
+	entries map[string]*list.Element
+	order   *list.List
+	limit   int
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+// fragmentEntry is a cached fragment and its expiry (zero for none)
+type fragmentEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// NewMemoryFragmentCache creates an empty in-memory fragment cache
+func NewMemoryFragmentCache() *MemoryFragmentCache {
+	return &MemoryFragmentCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		limit:   DefaultFragmentLimit,
+		now:     time.Now,
+	}
+}
+
+// SetLimit sets the maximum number of cached fragments (0 means
+// unlimited). Least-recently-used fragments are evicted when the limit is
+// exceeded.
+func (c *MemoryFragmentCache) SetLimit(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = n
+	c.evict()
+}
+
+// SetClock sets the clock that expiry is checked against (default:
+// time.Now)
+func (c *MemoryFragmentCache) SetClock(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Get returns the cached output for key. Expired entries are removed.
+func (c *MemoryFragmentCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*fragmentEntry)
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set stores output for key
+func (c *MemoryFragmentCache) Set(key, value string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &fragmentEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = c.now().Add(ttl)
+	}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+	} else {
+		c.entries[key] = c.order.PushFront(entry)
+	}
+	c.evict()
+}
+
+// evict removes least-recently-used fragments beyond the limit. Callers
+// must hold the mutex.
+func (c *MemoryFragmentCache) evict() {
+	if c.limit <= 0 {
+		return
+	}
+
+	for c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*fragmentEntry).key)
+	}
+}
+
+// Clear removes all cached fragments
+func (c *MemoryFragmentCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// WithFragmentCache sets the store for @cache blocks (default: in memory,
+// expiring fragments by the engine clock)
+func WithFragmentCache(store FragmentCache) Option {
+	return func(e *Engine) {
+		e.fragments = store
+	}
+}
+
+// cacheFragment renders the slot holding a @cache block, or returns its
+// cached output. The cache key combines key with a hash of the JSON
+// encoding of vary, the values of the variables the block refers to, so
+// equal values share a key whatever their pointers. Blocks whose values
+// cannot be encoded are rendered without caching.
+func (e *Engine) cacheFragment(tmpl *template.Template, key interface{}, ttl interface{}, vary map[string]interface{}, slot string, data interface{}) (template.HTML, error) {
+	render := func() (string, error) {
+		var buf bytes.Buffer
+		err := tmpl.ExecuteTemplate(&buf, slot, data)
+		return buf.String(), err
+	}
+
+	encoded, err := json.Marshal(vary)
+	if err != nil {
+		out, err := render()
+		return template.HTML(out), err
+	}
+	hash := sha256.Sum256(encoded)
+	fullKey := fmt.Sprint(key) + ":" + hex.EncodeToString(hash[:8])
+
+	if out, ok := e.fragments.Get(fullKey); ok {
+		return template.HTML(out), nil
+	}
+
+	out, err := render()
+	if err != nil {
+		return "", err
+	}
+
+	e.fragments.Set(fullKey, out, fragmentTTL(ttl))
+	return template.HTML(out), nil
+}
+
+// fragmentTTL converts a @cache ttl, given in seconds or as a duration
+// string such as "10m", to a duration
+func fragmentTTL(ttl interface{}) time.Duration {
+	switch t := ttl.(type) {
+	case time.Duration:
+		return t
+	case string:
+		if d, err := time.ParseDuration(t); err == nil {
+			return d
+		}
+	}
+	return time.Duration(toFloat64(ttl) * float64(time.Second))
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"html/template"
+	"testing"
+	"time"
+)
+
+func TestEngine_CacheFragment(t *testing.T) {
+	store := NewMemoryFragmentCache()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	calls := 0
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "<p>@cache('stats', 60){{ $name }}:{{ tick() }}@endcache</p>",
+	}, WithFragmentCache(store), WithFunctions(template.FuncMap{
+		"tick": func() int { calls++; return calls },
+	}))
+
+	render := func(name string) string {
+		t.Helper()
+		out, err := e.RenderString("page", map[string]interface{}{"name": name})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out
+	}
+
+	if out := render("ada"); out != "<p>ada:1</p>" {
+		t.Fatalf("expected first render to compute the block, got %q", out)
+	}
+
+	now = now.Add(30 * time.Second)
+	if out := render("ada"); out != "<p>ada:1</p>" {
+		t.Errorf("expected cached block within the TTL, got %q", out)
+	}
+	if out := render("bob"); out != "<p>bob:2</p>" {
+		t.Errorf("expected different data to compute the block, got %q", out)
+	}
+
+	now = now.Add(31 * time.Second)
+	if out := render("ada"); out != "<p>ada:3</p>" {
+		t.Errorf("expected stale block to be recomputed, got %q", out)
+	}
+}
+
+func TestEngine_CacheFragmentVaryByValue(t *testing.T) {
+	type user struct{ Name string }
+
+	calls := 0
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "@cache('profile'){{ $user->Name }}:{{ tick() }}@endcache",
+	}, WithFunctions(template.FuncMap{
+		"tick": func() int { calls++; return calls },
+	}))
+
+	render := func(u *user) string {
+		t.Helper()
+		out, err := e.RenderString("page", map[string]interface{}{"user": u})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out
+	}
+
+	if out := render(&user{Name: "ada"}); out != "ada:1" {
+		t.Fatalf("unexpected first render %q", out)
+	}
+	if out := render(&user{Name: "ada"}); out != "ada:1" {
+		t.Errorf("expected an equal value behind a new pointer to hit the cache, got %q", out)
+	}
+	if out := render(&user{Name: "bob"}); out != "bob:2" {
+		t.Errorf("expected a different value to miss the cache, got %q", out)
+	}
+}
+
+func TestMemoryFragmentCache_Limit(t *testing.T) {
+	store := NewMemoryFragmentCache()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	store.SetLimit(2)
+
+	store.Set("short", "s", time.Second)
+	store.Set("a", "a", 0)
+	now = now.Add(2 * time.Second)
+	if _, ok := store.Get("short"); ok {
+		t.Error("expected the fragment to expire")
+	}
+
+	store.Set("b", "b", 0)
+	if _, ok := store.Get("a"); !ok {
+		t.Error("expected the expired fragment to have made room")
+	}
+
+	store.Set("c", "c", 0)
+	if _, ok := store.Get("b"); ok {
+		t.Error("expected the least recently used fragment to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := store.Get(key); !ok {
+			t.Errorf("expected %q to be kept", key)
+		}
+	}
+}
+
+func TestEngine_CacheFragmentUsesClock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "@cache('stats', 60){{ tick() }}@endcache",
+	}, WithClock(func() time.Time { return now }), WithFunctions(template.FuncMap{
+		"tick": func() int { calls++; return calls },
+	}))
+
+	render := func() string {
+		t.Helper()
+		out, err := e.RenderString("page", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out
+	}
+
+	render()
+	now = now.Add(30 * time.Second)
+	if out := render(); out != "1" {
+		t.Errorf("expected cached block before the clock passes the TTL, got %q", out)
+	}
+	now = now.Add(31 * time.Second)
+	if out := render(); out != "2" {
+		t.Errorf("expected the block to expire by the engine clock, got %q", out)
+	}
+}
+
+func TestFragmentTTL(t *testing.T) {
+	tests := map[interface{}]time.Duration{
+		60:     time.Minute,
+		"90":   90 * time.Second,
+		"10m":  10 * time.Minute,
+		0.5:    500 * time.Millisecond,
+		"none": 0,
+	}
+	for ttl, expected := range tests {
+		if got := fragmentTTL(ttl); got != expected {
+			t.Errorf("fragmentTTL(%v): expected %v, got %v", ttl, expected, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -8,8 +9,10 @@ import (
 	"net/url"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/codingersid/legit-template/runtime"
 )
@@ -18,27 +21,41 @@ import (
 func DefaultFunctions() template.FuncMap {
 	return template.FuncMap{
 		// String functions
-		"upper":     strings.ToUpper,
-		"lower":     strings.ToLower,
-		"title":     strings.Title,
-		"trim":      strings.TrimSpace,
-		"ltrim":     strings.TrimLeft,
-		"rtrim":     strings.TrimRight,
-		"replace":   strings.ReplaceAll,
-		"contains":  strings.Contains,
-		"hasPrefix": strings.HasPrefix,
-		"hasSuffix": strings.HasSuffix,
-		"split":     strings.Split,
-		"join":      strings.Join,
-		"repeat":    strings.Repeat,
-		"substr":    substr,
-		"length":    length,
-		"nl2br":     nl2br,
-		"ucfirst":   ucfirst,
-		"lcfirst":   lcfirst,
-		"slug":      slug,
-		"limit":     limit,
-		"wordLimit": wordLimit,
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      strings.Title,
+		"trim":       strings.TrimSpace,
+		"ltrim":      strings.TrimLeft,
+		"rtrim":      strings.TrimRight,
+		"replace":    strings.ReplaceAll,
+		"contains":   strings.Contains,
+		"hasPrefix":  strings.HasPrefix,
+		"hasSuffix":  strings.HasSuffix,
+		"split":      strings.Split,
+		"splitN":     strings.SplitN,
+		"splitLines": splitLines,
+		"words":      strings.Fields,
+		"join":       join,
+		"repeat":     strings.Repeat,
+		"substr":     substr,
+		"length":     length,
+		"nl2br":      nl2br,
+		"ucfirst":    ucfirst,
+		"lcfirst":    lcfirst,
+		"slug":       slug,
+		"limit":      limit,
+		"wordLimit":  wordLimit,
+		"padLeft":    padLeft,
+		"padRight":   padRight,
+		"center":     center,
+		"mask":       mask,
+		"excerpt":    excerpt,
+		"limitHTML":  limitHTML,
+
+		// Regex functions
+		"replaceRegex": replaceRegex,
+		"match":        match,
+		"matchAll":     matchAll,
 
 		// HTML functions
 		"html":     template.HTMLEscapeString,
@@ -46,6 +63,7 @@ func DefaultFunctions() template.FuncMap {
 		"js":       template.JSEscapeString,
 		"url":      url.QueryEscape,
 		"safeHTML": safeHTML,
+		"raw":      raw,
 		"safeJS":   safeJS,
 		"safeURL":  safeURL,
 		"safeCSS":  safeCSS,
@@ -56,19 +74,34 @@ func DefaultFunctions() template.FuncMap {
 		"reverse":  reverse,
 		"sortAsc":  sortAsc,
 		"sortDesc": sortDesc,
+		"sortBy":   sortBy,
 		"unique":   unique,
 		"pluck":    pluck,
 		"where":    where,
+		"whereOp":  whereOp,
 		"groupBy":  groupBy,
 		"chunk":    chunk,
+		"paginate": paginate,
+		"collect":  runtime.Collect,
 		"flatten":  flatten,
 		"slice":    sliceFunc,
 		"append":   appendFunc,
 		"prepend":  prependFunc,
 		"merge":    mergeFunc,
+		"inArray":  inArray,
+
+		"groupByOrdered": groupByOrdered,
+
+		// Component functions
+		"componentData":    componentData,
+		"newSlot":          newSlot,
+		"aware":            aware,
+		"attributesExcept": attributesExcept,
+		"slot":             slotOr,
 
 		// Map functions
 		"dict":   dict,
+		"list":   listFunc,
 		"set":    setInMap,
 		"unset":  unsetInMap,
 		"keys":   keys,
@@ -76,20 +109,29 @@ func DefaultFunctions() template.FuncMap {
 		"hasKey": hasKey,
 
 		// Number functions
-		"add":      add,
-		"sub":      sub,
-		"mul":      mul,
-		"div":      div,
-		"mod":      mod,
-		"round":    round,
-		"floor":    floor,
-		"ceil":     ceil,
-		"abs":      abs,
-		"min":      minFunc,
-		"max":      maxFunc,
-		"currency": currency,
-		"number":   number,
-		"percent":  percent,
+		"add":        add,
+		"sub":        sub,
+		"mul":        mul,
+		"div":        div,
+		"mod":        mod,
+		"round":      round,
+		"floor":      floor,
+		"ceil":       ceil,
+		"abs":        abs,
+		"min":        minFunc,
+		"max":        maxFunc,
+		"currency":   currency,
+		"number":     number,
+		"percent":    percent,
+		"parseFloat": parseFloat,
+
+		// Aggregate functions
+		"sum":     sum,
+		"sumBy":   sumBy,
+		"avgBy":   avgBy,
+		"countBy": countBy,
+		"minBy":   minBy,
+		"maxBy":   maxBy,
 
 		// Date functions
 		"date":      formatDate,
@@ -115,7 +157,9 @@ func DefaultFunctions() template.FuncMap {
 		"default":  defaultValue,
 		"isset":    isset,
 		"empty":    isEmpty,
+		"exists":   exists,
 		"dump":     dump,
+		"dumpHTML": dumpHTML,
 		"json":     jsonEncode,
 		"jsonDec":  jsonDecode,
 		"seq":      seq,
@@ -133,14 +177,33 @@ func DefaultFunctions() template.FuncMap {
 
 		// Loop helper
 		"newLoop": runtime.NewLoop,
+		"iterate": runtime.Iterate,
 
 		// Validation helpers
-		"hasError": hasError,
-		"getError": getError,
+		"hasError":  hasError,
+		"getError":  getError,
+		"getErrors": getErrors,
+		"old":       old,
+		"getFlash":  getFlash,
 
 		// Class/Style helpers
 		"classArray": classArray,
 		"styleArray": styleArray,
+
+		// Output helpers
+		"spaceless": spacelessMarker,
+
+		// Layout data
+		"extendData": extendData,
+
+		// Stacks
+		"stack":        stackMarker,
+		"pushStack":    pushStack,
+		"prependStack": prependStack,
+
+		// Response helpers
+		"setStatus": setStatus,
+		"setHeader": setHeader,
 	}
 }
 
@@ -169,7 +232,13 @@ func substr(s string, start int, length ...int) string {
 	return string(runes[start:end])
 }
 
+// length returns the number of runes in a string or elements in a
+// collection. Values with a Count method, such as collections, are counted
+// with it.
 func length(v interface{}) int {
+	if c, ok := v.(interface{ Count() int }); ok {
+		return c.Count()
+	}
 	rv := reflect.ValueOf(v)
 	switch rv.Kind() {
 	case reflect.String:
@@ -203,6 +272,38 @@ func lcfirst(s string) string {
 	return string(runes)
 }
 
+// splitLines splits s into lines, accepting \n and \r\n line endings. A
+// trailing line ending does not produce an empty last line.
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+// join concatenates the elements of any slice or array with sep, formatting
+// non-string elements with fmt.Sprint
+func join(v interface{}, sep string) string {
+	if strs, ok := v.([]string); ok {
+		return strings.Join(strs, sep)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(parts, sep)
+}
+
 func slug(s string) string {
 	s = strings.ToLower(s)
 	s = strings.TrimSpace(s)
@@ -241,12 +342,96 @@ func wordLimit(s string, n int, suffix ...string) string {
 	return strings.Join(words[:n], " ") + end
 }
 
+// padLeft pads s on the left with ch (default a space) to n characters
+func padLeft(s string, n int, ch ...string) string {
+	return strings.Repeat(padChar(ch, " "), padWidth(s, n)) + s
+}
+
+// padRight pads s on the right with ch (default a space) to n characters
+func padRight(s string, n int, ch ...string) string {
+	return s + strings.Repeat(padChar(ch, " "), padWidth(s, n))
+}
+
+// center pads s on both sides with ch (default a space) to n characters.
+// When the padding is uneven the extra character goes on the right.
+func center(s string, n int, ch ...string) string {
+	width := padWidth(s, n)
+	c := padChar(ch, " ")
+	return strings.Repeat(c, width/2) + s + strings.Repeat(c, width-width/2)
+}
+
+// mask replaces length characters of s from index from with ch (default
+// "*"). A negative from counts from the end of s, so mask $card 0 -4 hides
+// all but the last four digits; a negative length leaves that many
+// characters unmasked at the end.
+func mask(s string, from, length int, ch ...string) string {
+	runes := []rune(s)
+	if from < 0 {
+		from += len(runes)
+	}
+	if from < 0 {
+		from = 0
+	}
+	if from >= len(runes) {
+		return s
+	}
+
+	end := from + length
+	if length < 0 {
+		end = len(runes) + length
+	}
+	if end > len(runes) {
+		end = len(runes)
+	}
+	if end <= from {
+		return s
+	}
+
+	c := []rune(padChar(ch, "*"))[0]
+	for i := from; i < end; i++ {
+		runes[i] = c
+	}
+	return string(runes)
+}
+
+// padWidth returns how many characters s needs to reach n
+func padWidth(s string, n int) int {
+	if width := n - utf8.RuneCountInString(s); width > 0 {
+		return width
+	}
+	return 0
+}
+
+// padChar returns the first character of the optional ch argument, or def
+func padChar(ch []string, def string) string {
+	if len(ch) == 0 || ch[0] == "" {
+		return def
+	}
+	return string([]rune(ch[0])[0])
+}
+
 // HTML safe functions
 
 func safeHTML(s string) template.HTML {
 	return template.HTML(s)
 }
 
+// raw marks a value as HTML for {!! !!} output. Strings, slots and other
+// fmt.Stringer values are emitted without escaping.
+func raw(v interface{}) template.HTML {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case template.HTML:
+		return val
+	case string:
+		return template.HTML(val)
+	case fmt.Stringer:
+		return template.HTML(val.String())
+	}
+	return template.HTML(fmt.Sprint(v))
+}
+
 func safeJS(s string) template.JS {
 	return template.JS(s)
 }
@@ -261,22 +446,44 @@ func safeCSS(s string) template.CSS {
 
 // Array/Slice functions
 
+// first returns the first element of a slice or array, the first character
+// of a string, or the value at the lowest key of a map
 func first(v interface{}) interface{} {
 	rv := reflect.ValueOf(v)
-	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
 		if rv.Len() > 0 {
 			return rv.Index(0).Interface()
 		}
+	case reflect.String:
+		for _, r := range rv.String() {
+			return string(r)
+		}
+	case reflect.Map:
+		if pairs := runtime.Iterate(v); len(pairs) > 0 {
+			return pairs[0].Value
+		}
 	}
 	return nil
 }
 
+// last returns the last element of a slice or array, the last character of
+// a string, or the value at the highest key of a map
 func last(v interface{}) interface{} {
 	rv := reflect.ValueOf(v)
-	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
 		if rv.Len() > 0 {
 			return rv.Index(rv.Len() - 1).Interface()
 		}
+	case reflect.String:
+		if runes := []rune(rv.String()); len(runes) > 0 {
+			return string(runes[len(runes)-1])
+		}
+	case reflect.Map:
+		if pairs := runtime.Iterate(v); len(pairs) > 0 {
+			return pairs[len(pairs)-1].Value
+		}
 	}
 	return nil
 }
@@ -288,7 +495,7 @@ func reverse(v interface{}) interface{} {
 	}
 
 	length := rv.Len()
-	result := reflect.MakeSlice(rv.Type(), length, length)
+	result := reflect.MakeSlice(reflect.SliceOf(rv.Type().Elem()), length, length)
 	for i := 0; i < length; i++ {
 		result.Index(i).Set(rv.Index(length - 1 - i))
 	}
@@ -305,7 +512,7 @@ func sortAsc(v interface{}) interface{} {
 	reflect.Copy(sorted, rv)
 
 	sort.SliceStable(sorted.Interface(), func(i, j int) bool {
-		return fmt.Sprint(sorted.Index(i).Interface()) < fmt.Sprint(sorted.Index(j).Interface())
+		return runtime.Compare(sorted.Index(i).Interface(), sorted.Index(j).Interface()) < 0
 	})
 
 	return sorted.Interface()
@@ -321,7 +528,7 @@ func sortDesc(v interface{}) interface{} {
 	reflect.Copy(sorted, rv)
 
 	sort.SliceStable(sorted.Interface(), func(i, j int) bool {
-		return fmt.Sprint(sorted.Index(i).Interface()) > fmt.Sprint(sorted.Index(j).Interface())
+		return runtime.Compare(sorted.Index(i).Interface(), sorted.Index(j).Interface()) > 0
 	})
 
 	return sorted.Interface()
@@ -347,6 +554,29 @@ func unique(v interface{}) interface{} {
 	return result.Interface()
 }
 
+// sortBy sorts a slice of maps or structs by a key, ascending unless
+// order is "desc"
+func sortBy(v interface{}, key string, order ...string) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return v
+	}
+
+	sorted := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+	reflect.Copy(sorted, rv)
+
+	desc := len(order) > 0 && strings.EqualFold(order[0], "desc")
+	sort.SliceStable(sorted.Interface(), func(i, j int) bool {
+		cmp := runtime.Compare(runtime.Field(sorted.Index(i).Interface(), key), runtime.Field(sorted.Index(j).Interface(), key))
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	return sorted.Interface()
+}
+
 func pluck(v interface{}, key string) interface{} {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Slice {
@@ -371,32 +601,33 @@ func pluck(v interface{}, key string) interface{} {
 }
 
 func where(v interface{}, key string, value interface{}) interface{} {
+	result, _ := whereOp(v, key, "=", value)
+	return result
+}
+
+// whereOp filters a slice of maps or structs by comparing a key against
+// value with one of =, !=, >, >=, <, <=, in (value is a list) or contains
+// (the key holds a string or list)
+func whereOp(v interface{}, key, op string, value interface{}) (interface{}, error) {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Slice {
-		return nil
+		return nil, nil
+	}
+
+	match, err := runtime.Matcher(op, value)
+	if err != nil {
+		return nil, fmt.Errorf("whereOp: %w", err)
 	}
 
 	result := reflect.MakeSlice(rv.Type(), 0, rv.Len())
 	for i := 0; i < rv.Len(); i++ {
 		item := rv.Index(i)
-		var itemVal interface{}
-
-		if item.Kind() == reflect.Map {
-			if val := item.MapIndex(reflect.ValueOf(key)); val.IsValid() {
-				itemVal = val.Interface()
-			}
-		} else if item.Kind() == reflect.Struct {
-			if field := item.FieldByName(key); field.IsValid() {
-				itemVal = field.Interface()
-			}
-		}
-
-		if itemVal == value {
+		if match(runtime.Field(item.Interface(), key)) {
 			result = reflect.Append(result, item)
 		}
 	}
 
-	return result.Interface()
+	return result.Interface(), nil
 }
 
 func groupBy(v interface{}, key string) map[string][]interface{} {
@@ -426,6 +657,36 @@ func groupBy(v interface{}, key string) map[string][]interface{} {
 	return result
 }
 
+// groupByOrdered groups the items of a slice or array by key, keeping the
+// groups in the order their keys first appear
+func groupByOrdered(v interface{}, key string) []runtime.Group {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+
+	var groups []runtime.Group
+	index := make(map[string]int)
+	for i := 0; i < rv.Len(); i++ {
+		item := rv.Index(i).Interface()
+
+		var groupKey string
+		if val := runtime.Field(item, key); val != nil {
+			groupKey = fmt.Sprint(val)
+		}
+
+		n, ok := index[groupKey]
+		if !ok {
+			n = len(groups)
+			index[groupKey] = n
+			groups = append(groups, runtime.Group{Key: groupKey})
+		}
+		groups[n].Items = append(groups[n].Items, item)
+	}
+
+	return groups
+}
+
 func chunk(v interface{}, size int) interface{} {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Slice || size <= 0 {
@@ -451,6 +712,11 @@ func chunk(v interface{}, size int) interface{} {
 	return result
 }
 
+// paginate returns the given page of items with its page metadata
+func paginate(v interface{}, perPage, currentPage interface{}) *runtime.Paginator {
+	return runtime.NewPaginator(v, toInt(perPage), toInt(currentPage))
+}
+
 func flatten(v interface{}) []interface{} {
 	rv := reflect.ValueOf(v)
 	result := make([]interface{}, 0)
@@ -551,6 +817,127 @@ func mergeFunc(maps ...interface{}) map[string]interface{} {
 	return result
 }
 
+// Component functions
+
+// componentStackKey holds the props of the enclosing components, innermost last
+const componentStackKey = "__components"
+
+// componentData builds the data for a component: the parent data, the slots
+// and the passed props, with the props pushed onto the component data stack.
+// The stack lives in the child's copy of the data, so it is popped
+// implicitly when the parent continues rendering.
+func componentData(parent interface{}, slots map[string]interface{}, props interface{}) map[string]interface{} {
+	// Named slots are also exposed as variables, e.g. $title
+	named := make(map[string]interface{}, len(slots))
+	for name, slot := range slots {
+		if name != "default" && name != "slot" && name != "slots" {
+			named[name] = slot
+		}
+	}
+
+	data := mergeFunc(parent, named, map[string]interface{}{
+		"slot":  slots["default"],
+		"slots": slots,
+	}, props)
+
+	passed := mergeFunc(props)
+	parentStack, _ := data[componentStackKey].([]map[string]interface{})
+	stack := make([]map[string]interface{}, len(parentStack), len(parentStack)+1)
+	copy(stack, parentStack)
+	data[componentStackKey] = append(stack, passed)
+
+	return data
+}
+
+// newSlot creates a named slot with its attributes
+func newSlot(content template.HTML, attributes map[string]interface{}) runtime.Slot {
+	return runtime.NewSlot(content, attributes)
+}
+
+// slotOr returns the content of a named slot of the current component, or
+// def when the caller didn't provide the slot or left it empty
+func slotOr(data interface{}, name string, def ...interface{}) interface{} {
+	m, _ := data.(map[string]interface{})
+	slots, _ := m["slots"].(map[string]interface{})
+	switch slot := slots[name].(type) {
+	case runtime.Slot:
+		if !slot.IsEmpty() {
+			return slot.HTML()
+		}
+	case template.HTML:
+		if slot != "" {
+			return slot
+		}
+	}
+	if len(def) > 0 {
+		return def[0]
+	}
+	return ""
+}
+
+// aware resolves a prop from the props passed to the current component,
+// falling back to the nearest enclosing component and then to the default
+func aware(data interface{}, name string, def ...interface{}) interface{} {
+	if m, ok := data.(map[string]interface{}); ok {
+		stack, _ := m[componentStackKey].([]map[string]interface{})
+		for i := len(stack) - 1; i >= 0; i-- {
+			if v, ok := stack[i][name]; ok {
+				return v
+			}
+		}
+	}
+	if len(def) > 0 {
+		return def[0]
+	}
+	return nil
+}
+
+// attributesExcept returns the props passed to the current component,
+// leaving out the declared props in names
+func attributesExcept(data interface{}, names ...string) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	if m, ok := data.(map[string]interface{}); ok {
+		stack, _ := m[componentStackKey].([]map[string]interface{})
+		if len(stack) > 0 {
+			for name, value := range stack[len(stack)-1] {
+				attributes[name] = value
+			}
+		}
+	}
+	for _, name := range names {
+		delete(attributes, name)
+	}
+	return attributes
+}
+
+// Response functions
+
+// responseKey holds the *runtime.Response collecting @status and @header
+const responseKey = "__response"
+
+// setStatus records the HTTP status code declared by @status. Outside
+// RenderResponse there is no response to record into and it does nothing.
+func setStatus(data interface{}, code interface{}) string {
+	if resp := responseFrom(data); resp != nil {
+		resp.SetStatus(toInt(code))
+	}
+	return ""
+}
+
+// setHeader records an HTTP header declared by @header
+func setHeader(data interface{}, name string, value interface{}) string {
+	if resp := responseFrom(data); resp != nil {
+		resp.SetHeader(name, toString(value))
+	}
+	return ""
+}
+
+func responseFrom(data interface{}) *runtime.Response {
+	m, _ := data.(map[string]interface{})
+	resp, _ := m[responseKey].(*runtime.Response)
+	return resp
+}
+
 // Map functions
 
 func dict(pairs ...interface{}) map[string]interface{} {
@@ -561,6 +948,10 @@ func dict(pairs ...interface{}) map[string]interface{} {
 	return result
 }
 
+func listFunc(items ...interface{}) []interface{} {
+	return items
+}
+
 func setInMap(m map[string]interface{}, key string, value interface{}) map[string]interface{} {
 	if m == nil {
 		m = make(map[string]interface{})
@@ -576,32 +967,50 @@ func unsetInMap(m map[string]interface{}, key string) map[string]interface{} {
 	return m
 }
 
+// keys returns the keys of a map, sorted numerically when they are all
+// numbers and alphabetically otherwise
 func keys(m interface{}) []string {
-	rv := reflect.ValueOf(m)
-	if rv.Kind() != reflect.Map {
+	if reflect.ValueOf(m).Kind() != reflect.Map {
 		return nil
 	}
 
-	result := make([]string, 0, rv.Len())
-	for _, key := range rv.MapKeys() {
-		result = append(result, fmt.Sprint(key.Interface()))
+	pairs := runtime.Iterate(m)
+	result := make([]string, len(pairs))
+	for i, pair := range pairs {
+		result[i] = fmt.Sprint(pair.Key)
 	}
 	return result
 }
 
+// values returns the values of a map in the order of keys
 func values(m interface{}) []interface{} {
-	rv := reflect.ValueOf(m)
-	if rv.Kind() != reflect.Map {
+	if reflect.ValueOf(m).Kind() != reflect.Map {
 		return nil
 	}
 
-	result := make([]interface{}, 0, rv.Len())
-	for _, key := range rv.MapKeys() {
-		result = append(result, rv.MapIndex(key).Interface())
+	pairs := runtime.Iterate(m)
+	result := make([]interface{}, len(pairs))
+	for i, pair := range pairs {
+		result[i] = pair.Value
 	}
 	return result
 }
 
+// inArray reports whether a slice or array holds a value equal to needle,
+// comparing numbers by value
+func inArray(needle, haystack interface{}) bool {
+	rv := reflect.ValueOf(haystack)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return false
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if runtime.Equal(rv.Index(i).Interface(), needle) {
+			return true
+		}
+	}
+	return false
+}
+
 func hasKey(m interface{}, key string) bool {
 	rv := reflect.ValueOf(m)
 	if rv.Kind() != reflect.Map {
@@ -636,7 +1045,7 @@ func div(a, b interface{}) interface{} {
 	if bf == 0 {
 		return 0
 	}
-	return af / bf
+	return finite(af / bf)
 }
 
 func mod(a, b interface{}) interface{} {
@@ -655,19 +1064,59 @@ func round(n interface{}, precision ...int) float64 {
 		p = precision[0]
 	}
 	mult := math.Pow(10, float64(p))
-	return math.Round(nf*mult) / mult
+	return finite(math.Round(nf*mult) / mult)
 }
 
 func floor(n interface{}) float64 {
-	return math.Floor(toFloat64(n))
+	return finite(math.Floor(toFloat64(n)))
 }
 
 func ceil(n interface{}) float64 {
-	return math.Ceil(toFloat64(n))
+	return finite(math.Ceil(toFloat64(n)))
 }
 
 func abs(n interface{}) float64 {
-	return math.Abs(toFloat64(n))
+	return finite(math.Abs(toFloat64(n)))
+}
+
+// finite returns f, or 0 when f is NaN or infinite
+func finite(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return f
+}
+
+// parseFloat converts a number or numeric string to a float, failing the
+// render for anything else instead of silently using 0
+func parseFloat(v interface{}) (float64, error) {
+	f, ok := formatNumber(v)
+	if !ok {
+		return 0, fmt.Errorf("parseFloat: %v is not a number", v)
+	}
+	return f, nil
+}
+
+// formatNumber returns the value for the number formatting functions,
+// which render nothing for non-numeric strings, NaN and infinity
+func formatNumber(v interface{}) (float64, bool) {
+	var f float64
+	switch n := v.(type) {
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, false
+		}
+		f = parsed
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		f = toFloat64(n)
+	default:
+		return 0, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
 }
 
 func minFunc(values ...interface{}) interface{} {
@@ -697,7 +1146,10 @@ func maxFunc(values ...interface{}) interface{} {
 }
 
 func currency(n interface{}, symbol ...string) string {
-	nf := toFloat64(n)
+	nf, ok := formatNumber(n)
+	if !ok {
+		return ""
+	}
 	sym := "$"
 	if len(symbol) > 0 {
 		sym = symbol[0]
@@ -706,7 +1158,10 @@ func currency(n interface{}, symbol ...string) string {
 }
 
 func number(n interface{}, decimals ...int) string {
-	nf := toFloat64(n)
+	nf, ok := formatNumber(n)
+	if !ok {
+		return ""
+	}
 	d := 0
 	if len(decimals) > 0 {
 		d = decimals[0]
@@ -715,7 +1170,10 @@ func number(n interface{}, decimals ...int) string {
 }
 
 func percent(n interface{}, decimals ...int) string {
-	nf := toFloat64(n)
+	nf, ok := formatNumber(n)
+	if !ok {
+		return ""
+	}
 	d := 0
 	if len(decimals) > 0 {
 		d = decimals[0]
@@ -726,7 +1184,12 @@ func percent(n interface{}, decimals ...int) string {
 // Date functions
 
 func formatDate(format string, t ...interface{}) string {
-	var tm time.Time
+	return formatDateAt(time.Now(), format, t...)
+}
+
+// formatDateAt formats a date, defaulting to now
+func formatDateAt(now time.Time, format string, t ...interface{}) string {
+	tm := now
 	if len(t) > 0 {
 		switch v := t[0].(type) {
 		case time.Time:
@@ -735,11 +1198,7 @@ func formatDate(format string, t ...interface{}) string {
 			tm, _ = time.Parse(time.RFC3339, v)
 		case int64:
 			tm = time.Unix(v, 0)
-		default:
-			tm = time.Now()
 		}
-	} else {
-		tm = time.Now()
 	}
 
 	// Convert PHP date format to Go format
@@ -774,6 +1233,11 @@ func convertDateFormat(format string) string {
 }
 
 func ago(t interface{}) string {
+	return agoFrom(time.Now(), t)
+}
+
+// agoFrom describes how long before now a time was
+func agoFrom(now time.Time, t interface{}) string {
 	var tm time.Time
 	switch v := t.(type) {
 	case time.Time:
@@ -786,7 +1250,7 @@ func ago(t interface{}) string {
 		return ""
 	}
 
-	diff := time.Since(tm)
+	diff := now.Sub(tm)
 
 	switch {
 	case diff < time.Minute:
@@ -842,7 +1306,12 @@ func dateDiff(t1, t2 interface{}) time.Duration {
 }
 
 func addDate(t interface{}, years, months, days int) time.Time {
-	var tm time.Time
+	return addDateAt(time.Now(), t, years, months, days)
+}
+
+// addDateAt adds to a date, defaulting to now
+func addDateAt(now time.Time, t interface{}, years, months, days int) time.Time {
+	tm := now
 	switch v := t.(type) {
 	case time.Time:
 		tm = v
@@ -850,8 +1319,6 @@ func addDate(t interface{}, years, months, days int) time.Time {
 		tm, _ = time.Parse(time.RFC3339, v)
 	case int64:
 		tm = time.Unix(v, 0)
-	default:
-		tm = time.Now()
 	}
 	return tm.AddDate(years, months, days)
 }
@@ -861,6 +1328,11 @@ func subDate(t interface{}, years, months, days int) time.Time {
 }
 
 func timestamp(t ...interface{}) int64 {
+	return timestampAt(time.Now(), t...)
+}
+
+// timestampAt returns the Unix time of a date, defaulting to now
+func timestampAt(now time.Time, t ...interface{}) int64 {
 	if len(t) > 0 {
 		switch v := t[0].(type) {
 		case time.Time:
@@ -870,17 +1342,18 @@ func timestamp(t ...interface{}) int64 {
 			return tm.Unix()
 		}
 	}
-	return time.Now().Unix()
+	return now.Unix()
 }
 
 // Comparison functions
 
 func equal(a, b interface{}) bool {
-	return reflect.DeepEqual(a, b)
+	// Numbers compare by value so arithmetic results (float64) match int literals
+	return runtime.Equal(a, b)
 }
 
 func notEqual(a, b interface{}) bool {
-	return !reflect.DeepEqual(a, b)
+	return !equal(a, b)
 }
 
 func lessThan(a, b interface{}) bool {
@@ -901,7 +1374,7 @@ func greaterOrEqual(a, b interface{}) bool {
 
 func and(values ...interface{}) bool {
 	for _, v := range values {
-		if !toBoolValue(v) {
+		if !runtime.Truthy(v) {
 			return false
 		}
 	}
@@ -910,7 +1383,7 @@ func and(values ...interface{}) bool {
 
 func or(values ...interface{}) bool {
 	for _, v := range values {
-		if toBoolValue(v) {
+		if runtime.Truthy(v) {
 			return true
 		}
 	}
@@ -918,7 +1391,7 @@ func or(values ...interface{}) bool {
 }
 
 func not(v interface{}) bool {
-	return !toBoolValue(v)
+	return !runtime.Truthy(v)
 }
 
 // Utility functions
@@ -944,10 +1417,22 @@ func isset(v interface{}) bool {
 	return true
 }
 
+// exists reports whether a value is present. Values with an Exists method
+// are asked directly, anything else exists when it is not empty.
+func exists(v interface{}) bool {
+	if e, ok := v.(interface{ Exists() bool }); ok {
+		return e.Exists()
+	}
+	return !isEmpty(v)
+}
+
 func isEmpty(v interface{}) bool {
 	if v == nil {
 		return true
 	}
+	if e, ok := v.(interface{ IsEmpty() bool }); ok {
+		return e.IsEmpty()
+	}
 	rv := reflect.ValueOf(v)
 	switch rv.Kind() {
 	case reflect.String:
@@ -973,6 +1458,20 @@ func dump(v interface{}) string {
 	return string(b)
 }
 
+// dumpHTML renders v as indented JSON inside a <pre> block for @dump. The
+// JSON is HTML-escaped so markup in values shows as text.
+func dumpHTML(v interface{}) template.HTML {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		buf.Reset()
+		fmt.Fprintf(&buf, "%#v", v)
+	}
+	return template.HTML("<pre>" + template.HTMLEscapeString(strings.TrimSuffix(buf.String(), "\n")) + "</pre>")
+}
+
 func jsonEncode(v interface{}) template.JS {
 	b, _ := json.Marshal(v)
 	return template.JS(b)
@@ -1046,6 +1545,9 @@ func ternary(cond bool, trueVal, falseVal interface{}) interface{} {
 }
 
 func typeof(v interface{}) string {
+	if v == nil {
+		return "<nil>"
+	}
 	return reflect.TypeOf(v).String()
 }
 
@@ -1062,43 +1564,133 @@ func toString(v interface{}) string {
 }
 
 func toBool(v interface{}) bool {
-	return toBoolValue(v)
+	return runtime.Truthy(v)
 }
 
 // Validation helpers
 
-func hasError(errors interface{}, field string) bool {
-	if errors == nil {
-		return false
-	}
-	rv := reflect.ValueOf(errors)
-	if rv.Kind() == reflect.Map {
-		if val := rv.MapIndex(reflect.ValueOf(field)); val.IsValid() {
-			if arr := val.Interface(); arr != nil {
-				if slice, ok := arr.([]string); ok {
-					return len(slice) > 0
-				}
-			}
+func hasError(errors interface{}, fields ...string) bool {
+	for _, field := range fields {
+		if len(errorMessages(errors, field)) > 0 {
+			return true
 		}
 	}
 	return false
 }
 
-func getError(errors interface{}, field string) string {
-	if errors == nil {
+func getError(errors interface{}, fields ...string) string {
+	for _, field := range fields {
+		if messages := errorMessages(errors, field); len(messages) > 0 {
+			return messages[0]
+		}
+	}
+	return ""
+}
+
+func getErrors(errors interface{}, fields ...string) []string {
+	var result []string
+	for _, field := range fields {
+		result = append(result, errorMessages(errors, field)...)
+	}
+	return result
+}
+
+// old returns the old input for a field from the "old" render data, or def
+// when it is missing. Values keep their type, so multi-value inputs return
+// slices.
+func old(data interface{}, field string, def ...interface{}) interface{} {
+	m, _ := data.(map[string]interface{})
+	var value interface{}
+	switch input := m["old"].(type) {
+	case map[string]interface{}:
+		value = input[field]
+	case map[string]string:
+		if v, ok := input[field]; ok {
+			value = v
+		}
+	}
+
+	if value == nil && len(def) > 0 {
+		return def[0]
+	}
+	if value == nil {
 		return ""
 	}
+	return value
+}
+
+// extendData merges the data a child template passes with @extends into
+// the render data, so the layout can read it
+func extendData(data interface{}, values map[string]interface{}) string {
+	if m, ok := data.(map[string]interface{}); ok {
+		for k, v := range values {
+			m[k] = v
+		}
+	}
+	return ""
+}
+
+// getFlash returns the flash message for a key from a map of flash
+// messages, or ""
+func getFlash(flash interface{}, key string) string {
+	switch messages := flash.(type) {
+	case map[string]string:
+		return messages[key]
+	case map[string]interface{}:
+		if message := messages[key]; message != nil {
+			return fmt.Sprint(message)
+		}
+	}
+	return ""
+}
+
+// errorMessages returns the messages for a field from an ErrorBag or a map
+// of []string, []interface{} or string values. A nil map yields nothing.
+// A field with a * wildcard collects the messages of every matching key in
+// key order.
+func errorMessages(errors interface{}, field string) []string {
+	if errors == nil {
+		return nil
+	}
+	if bag, ok := errors.(*runtime.ErrorBag); ok {
+		return bag.Get(field)
+	}
 	rv := reflect.ValueOf(errors)
-	if rv.Kind() == reflect.Map {
-		if val := rv.MapIndex(reflect.ValueOf(field)); val.IsValid() {
-			if arr := val.Interface(); arr != nil {
-				if slice, ok := arr.([]string); ok && len(slice) > 0 {
-					return slice[0]
-				}
+	if rv.Kind() != reflect.Map || rv.IsNil() || rv.Type().Key().Kind() != reflect.String {
+		return nil
+	}
+
+	if strings.Contains(field, "*") {
+		var messages []string
+		for _, pair := range runtime.Iterate(errors) {
+			if key := fmt.Sprint(pair.Key); runtime.FieldMatches(field, key) {
+				messages = append(messages, errorMessages(errors, key)...)
 			}
 		}
+		return messages
 	}
-	return ""
+
+	val := rv.MapIndex(reflect.ValueOf(field).Convert(rv.Type().Key()))
+	if !val.IsValid() {
+		return nil
+	}
+
+	switch v := val.Interface().(type) {
+	case []string:
+		return v
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		messages := make([]string, 0, len(v))
+		for _, m := range v {
+			messages = append(messages, fmt.Sprint(m))
+		}
+		return messages
+	}
+	return nil
 }
 
 // Class/Style helpers
@@ -1128,7 +1720,7 @@ func styleArray(styles interface{}) string {
 	var result []string
 	for _, key := range rv.MapKeys() {
 		val := rv.MapIndex(key)
-		if toBoolValue(val.Interface()) {
+		if runtime.Truthy(val.Interface()) {
 			result = append(result, fmt.Sprint(key.Interface()))
 		}
 	}
@@ -1206,30 +1798,3 @@ func toInt64(v interface{}) int64 {
 		return 0
 	}
 }
-
-func toBoolValue(v interface{}) bool {
-	if v == nil {
-		return false
-	}
-	switch b := v.(type) {
-	case bool:
-		return b
-	case int, int8, int16, int32, int64:
-		return reflect.ValueOf(b).Int() != 0
-	case uint, uint8, uint16, uint32, uint64:
-		return reflect.ValueOf(b).Uint() != 0
-	case float32, float64:
-		return reflect.ValueOf(b).Float() != 0
-	case string:
-		return b != "" && b != "0" && b != "false"
-	default:
-		rv := reflect.ValueOf(v)
-		switch rv.Kind() {
-		case reflect.Slice, reflect.Array, reflect.Map:
-			return rv.Len() > 0
-		case reflect.Ptr, reflect.Interface:
-			return !rv.IsNil()
-		}
-		return true
-	}
-}
@@ -0,0 +1,297 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/codingersid/legit-template/runtime"
+)
+
+func TestFunctions_SortNumeric(t *testing.T) {
+	if got := fmt.Sprint(sortAsc([]int{2, 10, 1})); got != "[1 2 10]" {
+		t.Errorf("sortAsc: expected [1 2 10], got %s", got)
+	}
+	if got := fmt.Sprint(sortDesc([]float64{2.5, 10, 1})); got != "[10 2.5 1]" {
+		t.Errorf("sortDesc: expected [10 2.5 1], got %s", got)
+	}
+	if got := fmt.Sprint(sortAsc([]interface{}{int64(30), 4, 2.5})); got != "[2.5 4 30]" {
+		t.Errorf("sortAsc mixed: expected [2.5 4 30], got %s", got)
+	}
+	if got := fmt.Sprint(sortAsc([]string{"pear", "apple", "fig"})); got != "[apple fig pear]" {
+		t.Errorf("sortAsc strings: expected [apple fig pear], got %s", got)
+	}
+}
+
+func TestFunctions_Reverse(t *testing.T) {
+	if got := fmt.Sprint(reverse([]int{1, 2, 3})); got != "[3 2 1]" {
+		t.Errorf("expected [3 2 1], got %s", got)
+	}
+	if got := fmt.Sprint(reverse([3]string{"a", "b", "c"})); got != "[c b a]" {
+		t.Errorf("expected [c b a], got %s", got)
+	}
+}
+
+func TestFunctions_SortBy(t *testing.T) {
+	type product struct {
+		Name  string
+		Price int
+	}
+	products := []product{{"Lamp", 30}, {"Desk", 120}, {"Pen", 2}}
+
+	if got := fmt.Sprint(sortBy(products, "Price")); got != "[{Pen 2} {Lamp 30} {Desk 120}]" {
+		t.Errorf("expected ascending prices, got %s", got)
+	}
+	if got := fmt.Sprint(sortBy(products, "Name", "desc")); got != "[{Pen 2} {Lamp 30} {Desk 120}]" {
+		t.Errorf("expected descending names, got %s", got)
+	}
+
+	rows := []map[string]interface{}{{"n": 10}, {"n": 9}}
+	if got := fmt.Sprint(sortBy(rows, "n")); got != "[map[n:9] map[n:10]]" {
+		t.Errorf("expected numeric map sort, got %s", got)
+	}
+}
+
+func TestFunctions_WhereCrossTypeNumbers(t *testing.T) {
+	rows := []map[string]interface{}{{"id": int64(1)}, {"id": 2.0}, {"id": "1"}}
+	if got := fmt.Sprint(where(rows, "id", 1)); got != "[map[id:1]]" {
+		t.Errorf("expected int64 row to match int, got %s", got)
+	}
+	if got := fmt.Sprint(where(rows, "id", 2)); got != "[map[id:2]]" {
+		t.Errorf("expected float row to match int, got %s", got)
+	}
+}
+
+func TestFunctions_WhereOp(t *testing.T) {
+	type product struct {
+		Name  string
+		Price int
+		Tags  []string
+	}
+	products := []product{
+		{"Lamp", 30, []string{"home"}},
+		{"Desk", 120, []string{"home", "office"}},
+		{"Pen", 2, []string{"office"}},
+	}
+
+	names := func(v interface{}) string {
+		var result []string
+		for _, p := range v.([]product) {
+			result = append(result, p.Name)
+		}
+		return fmt.Sprint(result)
+	}
+
+	tests := []struct {
+		key, op  string
+		value    interface{}
+		expected string
+	}{
+		{"Price", ">", 25, "[Lamp Desk]"},
+		{"Price", "<=", 30.0, "[Lamp Pen]"},
+		{"Name", "in", []string{"Pen", "Desk"}, "[Desk Pen]"},
+		{"Name", "!=", "Lamp", "[Desk Pen]"},
+		{"Tags", "contains", "office", "[Desk Pen]"},
+		{"Name", "contains", "e", "[Desk Pen]"},
+	}
+
+	for _, tt := range tests {
+		result, err := whereOp(products, tt.key, tt.op, tt.value)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := names(result); got != tt.expected {
+			t.Errorf("%s %s %v: expected %s, got %s", tt.key, tt.op, tt.value, tt.expected, got)
+		}
+	}
+
+	if _, err := whereOp(products, "Price", "~", 1); err == nil {
+		t.Error("expected error for unknown operator")
+	}
+}
+
+func TestFunctions_TypeofNil(t *testing.T) {
+	if got := typeof(nil); got != "<nil>" {
+		t.Errorf("expected <nil>, got %q", got)
+	}
+	if got := typeof(1); got != "int" {
+		t.Errorf("expected int, got %q", got)
+	}
+}
+
+func TestFunctions_NumericEdgeCases(t *testing.T) {
+	if got := div(1, 0); got != 0 {
+		t.Errorf("div by zero: expected 0, got %v", got)
+	}
+	if got := round(math.Inf(1)); got != 0 {
+		t.Errorf("round(+Inf): expected 0, got %v", got)
+	}
+	if got := round(math.NaN(), 2); got != 0 {
+		t.Errorf("round(NaN): expected 0, got %v", got)
+	}
+	if got := round(2.345, 2); got != 2.35 {
+		t.Errorf("round(2.345, 2): expected 2.35, got %v", got)
+	}
+
+	tests := []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{"number abc", number("abc"), ""},
+		{"number string", number(" 1234.5 ", 1), "1234.5"},
+		{"number NaN", number(math.NaN()), ""},
+		{"currency Inf", currency(math.Inf(-1)), ""},
+		{"percent", percent(0.256, 1), "25.6%"},
+		{"percent nil", percent(nil), ""},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, tt.got)
+		}
+	}
+
+	if _, err := parseFloat("abc"); err == nil {
+		t.Error("expected parseFloat error for non-numeric string")
+	}
+	if f, err := parseFloat("2.5"); err != nil || f != 2.5 {
+		t.Errorf("expected 2.5, got %v (%v)", f, err)
+	}
+}
+
+func TestFunctions_FirstLastStringsAndMaps(t *testing.T) {
+	if got := first("héllo"); got != "h" {
+		t.Errorf("first string: expected h, got %v", got)
+	}
+	if got := last("café"); got != "é" {
+		t.Errorf("last string: expected é, got %v", got)
+	}
+	if first("") != nil || last("") != nil {
+		t.Error("expected nil for an empty string")
+	}
+
+	m := map[string]int{"b": 2, "c": 3, "a": 1}
+	if got := first(m); got != 1 {
+		t.Errorf("first map: expected 1, got %v", got)
+	}
+	if got := last(m); got != 3 {
+		t.Errorf("last map: expected 3, got %v", got)
+	}
+	if first(map[string]int{}) != nil {
+		t.Error("expected nil for an empty map")
+	}
+}
+
+func TestFunctions_KeysValuesSorted(t *testing.T) {
+	byName := map[string]int{"cherry": 3, "apple": 1, "banana": 2, "date": 4}
+	if got := fmt.Sprint(keys(byName)); got != "[apple banana cherry date]" {
+		t.Errorf("keys: unexpected order %s", got)
+	}
+	if got := fmt.Sprint(values(byName)); got != "[1 2 3 4]" {
+		t.Errorf("values: unexpected order %s", got)
+	}
+
+	byID := map[int]string{10: "ten", 2: "two", 33: "thirty-three", 1: "one"}
+	if got := fmt.Sprint(keys(byID)); got != "[1 2 10 33]" {
+		t.Errorf("keys: expected numeric order, got %s", got)
+	}
+	if got := fmt.Sprint(values(byID)); got != "[one two ten thirty-three]" {
+		t.Errorf("values: expected numeric order, got %s", got)
+	}
+
+	mixed := map[interface{}]int{"b": 1, 10: 2, "a": 3}
+	if got := fmt.Sprint(keys(mixed)); got != "[10 a b]" {
+		t.Errorf("keys: expected string order for mixed keys, got %s", got)
+	}
+}
+
+func TestFunctions_GroupByOrdered(t *testing.T) {
+	items := []interface{}{
+		map[string]interface{}{"name": "Go", "cat": "lang"},
+		map[string]interface{}{"name": "Vim", "cat": "editor"},
+		map[string]interface{}{"name": "Rust", "cat": "lang"},
+		map[string]interface{}{"name": "Zig", "cat": "lang"},
+		map[string]interface{}{"name": "Make"},
+		map[string]interface{}{"name": "Emacs", "cat": "editor"},
+	}
+
+	groups := groupByOrdered(items, "cat")
+	var got []string
+	for _, g := range groups {
+		got = append(got, fmt.Sprintf("%s:%d", g.Key, len(g.Items)))
+	}
+	if s := strings.Join(got, " "); s != "lang:3 editor:2 :1" {
+		t.Errorf("unexpected groups %s", s)
+	}
+	if name := runtime.Field(groups[0].Items[1], "name"); name != "Rust" {
+		t.Errorf("expected items in input order, got %v", name)
+	}
+	if groupByOrdered("x", "cat") != nil {
+		t.Error("expected nil for a non-slice")
+	}
+}
+
+func TestFunctions_Join(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{[]string{"a", "b"}, "a, b"},
+		{[]int{1, 2, 3}, "1, 2, 3"},
+		{[]interface{}{"x", 2, 1.5, true}, "x, 2, 1.5, true"},
+		{[2]int{4, 5}, "4, 5"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := join(tt.input, ", "); got != tt.expected {
+			t.Errorf("join(%v): expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFunctions_Split(t *testing.T) {
+	funcs := DefaultFunctions()
+	splitN := funcs["splitN"].(func(string, string, int) []string)
+	if got := splitN("a,b,c", ",", 2); len(got) != 2 || got[0] != "a" || got[1] != "b,c" {
+		t.Errorf("splitN: expected [a b,c], got %q", got)
+	}
+
+	words := funcs["words"].(func(string) []string)
+	if got := fmt.Sprint(words("  one two\tthree\n")); got != "[one two three]" {
+		t.Errorf("words: expected [one two three], got %s", got)
+	}
+
+	if got := fmt.Sprintf("%q", splitLines("a\r\nb\n\nc\n")); got != `["a" "b" "" "c"]` {
+		t.Errorf("splitLines: unexpected %s", got)
+	}
+	if got := splitLines(""); len(got) != 0 {
+		t.Errorf("splitLines: expected no lines, got %q", got)
+	}
+}
+
+func TestFunctions_PadAndMask(t *testing.T) {
+	tests := []struct {
+		got      string
+		expected string
+	}{
+		{padLeft("7", 3, "0"), "007"},
+		{padLeft("héllo", 7), "  héllo"},
+		{padRight("日本", 4, "・"), "日本・・"},
+		{padRight("long", 2), "long"},
+		{center("ab", 7, "-"), "--ab---"},
+		{center("é", 3), " é "},
+		{mask("4111111111111234", 0, -4), "************1234"},
+		{mask("4111111111111234", -4, 4, "#"), "411111111111####"},
+		{mask("ünïcödé", 1, 3), "ü***ödé"},
+		{mask("abc", 5, 2), "abc"},
+		{mask("abc", 1, 10, "xy"), "axx"},
+	}
+
+	for i, tt := range tests {
+		if tt.got != tt.expected {
+			t.Errorf("case %d: expected %q, got %q", i, tt.expected, tt.got)
+		}
+	}
+}
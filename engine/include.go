@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+)
+
+// includeRe matches the templates a compiled template includes with
+// @include and its variants or renders as components
+var includeRe = regexp.MustCompile(`\{\{ template "([^"]+)"`)
+
+// optionalIncludeRe matches the templates included with @includeIf
+var optionalIncludeRe = regexp.MustCompile(`\{\{ if templateExists "([^"]+)" \}\}`)
+
+// associatePartials compiles the templates that compiled includes and
+// parses them into the template set of tmpl, so {{ template }} calls can
+// execute them. Included templates are associated recursively; seen holds
+// the names already in the set and sources receives their source maps. A missing template is an error unless it
+// is only included with @includeIf, in which case it renders nothing.
+func (e *Engine) associatePartials(tmpl *template.Template, compiled string, seen map[string]bool, deps map[string]Dependency, sources sourceMaps) error {
+	optional := make(map[string]bool)
+	for _, m := range optionalIncludeRe.FindAllStringSubmatch(compiled, -1) {
+		optional[m[1]] = true
+	}
+
+	for _, m := range includeRe.FindAllStringSubmatch(compiled, -1) {
+		name := m[1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if optional[name] && !e.Exists(name) {
+			if path, err := e.resolvePath(name); err == nil && deps != nil {
+				deps[path] = Dependency{}
+			}
+			if _, err := tmpl.New(name).Parse(""); err != nil {
+				return err
+			}
+			continue
+		}
+
+		path, err := e.resolvePath(name)
+		if err != nil {
+			return err
+		}
+		partial, err := e.compileSource(name, path, deps)
+		if err != nil {
+			return err
+		}
+		if _, err := parseCompiled(tmpl.New(name), partial, sources); err != nil {
+			return fmt.Errorf("failed to parse compiled template %s: %w", name, err)
+		}
+		if err := e.associatePartials(tmpl, partial, seen, deps, sources); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// partialSuffix marks the template cache entries of RenderPartial
+const partialSuffix = "#partial"
+
+// RenderPartial renders a template without the layout it extends, for
+// example to return a fragment to an AJAX request. The @extends directive
+// is ignored and the template's @section blocks render where they are
+// defined, along with any content outside them.
+func (e *Engine) RenderPartial(w io.Writer, name string, data interface{}) (err error) {
+	defer recoverPanic(name, &err)
+
+	name = normalizeName(name)
+	filePath, err := e.resolvePath(name)
+	if err != nil {
+		return err
+	}
+	cached, err := e.cachedTemplate(name+partialSuffix, filePath, e.compilePartialFile)
+	if err != nil {
+		return err
+	}
+
+	return e.execute(w, cached, data, nil)
+}
+
+// compilePartialFile compiles a template file for RenderPartial, keeping
+// its sections in place instead of merging them into its layout
+func (e *Engine) compilePartialFile(key, filePath string) (*CachedTemplate, error) {
+	name := strings.TrimSuffix(key, partialSuffix)
+
+	content, err := e.readFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template %s: %w", name, err)
+	}
+	deps := make(map[string]Dependency)
+	if err := e.recordDependency(deps, filePath, content); err != nil {
+		return nil, err
+	}
+
+	compiled, _, err := e.compileWith(name, string(content), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile template %s: %w", name, err)
+	}
+
+	sources := make(sourceMaps)
+	tmpl, err := parseCompiled(e.newTemplate(key), compiled, sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse compiled template %s: %w", name, err)
+	}
+	if err := e.associatePartials(tmpl, compiled, map[string]bool{key: true}, deps, sources); err != nil {
+		return nil, err
+	}
+
+	return newCachedTemplate(tmpl, filePath, deps, sources), nil
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEngine_RenderPartial(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"layouts/app.legit":    "<html><title>@yield('title')</title>@yield('content')@stack('scripts')</html>",
+		"partials/badge.legit": "<b>{{ $label }}</b>",
+		"pages/profile.legit": "@extends('layouts.app')\n" +
+			"@section('title', 'Profile')\n" +
+			"@section('content')<h1>{{ $name }}</h1>@include('partials.badge', ['label' => 'pro'])@parent@endsection\n" +
+			"@push('scripts')<script></script>@endpush",
+	})
+	data := map[string]interface{}{"name": "Ada"}
+
+	var buf strings.Builder
+	if err := e.RenderPartial(&buf, "pages.profile", data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "\n\n<h1>Ada</h1><b>pro</b>\n"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	// The full render still uses the layout
+	full, err := e.RenderString("pages.profile", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(full, "<html><title>Profile</title><h1>Ada</h1>") {
+		t.Errorf("unexpected full render: %q", full)
+	}
+}
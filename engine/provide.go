@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Provide exposes a Go object to every template under name so templates
+// can call its methods, e.g. {{ $cart->total() }} for cart.Total(). Unlike
+// Share, the object must have exported methods; passing a value without
+// any is an error, as templates could not call anything on it. Call data
+// with the same key wins, as it does for shared data.
+func (e *Engine) Provide(name string, service interface{}) error {
+	if name == "" {
+		return fmt.Errorf("provide: empty service name")
+	}
+	if service == nil {
+		return fmt.Errorf("provide: service %q is nil", name)
+	}
+	if t := reflect.TypeOf(service); t.NumMethod() == 0 {
+		return fmt.Errorf("provide: service %q (%s) has no exported methods", name, t)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.services[name] = service
+	return nil
+}
+
+// providedServices returns a copy of the services registered with Provide
+func (e *Engine) providedServices() map[string]interface{} {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	services := make(map[string]interface{}, len(e.services))
+	for name, service := range e.services {
+		services[name] = service
+	}
+	return services
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+type testCart struct {
+	prices []float64
+}
+
+func (c *testCart) Total() float64 {
+	total := 0.0
+	for _, p := range c.prices {
+		total += p
+	}
+	return total
+}
+
+func (c *testCart) ItemCount() int {
+	return len(c.prices)
+}
+
+func (c *testCart) Has(n int) bool {
+	return len(c.prices) >= n
+}
+
+func TestEngine_Provide(t *testing.T) {
+	e := New(t.TempDir())
+	if err := e.Provide("cart", &testCart{prices: []float64{2.5, 4}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out, err := e.RenderTemplate("{{ $cart->itemCount() }} items, {{ $cart->total() }} total @if($cart->has(2))ok@endif", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "2 items, 6.5 total ok" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	clone := e.Clone()
+	if err := clone.Provide("cart", &testCart{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out, _ := e.RenderTemplate("{{ $cart->itemCount() }}", nil); out != "2" {
+		t.Errorf("expected the clone's service not to affect the original, got %q", out)
+	}
+}
+
+func TestEngine_ProvideInvalid(t *testing.T) {
+	e := New(t.TempDir())
+	for name, service := range map[string]interface{}{
+		"nil":    nil,
+		"plain":  map[string]int{"a": 1},
+		"struct": testCart{},
+	} {
+		if err := e.Provide(name, service); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: expected error naming the service, got %v", name, err)
+		}
+	}
+}
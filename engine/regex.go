@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// regexCacheSize is the number of compiled patterns kept by the regex
+// functions
+const regexCacheSize = 128
+
+// regexCache is a least recently used cache of compiled patterns, shared by
+// all engines
+var regexCache = newPatternCache(regexCacheSize)
+
+// patternCache keeps up to size compiled patterns, evicting the least
+// recently used
+type patternCache struct {
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	mu      sync.Mutex
+}
+
+// patternEntry is a compiled pattern in a patternCache
+type patternEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newPatternCache(size int) *patternCache {
+	return &patternCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// compile returns the compiled pattern, compiling and caching it on first
+// use. Invalid patterns are not cached.
+func (c *patternCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*patternEntry).re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.entries[pattern] = c.order.PushFront(&patternEntry{pattern: pattern, re: re})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*patternEntry).pattern)
+	}
+	return re, nil
+}
+
+// replaceRegex replaces the matches of pattern in s with repl, which may
+// refer to capture groups as $1 or ${name}. An invalid pattern fails the
+// render.
+func replaceRegex(s, pattern, repl string) (string, error) {
+	re, err := regexCache.compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// match reports whether s contains a match of pattern. An invalid pattern
+// fails the render.
+func match(s, pattern string) (bool, error) {
+	re, err := regexCache.compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+// matchAll returns every match of pattern in s. When the pattern has a
+// capture group, the first group of each match is returned instead. An
+// invalid pattern fails the render.
+func matchAll(s, pattern string) ([]string, error) {
+	re, err := regexCache.compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := re.FindAllStringSubmatch(s, -1)
+	result := make([]string, len(matches))
+	for i, m := range matches {
+		if len(m) > 1 {
+			result[i] = m[1]
+		} else {
+			result[i] = m[0]
+		}
+	}
+	return result, nil
+}
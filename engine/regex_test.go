@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRegex_Functions(t *testing.T) {
+	if got, err := replaceRegex("2024-01-31", `(\d+)-(\d+)-(\d+)`, "$3/$2/$1"); err != nil || got != "31/01/2024" {
+		t.Errorf("replaceRegex: expected 31/01/2024, got %q (%v)", got, err)
+	}
+
+	if ok, err := match("order #42", `#\d+`); err != nil || !ok {
+		t.Errorf("match: expected a match (%v)", err)
+	}
+	if ok, _ := match("order", `#\d+`); ok {
+		t.Error("match: expected no match")
+	}
+
+	got, err := matchAll("#go and #templates", `#(\w+)`)
+	if err != nil || fmt.Sprint(got) != "[go templates]" {
+		t.Errorf("matchAll with group: expected [go templates], got %v (%v)", got, err)
+	}
+	got, _ = matchAll("a1 b22 c", `\d+`)
+	if fmt.Sprint(got) != "[1 22]" {
+		t.Errorf("matchAll: expected [1 22], got %v", got)
+	}
+
+	if _, err := match("x", `(`); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestRegex_CacheEvicts(t *testing.T) {
+	cache := newPatternCache(2)
+	a, _ := cache.compile("a")
+	cache.compile("b")
+	cache.compile("a")
+	cache.compile("c")
+
+	if _, ok := cache.entries["b"]; ok {
+		t.Error("expected least recently used pattern to be evicted")
+	}
+	if again, _ := cache.compile("a"); again != a {
+		t.Error("expected cached pattern to be reused")
+	}
+}
+
+func TestEngine_RegexInvalidPattern(t *testing.T) {
+	e := New(t.TempDir())
+	if _, err := e.RenderTemplate(`{{ replaceRegex($s, '[', '') }}`, map[string]interface{}{"s": "x"}); err == nil {
+		t.Error("expected render error for invalid pattern")
+	}
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+)
+
+// RenderScope renders templates with request-local data layered
+// between the engine's shared data and the data passed to each call.
+// A scope is cheap to create and should not outlive the request it serves.
+type RenderScope struct {
+	engine *Engine
+	data   map[string]interface{}
+}
+
+// WithRequestData returns a render scope carrying request-local data
+// (current user, CSRF token, ...) that never leaks into other renders
+func (e *Engine) WithRequestData(data map[string]interface{}) *RenderScope {
+	local := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		local[k] = v
+	}
+	return &RenderScope{
+		engine: e,
+		data:   local,
+	}
+}
+
+// Share adds request-local data to the scope
+func (s *RenderScope) Share(key string, value interface{}) *RenderScope {
+	s.data[key] = value
+	return s
+}
+
+// Data returns a copy of the request-local data
+func (s *RenderScope) Data() map[string]interface{} {
+	result := make(map[string]interface{}, len(s.data))
+	for k, v := range s.data {
+		result[k] = v
+	}
+	return result
+}
+
+// Render renders a template to the given writer
+func (s *RenderScope) Render(w io.Writer, name string, data interface{}) error {
+	return s.engine.render(w, name, data, s.data)
+}
+
+// RenderString renders a template and returns the result as a string
+func (s *RenderScope) RenderString(name string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	err := s.Render(&buf, name, data)
+	return buf.String(), err
+}
+
+// RenderTemplate renders a template string directly (not from file)
+func (s *RenderScope) RenderTemplate(templateStr string, data interface{}) (string, error) {
+	return s.engine.renderTemplate(templateStr, data, s.data)
+}
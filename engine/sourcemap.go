@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"errors"
+	"html/template"
+	"regexp"
+	"strconv"
+
+	"github.com/codingersid/legit-template/compiler"
+)
+
+// execErrorRe matches the position prefix of a Go template execution error
+var execErrorRe = regexp.MustCompile(`template: (.+?):(\d+):(\d+): executing "[^"]*" at <(.*?)>: `)
+
+// sourceMaps holds the source maps of the templates in one template set
+// by template name. It is built while the set is compiled and not changed
+// afterwards, so it is dropped along with the cached set.
+type sourceMaps map[string]*compiler.SourceMap
+
+// lookup returns the source map of a template in the set, or nil
+func (s sourceMaps) lookup(name string) *compiler.SourceMap {
+	return s[name]
+}
+
+// parseCompiled removes the source marks from compiled template source,
+// parses it into tmpl and adds the source map to sources for translating
+// errors
+func parseCompiled(tmpl *template.Template, compiled string, sources sourceMaps) (*template.Template, error) {
+	compiled, sourceMap := compiler.StripMarks(compiled)
+	sources[tmpl.Name()] = sourceMap
+	return tmpl.Parse(compiled)
+}
+
+// sourceError translates a Go template execution error into an EngineError
+// at the .legit line and column of the node that failed. When slots or
+// nested templates wrap the error, the innermost position is used. Errors
+// without a known position are returned unchanged.
+func sourceError(err error, lookup func(name string) *compiler.SourceMap) error {
+	msg := err.Error()
+	matches := execErrorRe.FindAllStringSubmatchIndex(msg, -1)
+	if len(matches) == 0 {
+		return err
+	}
+	m := matches[len(matches)-1]
+
+	line, _ := strconv.Atoi(msg[m[4]:m[5]])
+	column, _ := strconv.Atoi(msg[m[6]:m[7]])
+	source, pos, ok := lookup(msg[m[2]:m[3]]).Lookup(line, column)
+	if !ok {
+		return err
+	}
+
+	// A panicking directive handler is reported like a panic while
+	// rendering, without the compiled action that called it
+	var p *handlerPanic
+	if errors.As(err, &p) {
+		return &EngineError{Message: p.Error(), Template: source, Err: err}
+	}
+
+	return &EngineError{
+		Message:  msg[m[1]:],
+		Template: source,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Near:     msg[m[8]:m[9]],
+		Err:      err,
+	}
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// Markers delimiting @spaceless blocks in rendered output
+const (
+	spacelessStart = "<!--legit:spaceless-->"
+	spacelessEnd   = "<!--legit:endspaceless-->"
+)
+
+var (
+	betweenTagsRe = regexp.MustCompile(`>\s+<`)
+	preBlockRe    = regexp.MustCompile(`(?is)<pre\b.*?</pre>`)
+)
+
+// spacelessMarker emits the start or end marker of a @spaceless block
+func spacelessMarker(which string) template.HTML {
+	if which == "end" {
+		return template.HTML(spacelessEnd)
+	}
+	return template.HTML(spacelessStart)
+}
+
+// processSpaceless removes whitespace between tags inside @spaceless blocks
+// and strips the block markers. Nested blocks are processed innermost first.
+func processSpaceless(out string) string {
+	if !strings.Contains(out, spacelessStart) {
+		return out
+	}
+
+	for {
+		start := strings.LastIndex(out, spacelessStart)
+		if start == -1 {
+			break
+		}
+		contentStart := start + len(spacelessStart)
+
+		end := strings.Index(out[contentStart:], spacelessEnd)
+		if end == -1 {
+			// Unterminated block - drop the marker and keep content as-is
+			out = out[:start] + out[contentStart:]
+			continue
+		}
+		end += contentStart
+
+		out = out[:start] + collapseBetweenTags(out[contentStart:end]) + out[end+len(spacelessEnd):]
+	}
+
+	return strings.ReplaceAll(out, spacelessEnd, "")
+}
+
+// collapseBetweenTags removes whitespace between HTML tags,
+// leaving text whitespace and <pre> contents untouched
+func collapseBetweenTags(s string) string {
+	pres := preBlockRe.FindAllStringIndex(s, -1)
+
+	var result strings.Builder
+	last := 0
+
+	for _, m := range betweenTagsRe.FindAllStringIndex(s, -1) {
+		inPre := false
+		for _, r := range pres {
+			if m[0] > r[0] && m[1] < r[1] {
+				inPre = true
+				break
+			}
+		}
+		if inPre {
+			continue
+		}
+		result.WriteString(s[last:m[0]])
+		result.WriteString("><")
+		last = m[1]
+	}
+	result.WriteString(s[last:])
+
+	return result.String()
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// stacksKey holds the content pushed to each stack during a render
+const stacksKey = "__stacks"
+
+// stackRe matches the placeholders @stack leaves in rendered output: a
+// plain placeholder, or one with default content up to the end marker
+var stackRe = regexp.MustCompile(`(?s)<!--legit:stack:(.*?)-->|<!--legit:stackdefault:(.*?)-->(.*?)<!--legit:endstack-->`)
+
+// stackMarker emits the placeholder for a stack, followed by its default
+// content if any. Stacks are filled in after the render, so content pushed
+// further down the page (e.g. by a child template's sections) still
+// appears at the @stack location.
+func stackMarker(name string, def ...template.HTML) template.HTML {
+	if len(def) == 0 {
+		return template.HTML("<!--legit:stack:" + name + "-->")
+	}
+	return template.HTML("<!--legit:stackdefault:" + name + "-->" + string(def[0]) + "<!--legit:endstack-->")
+}
+
+// pushStack appends content to a stack. A stack renders all prepended
+// content, most recent first, followed by all pushed content in the order
+// it was pushed.
+func pushStack(data interface{}, name string, content template.HTML) string {
+	if stacks := stacksFrom(data); stacks != nil {
+		stacks[name] = append(stacks[name], string(content))
+	}
+	return ""
+}
+
+// prependStack adds content to the front of a stack
+func prependStack(data interface{}, name string, content template.HTML) string {
+	if stacks := stacksFrom(data); stacks != nil {
+		stacks[name] = append([]string{string(content)}, stacks[name]...)
+	}
+	return ""
+}
+
+// stacksFrom returns the stacks of the render data, or nil
+func stacksFrom(data interface{}) map[string][]string {
+	m, _ := data.(map[string]interface{})
+	stacks, _ := m[stacksKey].(map[string][]string)
+	return stacks
+}
+
+// processStacks replaces the stack placeholders in rendered output with
+// the content pushed to each stack, or the default content of stacks
+// nothing was pushed to
+func processStacks(out string, data interface{}) string {
+	if !strings.Contains(out, "<!--legit:stack") {
+		return out
+	}
+
+	stacks := stacksFrom(data)
+	return stackRe.ReplaceAllStringFunc(out, func(marker string) string {
+		m := stackRe.FindStringSubmatch(marker)
+		if strings.HasPrefix(marker, "<!--legit:stack:") {
+			return strings.Join(stacks[m[1]], "")
+		}
+		if pushed := stacks[m[2]]; len(pushed) > 0 {
+			return strings.Join(pushed, "")
+		}
+		return m[3]
+	})
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RenderToFile renders a template and writes it to outPath, creating its
+// parent directories. Nothing is written on error.
+func (e *Engine) RenderToFile(outPath, name string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := e.Render(&buf, name, data); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, buf.Bytes(), 0644)
+}
+
+// RenderAllToDir renders every template accepted by filter (all when filter
+// is nil) to outDir, mirroring the views directory: "blog.post" is written
+// to blog/post.html and "admin::users.index" to admin/users/index.html.
+// Every template receives the same data.
+func (e *Engine) RenderAllToDir(outDir string, data interface{}, filter func(name string) bool) error {
+	return e.walkTemplates(func(name string) error {
+		if filter != nil && !filter(name) {
+			return nil
+		}
+		return e.RenderToFile(filepath.Join(outDir, staticPath(name)), name, data)
+	})
+}
+
+// staticPath returns the relative output path of a template name
+func staticPath(name string) string {
+	namespace, name := splitNamespace(name)
+	parts := strings.Split(name, ".")
+	if namespace != "" {
+		parts = append([]string{namespace}, parts...)
+	}
+	return filepath.Join(parts...) + ".html"
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEngine_RenderToFile(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"page.legit": "<h1>{{ $title }}</h1>",
+	})
+	out := filepath.Join(t.TempDir(), "site", "nested", "index.html")
+
+	if err := e.RenderToFile(out, "page", map[string]interface{}{"title": "Home"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if string(content) != "<h1>Home</h1>" {
+		t.Errorf("unexpected content: %q", content)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.html")
+	if err := e.RenderToFile(missing, "missing", nil); err == nil {
+		t.Error("expected error for missing template")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("expected no file to be written on error")
+	}
+}
+
+func TestEngine_RenderAllToDir(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"index.legit":           "home {{ $site }}",
+		"blog/post.legit":       "post {{ $site }}",
+		"partials/footer.legit": "footer",
+	})
+	out := t.TempDir()
+
+	err := e.RenderAllToDir(out, map[string]interface{}{"site": "legit"}, func(name string) bool {
+		return !strings.HasPrefix(name, "partials.")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for path, expected := range map[string]string{
+		"index.html":     "home legit",
+		"blog/post.html": "post legit",
+	} {
+		content, err := os.ReadFile(filepath.Join(out, path))
+		if err != nil || string(content) != expected {
+			t.Errorf("%s: expected %q, got %q (%v)", path, expected, content, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "partials")); !os.IsNotExist(err) {
+		t.Error("expected filtered templates to be skipped")
+	}
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/codingersid/legit-template/compiler"
+	"github.com/codingersid/legit-template/lexer"
+	"github.com/codingersid/legit-template/parser"
+)
+
+// Validate checks that a template compiles, without rendering or caching
+// it. The template is compiled with the layouts it extends and the
+// partials it includes, and the result is parsed as a Go template. Errors
+// are returned as an EngineError naming the template at fault and, for
+// syntax errors, the line and column.
+func (e *Engine) Validate(name string) error {
+	name = normalizeName(name)
+
+	path, err := e.resolvePath(name)
+	if err != nil {
+		return &EngineError{Message: err.Error(), Template: name, Err: err}
+	}
+	if _, err := e.compileFile(name, path); err != nil {
+		var srcErr *templateSourceError
+		if errors.As(err, &srcErr) {
+			return positionedError(srcErr.name, srcErr.source, srcErr.err)
+		}
+		return &EngineError{Message: err.Error(), Template: name, Err: err}
+	}
+	return nil
+}
+
+// ValidateAll validates every template in the views directories and
+// returns the errors by template name. Templates that compile are left
+// out, so an empty map means every template is valid. An error walking
+// the views directories is returned under the empty name.
+func (e *Engine) ValidateAll() map[string]error {
+	failed := make(map[string]error)
+	if err := e.walkTemplates(func(name string) error {
+		if err := e.Validate(name); err != nil {
+			failed[name] = err
+		}
+		return nil
+	}); err != nil {
+		failed[""] = err
+	}
+	return failed
+}
+
+// templateSourceError is a lexer, parser or compiler error in the named template,
+// kept with the template source so it can be positioned
+type templateSourceError struct {
+	name   string
+	source string
+	err    error
+}
+
+func (s *templateSourceError) Error() string {
+	return s.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (s *templateSourceError) Unwrap() error {
+	return s.err
+}
+
+// positionedError converts a lexer, parser or compiler error into an
+// EngineError at its position in the template source
+func positionedError(name, source string, err error) error {
+	var (
+		message string
+		pos     lexer.Position
+	)
+
+	var lexErr *lexer.LexerError
+	var parseErr *parser.ParserError
+	var compileErr *compiler.CompilerError
+	switch {
+	case errors.As(err, &lexErr):
+		message, pos = lexErr.Message, lexErr.Position
+	case errors.As(err, &parseErr):
+		message, pos = parseErr.Message, parseErr.Position
+	case errors.As(err, &compileErr):
+		message, pos = compileErr.Message, compileErr.Position
+	default:
+		return &EngineError{Message: err.Error(), Template: name, Err: err}
+	}
+
+	var near string
+	if lines := strings.Split(source, "\n"); pos.Line > 0 && pos.Line <= len(lines) {
+		near = strings.TrimSpace(lines[pos.Line-1])
+	}
+
+	return &EngineError{
+		Message:  message,
+		Template: name,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Near:     near,
+		Err:      err,
+	}
+}
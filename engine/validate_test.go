@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEngine_Validate(t *testing.T) {
+	e := newTestEngine(t, map[string]string{
+		"layouts/app.legit":    "<body>@yield('content')</body>",
+		"layouts/broken.legit": "<body>\n@foreach($items as $item)\n@yield('content')</body>",
+		"home.legit":           "@extends('layouts.app')@section('content'){{ $user->name }}@endsection",
+		"bad.legit":            "<ul>\n  @if($a)\n    <li>{{ $a }}</li>\n</ul>",
+		"child.legit":          "@extends('layouts.broken')@section('content')x@endsection",
+	})
+
+	if err := e.Validate("home"); err != nil {
+		t.Errorf("expected a valid template, got %v", err)
+	}
+	if stats := e.CacheStats(); stats.Size != 0 {
+		t.Errorf("expected Validate not to cache templates, got %d", stats.Size)
+	}
+
+	var engineErr *EngineError
+	err := e.Validate("bad")
+	if !errors.As(err, &engineErr) || engineErr.Template != "bad" || engineErr.Line != 2 || engineErr.Column != 3 || engineErr.Near != "@if($a)" {
+		t.Errorf("expected a positioned error for the unclosed @if, got %#v", err)
+	}
+
+	err = e.Validate("child")
+	if !errors.As(err, &engineErr) || engineErr.Template != "layouts.broken" || engineErr.Line != 2 {
+		t.Errorf("expected the error to name the broken layout, got %#v", err)
+	}
+
+	failed := e.ValidateAll()
+	if len(failed) != 3 || failed["bad"] == nil || failed["child"] == nil || failed["layouts.broken"] == nil {
+		t.Errorf("unexpected failures %v", failed)
+	}
+}
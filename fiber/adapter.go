@@ -4,12 +4,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/codingersid/legit-template/engine"
+	"github.com/codingersid/legit-template/runtime"
 )
 
 // Engine wraps the legit-view engine for Fiber compatibility
@@ -17,11 +17,27 @@ type Engine struct {
 	*engine.Engine
 	directory  string
 	extension  string
-	layout     string
+	layouts    []string
 	reload     bool
 	debug      bool
 	mutex      sync.RWMutex
 	layoutFunc func() string
+	locals     map[string]string
+}
+
+// Locals is the part of *fiber.Ctx that RenderWithContext reads
+// request-scoped values from
+type Locals interface {
+	Locals(key interface{}, value ...interface{}) interface{}
+}
+
+// DefaultLocals maps the data keys used by @csrf, @error, @old and @flash
+// to the Fiber locals RenderWithContext reads them from
+var DefaultLocals = map[string]string{
+	"csrf_token": "csrf",
+	"errors":     "errors",
+	"old":        "old",
+	"flash":      "flash",
 }
 
 // New creates a new Fiber-compatible template engine
@@ -44,6 +60,7 @@ func New(directory string, extension ...string) *Engine {
 		extension: ext,
 		reload:    false,
 		debug:     false,
+		locals:    copyLocals(DefaultLocals),
 	}
 }
 
@@ -52,11 +69,13 @@ func NewFiber(directory string, extension ...string) *Engine {
 	return New(directory, extension...)
 }
 
-// Layout sets the default layout template
-func (e *Engine) Layout(layout string) *Engine {
+// Layout sets the default layout template. Further layouts nest it:
+// Layout("layouts/admin", "layouts/base") renders each view into
+// layouts/admin and the result into layouts/base.
+func (e *Engine) Layout(layout string, outer ...string) *Engine {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
-	e.layout = layout
+	e.layouts = layoutChain(append([]string{layout}, outer...))
 	return e
 }
 
@@ -79,6 +98,25 @@ func (e *Engine) Reload(reload bool) *Engine {
 	return e
 }
 
+// BindWith sets the Fiber locals RenderWithContext copies into the data,
+// mapping each data key to the local it is read from, e.g.
+// {"csrf_token": "csrf"}. It replaces DefaultLocals.
+func (e *Engine) BindWith(locals map[string]string) *Engine {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.locals = copyLocals(locals)
+	return e
+}
+
+// copyLocals copies a locals mapping
+func copyLocals(locals map[string]string) map[string]string {
+	c := make(map[string]string, len(locals))
+	for key, local := range locals {
+		c[key] = local
+	}
+	return c
+}
+
 // Debug enables debug mode with error details
 func (e *Engine) Debug(debug bool) *Engine {
 	e.mutex.Lock()
@@ -89,41 +127,34 @@ func (e *Engine) Debug(debug bool) *Engine {
 
 // Load pre-compiles all templates
 // This implements the fiber.Views interface
+// Templates are compiled without rendering them, so templates that need
+// data, partials and layouts load too. A template that fails to compile
+// does not fail the load; its error is reported when it is rendered, and
+// logged in debug mode. Templates are named with dots like
+// Engine.Templates; Render accepts both "partials/header" and
+// "partials.header".
 func (e *Engine) Load() error {
 	if e.reload {
 		return nil // Don't pre-load in reload mode
 	}
 
-	return filepath.Walk(e.directory, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		if !strings.HasSuffix(path, e.extension) {
-			return nil
-		}
-
-		// Get template name from path
-		name := strings.TrimPrefix(path, e.directory+string(filepath.Separator))
-		name = strings.TrimSuffix(name, e.extension)
-		name = strings.ReplaceAll(name, string(filepath.Separator), "/")
+	templates, err := e.Engine.Templates()
+	if err != nil {
+		return err
+	}
 
-		// Compile template by rendering with nil data
-		// This validates the template and caches it
-		_, err = e.Engine.RenderString(name, nil)
-		if err != nil && e.debug {
+	for _, name := range templates {
+		if err := e.Engine.Compile(name); err != nil && e.debug {
 			fmt.Printf("Warning: failed to pre-compile template %s: %v\n", name, err)
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
 // Render renders a template with the given data
 // This implements the fiber.Views interface
+// Several layouts nest, innermost first: the view is rendered into
+// layouts[0], which is rendered into layouts[1] and so on.
 func (e *Engine) Render(w io.Writer, name string, data interface{}, layouts ...string) error {
 	// Clear cache in reload mode
 	if e.reload {
@@ -133,70 +164,119 @@ func (e *Engine) Render(w io.Writer, name string, data interface{}, layouts ...s
 	// Prepare binding data
 	binding := e.prepareBinding(data)
 
-	// Determine layout to use
-	layout := e.getLayout(layouts...)
+	// Determine layouts to use, innermost first
+	chain := e.getLayouts(layouts...)
 
-	// If layout is specified, render the view into the layout
-	if layout != "" {
-		return e.renderWithLayout(w, name, layout, binding)
+	// If layouts are specified, render the view into them
+	if len(chain) > 0 {
+		return e.renderWithLayouts(w, name, chain, binding)
 	}
 
 	// Direct render
 	return e.Engine.Render(w, name, binding)
 }
 
-// renderWithLayout renders a template with a layout
-func (e *Engine) renderWithLayout(w io.Writer, name, layout string, binding map[string]interface{}) error {
+// RenderWithContext renders a template like Render with the request-scoped
+// values configured by BindWith, such as the CSRF token, validation errors
+// and old input, taken from the Fiber locals. Values in data win over
+// locals with the same key.
+//
+//	app.Post("/register", func(c *fiber.Ctx) error {
+//	    c.Type("html")
+//	    return engine.RenderWithContext(c, c, "auth/register", fiber.Map{})
+//	})
+func (e *Engine) RenderWithContext(w io.Writer, c Locals, name string, data interface{}, layouts ...string) error {
+	return e.Render(w, name, e.prepareBinding(e.bindLocals(c), data), layouts...)
+}
+
+// bindLocals returns the configured locals that are set in c
+func (e *Engine) bindLocals(c Locals) map[string]interface{} {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	binding := make(map[string]interface{}, len(e.locals))
+	for key, local := range e.locals {
+		if value := c.Locals(local); value != nil {
+			binding[key] = value
+		}
+	}
+	return binding
+}
+
+// renderWithLayouts renders a template into a chain of layouts, innermost
+// first, each receiving the output of the previous one as its content
+func (e *Engine) renderWithLayouts(w io.Writer, name string, layouts []string, binding map[string]interface{}) error {
 	// First render the content template
 	content, err := e.Engine.RenderString(name, binding)
 	if err != nil {
 		return err
 	}
 
-	// Add content to binding
+	// Render the inner layouts around the content
+	last := len(layouts) - 1
+	for _, layout := range layouts[:last] {
+		binding["Content"] = content
+		binding["LayoutContent"] = content
+		if content, err = e.Engine.RenderString(layout, binding); err != nil {
+			return err
+		}
+	}
+
+	// Render the outermost layout
 	binding["Content"] = content
 	binding["LayoutContent"] = content
-
-	// Render the layout
-	return e.Engine.Render(w, layout, binding)
+	return e.Engine.Render(w, layouts[last], binding)
 }
 
-// prepareBinding converts data to map[string]interface{}
-func (e *Engine) prepareBinding(data interface{}) map[string]interface{} {
-	if data == nil {
-		return make(map[string]interface{})
-	}
-
-	switch d := data.(type) {
-	case map[string]interface{}:
-		return d
-	case map[string]string:
-		result := make(map[string]interface{}, len(d))
-		for k, v := range d {
-			result[k] = v
+// prepareBinding converts data to map[string]interface{}. Several layers
+// of data, such as locals and the data passed to a render, are merged in
+// order: nested maps are merged key by key and later layers win on
+// conflicting values.
+func (e *Engine) prepareBinding(layers ...interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, data := range layers {
+		switch d := data.(type) {
+		case nil:
+		case map[string]interface{}:
+			engine.MergeData(result, d)
+		case map[string]string:
+			for k, v := range d {
+				result[k] = v
+			}
+		default:
+			result["data"] = data
 		}
-		return result
-	default:
-		return map[string]interface{}{"data": data}
 	}
+	return result
 }
 
-// getLayout determines which layout to use
-func (e *Engine) getLayout(layouts ...string) string {
-	// Use layout from Render call if provided
+// getLayouts determines which layouts to use, innermost first
+func (e *Engine) getLayouts(layouts ...string) []string {
+	// Use layouts from Render call if provided
 	if len(layouts) > 0 && layouts[0] != "" {
-		return layouts[0]
+		return layoutChain(layouts)
 	}
 
 	// Use layout function if set
 	if e.layoutFunc != nil {
-		return e.layoutFunc()
+		return layoutChain([]string{e.layoutFunc()})
 	}
 
-	// Use default layout
+	// Use default layouts
 	e.mutex.RLock()
 	defer e.mutex.RUnlock()
-	return e.layout
+	return e.layouts
+}
+
+// layoutChain returns the non-empty layout names
+func layoutChain(layouts []string) []string {
+	var chain []string
+	for _, layout := range layouts {
+		if layout != "" {
+			chain = append(chain, layout)
+		}
+	}
+	return chain
 }
 
 // FuncMap returns the template function map
@@ -212,29 +292,124 @@ func (e *Engine) AddFunc(name string, fn interface{}) *Engine {
 
 // AddFuncMap adds multiple template functions
 func (e *Engine) AddFuncMap(funcs map[string]interface{}) *Engine {
-	for name, fn := range funcs {
-		e.Engine.AddFunction(name, fn)
-	}
+	e.Engine.AddFunctions(funcs)
 	return e
 }
 
-// Delims is a no-op for compatibility with other engines
-// Legit template uses {{ }} and {!! !!} delimiters which cannot be changed
+// Delims sets the delimiters of escaped echoes, e.g. Delims("[[", "]]")
+// to leave {{ }} to Vue. Comments and {!! !!} keep their syntax.
 func (e *Engine) Delims(left, right string) *Engine {
-	// No-op: Legit template delimiters are fixed
+	e.Engine.SetDelims(left, right)
 	return e
 }
 
 // HTTPHandler returns an http.Handler that renders the template
 func (e *Engine) HTTPHandler(name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		if err := e.Engine.Render(w, name, nil); err != nil {
+		if err := e.Engine.RenderToResponse(w, name, nil); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	})
 }
 
+// RenderResponse renders a template, wrapped in the layouts like Render,
+// and returns the body with the status code and headers declared by
+// @status and @header. Apply them in a Fiber handler:
+//
+//	resp, err := engine.RenderResponse("errors.404", data)
+//	if err != nil {
+//	    return err
+//	}
+//	for name := range resp.Header {
+//	    c.Set(name, resp.Header.Get(name))
+//	}
+//	return c.Status(resp.Status).Type("html").SendString(resp.Body)
+func (e *Engine) RenderResponse(name string, data interface{}, layouts ...string) (*runtime.Response, error) {
+	if e.reload {
+		e.ClearCache()
+	}
+
+	binding := e.prepareBinding(data)
+	resp, err := e.Engine.RenderResponse(name, binding)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+
+	for _, layout := range e.getLayouts(layouts...) {
+		binding["Content"] = resp.Body
+		binding["LayoutContent"] = resp.Body
+		wrapped, err := e.Engine.RenderResponse(layout, binding)
+		if err != nil {
+			return nil, err
+		}
+		resp.Merge(wrapped)
+		resp.Body = wrapped.Body
+	}
+
+	return resp, nil
+}
+
+// RenderCacheable renders a template like RenderResponse and adds an ETag,
+// computed from the rendered body, and a Last-Modified header from the
+// template and layout files. When ifNoneMatch, the request's If-None-Match
+// header, matches the ETag, the response has status 304 and no body:
+//
+//	resp, err := engine.RenderCacheable("pages.about", data, c.Get("If-None-Match"))
+//	if err != nil {
+//	    return err
+//	}
+//	for name := range resp.Header {
+//	    c.Set(name, resp.Header.Get(name))
+//	}
+//	return c.Status(resp.Status).Type("html").SendString(resp.Body)
+//
+// Responses with a status other than 200 are returned without validators.
+func (e *Engine) RenderCacheable(name string, data interface{}, ifNoneMatch string, layouts ...string) (*runtime.Response, error) {
+	resp, err := e.RenderResponse(name, data, layouts...)
+	if err != nil || resp.Status != http.StatusOK {
+		return resp, err
+	}
+
+	etag := `"` + engine.Checksum([]byte(resp.Body)) + `"`
+	resp.Header.Set("ETag", etag)
+	if modified := e.lastModified(append([]string{name}, e.getLayouts(layouts...)...)...); !modified.IsZero() {
+		resp.Header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if etagMatches(ifNoneMatch, etag) {
+		resp.Status = http.StatusNotModified
+		resp.Body = ""
+	}
+	return resp, nil
+}
+
+// lastModified returns the latest modification time of the given
+// templates, or zero when none is known
+func (e *Engine) lastModified(names ...string) time.Time {
+	var latest time.Time
+	for _, name := range names {
+		if modified, err := e.Engine.ModTime(name); err == nil && modified.After(latest) {
+			latest = modified
+		}
+	}
+	return latest
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison the header calls for
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // Templates returns all available template names
 func (e *Engine) Templates() []string {
 	templates, _ := e.Engine.Templates()
@@ -243,10 +418,10 @@ func (e *Engine) Templates() []string {
 
 // Options for the engine
 
-// WithLayout sets the default layout
-func WithLayout(layout string) func(*Engine) {
+// WithLayout sets the default layout and any layouts nesting it
+func WithLayout(layout string, outer ...string) func(*Engine) {
 	return func(e *Engine) {
-		e.layout = layout
+		e.layouts = layoutChain(append([]string{layout}, outer...))
 	}
 }
 
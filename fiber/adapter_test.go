@@ -0,0 +1,288 @@
+package fiber
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEngine_RenderCacheable(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "about.legit")
+	if err := os.WriteFile(path, []byte("<h1>{{ $title }}</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(dir)
+	data := map[string]interface{}{"title": "About"}
+
+	resp, err := e.RenderCacheable("about", data, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	etag := resp.Header.Get("ETag")
+	if resp.Status != http.StatusOK || resp.Body != "<h1>About</h1>" || etag == "" {
+		t.Fatalf("expected a full response with an ETag, got %d %q %q", resp.Status, resp.Body, etag)
+	}
+	if got := resp.Header.Get("Last-Modified"); got != "Wed, 01 May 2024 12:00:00 GMT" {
+		t.Errorf("unexpected Last-Modified %q", got)
+	}
+
+	resp, err = e.RenderCacheable("about", data, `"other", W/`+etag)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	if err := resp.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+		t.Errorf("expected 304 with the ETag and no body, got %d %q %q", rec.Code, rec.Body.String(), rec.Header().Get("ETag"))
+	}
+
+	resp, err = e.RenderCacheable("about", data, `"stale"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != http.StatusOK || resp.Body != "<h1>About</h1>" {
+		t.Errorf("expected a full response for a stale ETag, got %d %q", resp.Status, resp.Body)
+	}
+
+	resp, err = e.RenderCacheable("about", map[string]interface{}{"title": "Changed"}, etag)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("expected a new ETag for changed content, got %d %q", resp.Status, resp.Header.Get("ETag"))
+	}
+}
+
+// stubLocals stands in for *fiber.Ctx
+type stubLocals map[interface{}]interface{}
+
+func (s stubLocals) Locals(key interface{}, value ...interface{}) interface{} {
+	if len(value) > 0 {
+		s[key] = value[0]
+	}
+	return s[key]
+}
+
+func TestEngine_RenderWithContext(t *testing.T) {
+	dir := t.TempDir()
+	tpl := `<form>@csrf<input name="email" value="@old('email')">@error('email')<p>{{ $message }}</p>@enderror@flash('status')<b>{{ $message }}</b>@endflash{{ $title }}</form>`
+	if err := os.WriteFile(filepath.Join(dir, "register.legit"), []byte(tpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := stubLocals{
+		"csrf":   "tok123",
+		"errors": map[string][]string{"email": {"Email is taken"}},
+		"old":    map[string]interface{}{"email": "ada@example.com"},
+		"flash":  map[string]string{"status": "Try again"},
+		"title":  "ignored",
+	}
+
+	e := New(dir)
+	var buf bytes.Buffer
+	if err := e.RenderWithContext(&buf, c, "register", map[string]interface{}{"title": "Register"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `<form><input type="hidden" name="_token" value="tok123"><input name="email" value="ada@example.com"><p>Email is taken</p><b>Try again</b>Register</form>`
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	e.BindWith(map[string]string{"csrf_token": "token", "title": "title"})
+	if err := e.RenderWithContext(&buf, c, "register", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = `<form><input type="hidden" name="_token" value=""><input name="email" value="">ignored</form>`
+	if buf.String() != expected {
+		t.Errorf("expected only the configured locals, got %q", buf.String())
+	}
+}
+
+func TestEngine_RenderDeepMergesData(t *testing.T) {
+	dir := t.TempDir()
+	tpl := "{{ $config['a'] }}{{ $config['b'] }}{{ $config['c'] }}"
+	if err := os.WriteFile(filepath.Join(dir, "page.legit"), []byte(tpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(dir)
+	e.Share("config", map[string]interface{}{"a": 1, "b": 0})
+	e.BindWith(map[string]string{"config": "config"})
+	c := stubLocals{"config": map[string]interface{}{"b": 2, "c": 0}}
+
+	var buf bytes.Buffer
+	if err := e.RenderWithContext(&buf, c, "page", map[string]interface{}{
+		"config": map[string]interface{}{"c": 3},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "123" {
+		t.Errorf("expected shared data, locals and call data to merge, got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := e.Render(&buf, "page", map[string]interface{}{
+		"config": map[string]interface{}{"b": 2, "c": 3},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "123" {
+		t.Errorf("expected shared and call data to merge, got %q", buf.String())
+	}
+}
+
+func TestEngine_NestedLayouts(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"page.legit":          "<p>{{ $title }}</p>",
+		"layouts/admin.legit": "<main>{!! $Content !!}</main>",
+		"layouts/base.legit":  "<body>{!! $Content !!}</body>",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := map[string]interface{}{"title": "Users"}
+	expected := "<body><main><p>Users</p></main></body>"
+
+	e := New(dir)
+	var buf bytes.Buffer
+	if err := e.Render(&buf, "page", data, "layouts/admin", "layouts/base"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	e.Layout("layouts/admin", "layouts/base")
+	buf.Reset()
+	if err := e.Render(&buf, "page", data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("default layouts: expected %q, got %q", expected, buf.String())
+	}
+
+	resp, err := e.RenderResponse("page", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Body != expected {
+		t.Errorf("RenderResponse: expected %q, got %q", expected, resp.Body)
+	}
+}
+
+func TestEngine_TemplateNameSeparators(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "partials", "header.legit")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("<h1>{{ $title }}</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(dir)
+	if err := e.Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats := e.CacheStats(); stats.Size != 1 {
+		t.Fatalf("expected one pre-compiled template, got %d", stats.Size)
+	}
+
+	for _, name := range []string{"partials/header", "partials.header"} {
+		var buf bytes.Buffer
+		if err := e.Render(&buf, name, map[string]interface{}{"title": "Hi"}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if buf.String() != "<h1>Hi</h1>" {
+			t.Errorf("%s: unexpected output %q", name, buf.String())
+		}
+	}
+
+	if stats := e.CacheStats(); stats.Size != 1 || stats.Misses != 1 {
+		t.Errorf("expected both names to use the pre-compiled template, got %+v", stats)
+	}
+}
+
+func TestEngine_Delims(t *testing.T) {
+	dir := t.TempDir()
+	tpl := `<div id="app"><h1>[[ $title ]]</h1><p>{{ message }}</p>{{-- note --}}{!! $html !!}</div>`
+	if err := os.WriteFile(filepath.Join(dir, "vue.legit"), []byte(tpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"title": "Inbox", "html": "<b>!</b>"}
+
+	e := New(dir).Delims("[[", "]]")
+	var buf bytes.Buffer
+	if err := e.Render(&buf, "vue", data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `<div id="app"><h1>Inbox</h1><p>{{ message }}</p><b>!</b></div>`; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	e.Delims("{{", "}}")
+	buf.Reset()
+	if err := e.Render(&buf, "vue", data); err == nil {
+		t.Errorf("expected {{ message }} to be an expression with the default delimiters, got %q", buf.String())
+	}
+}
+
+func TestEngine_LoadCompilesWithoutData(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"profile.legit":       "<h1>{{ $user->name }}</h1>@foreach($user->posts as $post){{ $post->title }}@endforeach",
+		"layouts/main.legit":  "<body>@yield('content')</body>",
+		"partials/item.legit": "<li>{{ $item['label'] }}</li>",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := New(dir)
+	if err := e.Load(); err != nil {
+		t.Fatalf("expected data-dependent templates to pre-load, got %v", err)
+	}
+	if stats := e.CacheStats(); stats.Size != 3 {
+		t.Errorf("expected 3 pre-compiled templates, got %d", stats.Size)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.legit"), []byte("@if($a)never closed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Load(); err != nil {
+		t.Fatalf("expected a broken template not to fail the load, got %v", err)
+	}
+	if stats := e.CacheStats(); stats.Size != 3 {
+		t.Errorf("expected the other templates to stay pre-compiled, got %d", stats.Size)
+	}
+	var buf bytes.Buffer
+	if err := e.Render(&buf, "broken", nil); err == nil {
+		t.Error("expected rendering the broken template to fail")
+	}
+}
@@ -45,9 +45,14 @@ package legitview
 import (
 	"html/template"
 	"io"
+	"io/fs"
+	"time"
 
 	"github.com/codingersid/legit-template/engine"
 	fiberAdapter "github.com/codingersid/legit-template/fiber"
+	"github.com/codingersid/legit-template/lexer"
+	"github.com/codingersid/legit-template/parser"
+	"github.com/codingersid/legit-template/runtime"
 )
 
 // Version is the current version of legit-view
@@ -59,6 +64,27 @@ type Engine = engine.Engine
 // Option is an alias for engine.Option
 type Option = engine.Option
 
+// Config is an alias for engine.Config
+type Config = engine.Config
+
+// Collection is an alias for runtime.Collection
+type Collection = runtime.Collection
+
+// Paginator is an alias for runtime.Paginator
+type Paginator = runtime.Paginator
+
+// Token is an alias for lexer.Token
+type Token = lexer.Token
+
+// LintIssue is an alias for parser.LintIssue
+type LintIssue = parser.LintIssue
+
+// Response is an alias for runtime.Response
+type Response = runtime.Response
+
+// FragmentCache is an alias for engine.FragmentCache
+type FragmentCache = engine.FragmentCache
+
 // New creates a new template engine
 //
 // Example:
@@ -69,6 +95,18 @@ func New(viewsPath string, opts ...Option) *Engine {
 	return engine.New(viewsPath, opts...)
 }
 
+// NewWithConfig creates a new template engine from a Config
+//
+// Example:
+//
+//	engine := legitview.NewWithConfig(legitview.Config{
+//	    ViewsPath:   "./resources/views",
+//	    Development: true,
+//	})
+func NewWithConfig(cfg Config) *Engine {
+	return engine.NewWithConfig(cfg)
+}
+
 // NewFiber creates a new Fiber-compatible template engine
 //
 // Example:
@@ -86,11 +124,86 @@ func WithExtension(ext string) Option {
 	return engine.WithExtension(ext)
 }
 
+// WithExtensions sets the template file extensions, tried in order
+func WithExtensions(exts ...string) Option {
+	return engine.WithExtensions(exts...)
+}
+
 // WithDevelopment enables development mode (disables caching)
 func WithDevelopment(dev bool) Option {
 	return engine.WithDevelopment(dev)
 }
 
+// WithStrictDirectives makes unknown directives a compile error
+func WithStrictDirectives(strict bool) Option {
+	return engine.WithStrictDirectives(strict)
+}
+
+// WithClock sets the clock used by now, ago and the date functions
+func WithClock(clock func() time.Time) Option {
+	return engine.WithClock(clock)
+}
+
+// WithDelims changes the delimiters of escaped echoes from {{ }}
+func WithDelims(left, right string) Option {
+	return engine.WithDelims(left, right)
+}
+
+// WithCollapseWhitespace collapses whitespace-only text between tags and directives
+func WithCollapseWhitespace(collapse bool) Option {
+	return engine.WithCollapseWhitespace(collapse)
+}
+
+// WithKeepComments renders {{-- --}} comments as HTML comments
+func WithKeepComments(keep bool) Option {
+	return engine.WithKeepComments(keep)
+}
+
+// WithFragmentCache sets the store for @cache blocks (default: in memory)
+func WithFragmentCache(store FragmentCache) Option {
+	return engine.WithFragmentCache(store)
+}
+
+// WithCSRFFieldName sets the input name rendered by @csrf (default: _token)
+func WithCSRFFieldName(name string) Option {
+	return engine.WithCSRFFieldName(name)
+}
+
+// WithCSRFTokenResolver sets the function that provides the token rendered by @csrf
+func WithCSRFTokenResolver(resolver engine.CSRFResolver) Option {
+	return engine.WithCSRFTokenResolver(resolver)
+}
+
+// WithFeatureResolver sets the function that reports enabled @feature flags
+func WithFeatureResolver(resolver engine.FeatureResolver) Option {
+	return engine.WithFeatureResolver(resolver)
+}
+
+// WithComponentsPath sets the directory @component templates are loaded from (default: components)
+func WithComponentsPath(dir string) Option {
+	return engine.WithComponentsPath(dir)
+}
+
+// WithRawGuard runs the content of every {!! !!} echo through a sanitizer
+func WithRawGuard(guard engine.RawGuard) Option {
+	return engine.WithRawGuard(guard)
+}
+
+// WithFileSystem reads templates from the given file system (e.g. embed.FS)
+func WithFileSystem(fsys fs.FS) Option {
+	return engine.WithFileSystem(fsys)
+}
+
+// WithCacheLimit limits the number of cached templates (LRU eviction)
+func WithCacheLimit(n int) Option {
+	return engine.WithCacheLimit(n)
+}
+
+// WithChecksumValidation validates cached templates by content checksum
+func WithChecksumValidation(enabled bool) Option {
+	return engine.WithChecksumValidation(enabled)
+}
+
 // WithFunctions adds custom template functions
 func WithFunctions(funcs template.FuncMap) Option {
 	return engine.WithFunctions(funcs)
@@ -125,6 +238,9 @@ var Directives = []string{
 	"@section",
 	"@endsection",
 	"@show",
+	"@stop",
+	"@append",
+	"@overwrite",
 	"@yield",
 	"@parent",
 
@@ -169,6 +285,13 @@ var Directives = []string{
 	"@endauth",
 	"@guest",
 	"@endguest",
+	"@allow",
+	"@endallow",
+
+	// Feature flags
+	"@feature",
+	"@featureelse",
+	"@endfeature",
 
 	// Environment
 	"@env",
@@ -183,21 +306,32 @@ var Directives = []string{
 	"@endprepend",
 	"@pushOnce",
 	"@endPushOnce",
+	"@pushIf",
+	"@endPushIf",
 	"@stack",
+	"@endstack",
 
 	// Components
 	"@component",
 	"@endcomponent",
 	"@slot",
 	"@endslot",
+	"@aware",
+	"@props",
 
 	// Forms
 	"@csrf",
 	"@method",
 	"@error",
 	"@enderror",
+	"@flash",
+	"@endflash",
 	"@old",
 
+	// Response
+	"@status",
+	"@header",
+
 	// Attributes
 	"@class",
 	"@style",
@@ -209,38 +343,50 @@ var Directives = []string{
 
 	// Miscellaneous
 	"@json",
+	"@dump",
 	"@verbatim",
 	"@endverbatim",
 	"@php",
 	"@endphp",
 	"@once",
 	"@endonce",
+	"@spaceless",
+	"@endspaceless",
+	"@cache",
+	"@endcache",
 }
 
-// Functions lists all built-in template functions
+// Functions lists all built-in template functions. Engine.FunctionNames
+// returns the functions registered on a configured engine.
 var Functions = []string{
 	// String
 	"upper", "lower", "title", "trim", "ltrim", "rtrim",
 	"replace", "contains", "hasPrefix", "hasSuffix",
-	"split", "join", "repeat", "substr", "length",
+	"split", "splitN", "splitLines", "words", "join", "repeat", "substr", "length",
 	"nl2br", "ucfirst", "lcfirst", "slug", "limit", "wordLimit",
+	"padLeft", "padRight", "center", "mask", "excerpt", "limitHTML",
+
+	// Regex
+	"replaceRegex", "match", "matchAll",
 
 	// HTML
 	"html", "htmlAttr", "js", "url",
-	"safeHTML", "safeJS", "safeURL", "safeCSS",
+	"safeHTML", "raw", "sanitizeRaw", "safeJS", "safeURL", "safeCSS",
 
 	// Array/Slice
-	"first", "last", "reverse", "sortAsc", "sortDesc",
-	"unique", "pluck", "where", "groupBy", "chunk",
-	"flatten", "slice", "append", "prepend", "merge",
+	"first", "last", "reverse", "sortAsc", "sortDesc", "sortBy",
+	"unique", "pluck", "where", "whereOp", "groupBy", "groupByOrdered", "chunk", "paginate",
+	"collect",
+	"flatten", "slice", "append", "prepend", "merge", "inArray",
 
 	// Map
-	"dict", "set", "unset", "keys", "values", "hasKey",
+	"dict", "list", "set", "unset", "keys", "values", "hasKey",
 
 	// Number
 	"add", "sub", "mul", "div", "mod",
 	"round", "floor", "ceil", "abs",
-	"min", "max", "currency", "number", "percent",
+	"min", "max", "currency", "number", "percent", "parseFloat",
+	"sum", "sumBy", "avgBy", "countBy", "minBy", "maxBy",
 
 	// Date
 	"date", "now", "ago", "diff", "addDate", "subDate", "timestamp",
@@ -249,17 +395,44 @@ var Functions = []string{
 	"eq", "ne", "lt", "gt", "lte", "gte", "and", "or", "not",
 
 	// Utility
-	"default", "isset", "empty", "dump", "json", "jsonDec",
+	"default", "isset", "empty", "exists", "dump", "dumpHTML", "json", "jsonDec",
 	"seq", "until", "index", "printf", "print",
 	"coalesce", "ternary", "typeof",
 	"toInt", "toFloat", "toString", "toBool",
 
 	// Loop
-	"newLoop",
+	"newLoop", "iterate",
 
 	// Validation
-	"hasError", "getError",
+	"hasError", "getError", "getErrors", "old", "getFlash",
+
+	// Forms
+	"csrfToken",
+
+	// Shared data
+	"shared",
+
+	// Permissions
+	"allow",
+
+	// Feature flags
+	"feature",
+
+	// Response
+	"setStatus", "setHeader",
 
 	// Class/Style
 	"classArray", "styleArray",
+
+	// Output
+	"spaceless",
+
+	// Stacks
+	"stack", "pushStack", "prependStack",
+
+	// Views
+	"each", "includeFirst", "extendData", "componentData", "newSlot", "aware", "attributesExcept", "slot",
+
+	// Custom directives
+	"customDirective",
 }
@@ -3,6 +3,7 @@ package lexer
 import (
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // TokenType represents the type of token
@@ -49,16 +50,17 @@ func (t TokenType) String() string {
 // Position represents location in source
 type Position struct {
 	Line   int
-	Column int
-	Offset int
+	Column int // 1-based, counted in runes
+	Offset int // 0-based, counted in bytes
 }
 
 // Token represents a lexical token
 type Token struct {
-	Type     TokenType
-	Value    string
-	Args     string // For directives with arguments
-	Position Position
+	Type       TokenType
+	Value      string
+	Args       string // For directives with arguments
+	Position   Position
+	Whitespace bool // Text containing only whitespace, flagged when enabled with SetMarkWhitespace
 }
 
 // Lexer tokenizes legit template files
@@ -69,6 +71,13 @@ type Lexer struct {
 	column       int
 	inVerbatim   bool
 	tokens       []Token
+
+	// Flag whitespace-only text tokens
+	markWhitespace bool
+
+	// Delimiters of escaped echoes
+	left  string
+	right string
 }
 
 // New creates a new Lexer
@@ -79,9 +88,30 @@ func New(input string) *Lexer {
 		line:   1,
 		column: 1,
 		tokens: make([]Token, 0),
+		left:   "{{",
+		right:  "}}",
+	}
+}
+
+// SetDelims changes the delimiters of escaped echoes from {{ }}, for
+// example to [[ ]] so {{ }} in the template is left to a JavaScript
+// framework. Comments and raw echoes keep their syntax. Empty delimiters
+// keep the default.
+func (l *Lexer) SetDelims(left, right string) {
+	if left != "" {
+		l.left = left
+	}
+	if right != "" {
+		l.right = right
 	}
 }
 
+// SetMarkWhitespace flags text tokens that contain only whitespace, so
+// later stages can collapse them. Token values are never changed.
+func (l *Lexer) SetMarkWhitespace(mark bool) {
+	l.markWhitespace = mark
+}
+
 // Tokenize processes the entire input and returns all tokens
 func (l *Lexer) Tokenize() ([]Token, error) {
 	for l.pos < len(l.input) {
@@ -135,7 +165,7 @@ func (l *Lexer) nextToken() (Token, error) {
 	}
 
 	// Check for escaped echo {{ ... }}
-	if l.matchString("{{") {
+	if l.matchString(l.left) {
 		return l.scanEscapedEcho(startPos)
 	}
 
@@ -210,14 +240,14 @@ func (l *Lexer) scanRawEcho(startPos Position) (Token, error) {
 
 // scanEscapedEcho scans escaped echo {{ ... }}
 func (l *Lexer) scanEscapedEcho(startPos Position) (Token, error) {
-	l.advanceN(2) // Skip {{
+	l.advanceN(len(l.left)) // Skip {{
 	l.skipWhitespace()
 
 	start := l.pos
 	for l.pos < len(l.input) {
-		if l.matchString("}}") {
+		if l.matchString(l.right) {
 			content := strings.TrimSpace(l.input[start:l.pos])
-			l.advanceN(2) // Skip }}
+			l.advanceN(len(l.right)) // Skip }}
 			return Token{
 				Type:     TOKEN_ECHO_ESCAPED,
 				Value:    content,
@@ -333,7 +363,7 @@ func (l *Lexer) scanText(startPos Position) (Token, error) {
 
 	for l.pos < len(l.input) {
 		// Stop at special sequences
-		if l.matchString("{{") || l.matchString("{!!") || l.matchString("@@") {
+		if l.matchString(l.left) || l.matchString("{{--") || l.matchString("{!!") || l.matchString("@@") {
 			break
 		}
 		if l.current() == '@' && l.pos+1 < len(l.input) && (unicode.IsLetter(rune(l.input[l.pos+1])) || l.input[l.pos+1] == '_') {
@@ -348,19 +378,27 @@ func (l *Lexer) scanText(startPos Position) (Token, error) {
 	}
 
 	return Token{
-		Type:     TOKEN_TEXT,
-		Value:    content,
-		Position: startPos,
+		Type:       TOKEN_TEXT,
+		Value:      content,
+		Position:   startPos,
+		Whitespace: l.markWhitespace && strings.TrimSpace(content) == "",
 	}, nil
 }
 
-// scanVerbatimContent scans content inside @verbatim...@endverbatim
+// scanVerbatimContent scans content inside @verbatim...@endverbatim.
+// @@endverbatim is an escaped, literal @endverbatim.
 func (l *Lexer) scanVerbatimContent(startPos Position) (Token, error) {
-	start := l.pos
+	var buf strings.Builder
 
 	for l.pos < len(l.input) {
+		if l.matchString("@@endverbatim") {
+			buf.WriteString("@endverbatim")
+			l.advanceN(13)
+			continue
+		}
 		if l.matchString("@endverbatim") {
-			content := l.input[start:l.pos]
+			content := buf.String()
+			endPos := Position{Line: l.line, Column: l.column, Offset: l.pos}
 			l.advanceN(12) // Skip @endverbatim
 			l.inVerbatim = false
 
@@ -376,13 +414,10 @@ func (l *Lexer) scanVerbatimContent(startPos Position) (Token, error) {
 			return Token{
 				Type:     TOKEN_VERBATIM_END,
 				Value:    "endverbatim",
-				Position: Position{
-					Line:   l.line,
-					Column: l.column - 12,
-					Offset: l.pos - 12,
-				},
+				Position: endPos,
 			}, nil
 		}
+		buf.WriteByte(l.current())
 		l.advance()
 	}
 
@@ -406,7 +441,8 @@ func (l *Lexer) advance() {
 		if l.input[l.pos] == '\n' {
 			l.line++
 			l.column = 1
-		} else {
+		} else if utf8.RuneStart(l.input[l.pos]) {
+			// Columns count runes; continuation bytes only advance the offset
 			l.column++
 		}
 		l.pos++
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -214,6 +215,87 @@ func TestLexer_Verbatim(t *testing.T) {
 	}
 }
 
+func TestLexer_VerbatimEscapedEnd(t *testing.T) {
+	input := "@verbatim Close it with @@endverbatim. {{ $x }} @endverbatim!"
+	tokens, err := New(input).Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tokens) != 5 { // VERBATIM_START + TEXT + VERBATIM_END + TEXT + EOF
+		t.Fatalf("expected 5 tokens, got %+v", tokens)
+	}
+	if tokens[1].Value != " Close it with @endverbatim. {{ $x }} " {
+		t.Errorf("unexpected verbatim content %q", tokens[1].Value)
+	}
+	if tokens[2].Type != TOKEN_VERBATIM_END || tokens[3].Value != "!" {
+		t.Errorf("expected verbatim to end at the unescaped @endverbatim, got %+v", tokens[2:])
+	}
+}
+
+func TestLexer_VerbatimMultilinePosition(t *testing.T) {
+	input := "a\n@verbatim\n  {{ $x }}\n  done @endverbatim"
+	tokens, err := New(input).Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tok := range tokens {
+		if tok.Type != TOKEN_VERBATIM_END {
+			continue
+		}
+		want := Position{Line: 4, Column: 8, Offset: strings.Index(input, "@endverbatim")}
+		if tok.Position != want {
+			t.Errorf("expected @endverbatim at %+v, got %+v", want, tok.Position)
+		}
+		return
+	}
+	t.Fatal("expected VERBATIM_END token")
+}
+
+func TestLexer_VerbatimEndAtLineStart(t *testing.T) {
+	input := "@verbatim\n{{ $x }}\n@endverbatim\nafter"
+	tokens, err := New(input).Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tokens) != 5 || tokens[2].Type != TOKEN_VERBATIM_END {
+		t.Fatalf("unexpected tokens %+v", tokens)
+	}
+	want := Position{Line: 3, Column: 1, Offset: 19}
+	if tokens[2].Position != want {
+		t.Errorf("expected @endverbatim at %+v, got %+v", want, tokens[2].Position)
+	}
+	if tokens[3].Position.Line != 3 || tokens[3].Position.Column != 13 {
+		t.Errorf("expected trailing text at 3:13, got %+v", tokens[3].Position)
+	}
+}
+
+func TestLexer_MultiByteColumns(t *testing.T) {
+	input := "héllo 👋 ünï\n¡ @if($x)"
+	tokens, err := New(input).Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tokens[1].Type != TOKEN_DIRECTIVE_ARGS {
+		t.Fatalf("expected directive, got %+v", tokens[1])
+	}
+	want := Position{Line: 2, Column: 3, Offset: strings.Index(input, "@if")}
+	if tokens[1].Position != want {
+		t.Errorf("expected @if at %+v, got %+v", want, tokens[1].Position)
+	}
+
+	tokens, err = New("日本語 {{ $x }}").Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tokens[1].Position.Column != 5 || tokens[1].Position.Offset != 10 {
+		t.Errorf("expected echo at column 5, offset 10, got %+v", tokens[1].Position)
+	}
+}
+
 func TestLexer_ComplexTemplate(t *testing.T) {
 	input := `@extends('layouts.app')
 
@@ -303,3 +385,75 @@ func TestLexer_StringsInArgs(t *testing.T) {
 		t.Errorf("unexpected args: %q", tokens[0].Args)
 	}
 }
+
+func TestLexer_MarkWhitespace(t *testing.T) {
+	input := "<p>\n  @if($a)\n    {{ $a }}\n  @endif\n</p>"
+
+	lex := New(input)
+	lex.SetMarkWhitespace(true)
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var flagged, text []string
+	for _, tok := range tokens {
+		if tok.Type != TOKEN_TEXT {
+			continue
+		}
+		if tok.Whitespace {
+			flagged = append(flagged, tok.Value)
+		} else {
+			text = append(text, tok.Value)
+		}
+	}
+
+	if len(flagged) != 2 || flagged[0] != "\n    " || flagged[1] != "\n  " {
+		t.Errorf("unexpected whitespace tokens: %q", flagged)
+	}
+	if len(text) != 2 || text[0] != "<p>\n  " || text[1] != "\n</p>" {
+		t.Errorf("unexpected text tokens: %q", text)
+	}
+}
+
+func TestLexer_MarkWhitespaceDisabled(t *testing.T) {
+	tokens, err := New("@if($a)\n  @endif").Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tokens[1].Type != TOKEN_TEXT || tokens[1].Value != "\n  " {
+		t.Fatalf("expected whitespace text token, got %+v", tokens[1])
+	}
+	if tokens[1].Whitespace {
+		t.Error("expected whitespace tokens to be unflagged by default")
+	}
+}
+
+func TestLexer_SetDelims(t *testing.T) {
+	lex := New("<p>{{ msg }}</p>[[ $name ]]{{-- note --}}{!! $html !!}")
+	lex.SetDelims("[[", "]]")
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []struct {
+		typ   TokenType
+		value string
+	}{
+		{TOKEN_TEXT, "<p>{{ msg }}</p>"},
+		{TOKEN_ECHO_ESCAPED, "$name"},
+		{TOKEN_COMMENT, "note"},
+		{TOKEN_ECHO_RAW, "$html"},
+		{TOKEN_EOF, ""},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, exp := range expected {
+		if tokens[i].Type != exp.typ || tokens[i].Value != exp.value {
+			t.Errorf("token %d: expected %s %q, got %s %q", i, exp.typ, exp.value, tokens[i].Type, tokens[i].Value)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/codingersid/legit-template/lexer"
+)
+
+// unsupported lists Blade directives this engine does not implement.
+// Convert keeps them and flags them with a comment.
+var unsupported = map[string]bool{
+	"hasSection": true, "sectionMissing": true,
+	"cannot": true, "endcannot": true, "canany": true, "endcanany": true,
+	"elsecan": true, "elsecannot": true,
+	"lang": true, "choice": true, "inject": true, "js": true, "dd": true,
+	"session": true, "endsession": true, "fragment": true, "endfragment": true,
+	"livewire": true, "livewireStyles": true, "livewireScripts": true,
+	"vite": true, "viteReactRefresh": true, "use": true,
+}
+
+// renamed maps Blade directives to their legit equivalent
+var renamed = map[string]string{
+	"can":    "allow",
+	"endcan": "endallow",
+}
+
+// helperRe matches calls to Laravel helpers that have no template function
+var helperRe = regexp.MustCompile(`(?:^|[^\w$>])(__|trans|trans_choice|route|asset|url|config|session|request|auth|csrf_field|mix|vite)\(`)
+
+// componentTagRe matches Blade component tags such as <x-alert>
+var componentTagRe = regexp.MustCompile(`<x-[\w.:-]+`)
+
+// phpAssignRe matches the @php statements legit supports
+var phpAssignRe = regexp.MustCompile(`^\$[a-zA-Z_][a-zA-Z0-9_]*\s*=[^=]`)
+
+// Convert translates a Blade template into legit syntax where the two
+// differ:
+//   - {{ $a ?? 'b' }} becomes {{ coalesce($a, 'b') }}. Unlike ??, coalesce
+//     also skips empty values such as "" and 0.
+//   - @php...@endphp blocks become one @php($var = ...) per assignment
+//   - @json flags such as JSON_PRETTY_PRINT are dropped
+//   - @can...@endcan becomes @allow...@endallow
+//
+// Constructs the engine does not support, such as <x-component> tags,
+// Laravel helpers and unsupported directives, are kept and flagged with a
+// {{-- legit: ... --}} comment in front of them.
+func Convert(bladeSource string) (string, error) {
+	tokens, err := lexer.New(bladeSource).Tokenize()
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(tokens)-1; i++ {
+		tok := tokens[i]
+		raw := bladeSource[tok.Position.Offset:tokens[i+1].Position.Offset]
+
+		switch tok.Type {
+		case lexer.TOKEN_ECHO_ESCAPED:
+			out.WriteString(convertEcho(raw, tok.Value, "{{ ", " }}"))
+		case lexer.TOKEN_ECHO_RAW:
+			out.WriteString(convertEcho(raw, tok.Value, "{!! ", " !!}"))
+		case lexer.TOKEN_DIRECTIVE, lexer.TOKEN_DIRECTIVE_ARGS:
+			if tok.Value == "php" && tok.Type == lexer.TOKEN_DIRECTIVE {
+				end, err := convertPhpBlock(&out, bladeSource, tokens, i)
+				if err != nil {
+					return "", err
+				}
+				i = end
+				continue
+			}
+			out.WriteString(convertDirective(raw, tok))
+		case lexer.TOKEN_TEXT:
+			out.WriteString(flagComponentTags(raw))
+		default:
+			out.WriteString(raw)
+		}
+	}
+
+	return out.String(), nil
+}
+
+// convertEcho converts the expression of an echo, keeping the original
+// text when nothing changes
+func convertEcho(raw, expr, open, close string) string {
+	converted := convertExpression(expr)
+
+	var flag string
+	if m := helperRe.FindStringSubmatch(expr); m != nil {
+		flag = note("%s() is not supported", m[1])
+	}
+
+	if converted == expr {
+		return flag + raw
+	}
+	return flag + open + converted + close
+}
+
+// convertExpression rewrites the null coalescing operator
+func convertExpression(expr string) string {
+	parts := splitTopLevel(expr, "??")
+	if len(parts) < 2 {
+		return expr
+	}
+	return "coalesce(" + strings.Join(parts, ", ") + ")"
+}
+
+// convertDirective renames directives, drops @json flags and flags
+// unsupported directives
+func convertDirective(raw string, tok lexer.Token) string {
+	if name, ok := renamed[tok.Value]; ok {
+		return "@" + name + strings.TrimPrefix(raw, "@"+tok.Value)
+	}
+
+	if unsupported[tok.Value] {
+		return note("@%s is not supported", tok.Value) + raw
+	}
+
+	if tok.Value == "json" && tok.Type == lexer.TOKEN_DIRECTIVE_ARGS {
+		args := splitTopLevel(tok.Args, ",")
+		if len(args) > 1 {
+			return note("@json flags %s were dropped", strings.Join(args[1:], ", ")) + "@json(" + args[0] + ")"
+		}
+	}
+
+	return raw
+}
+
+// convertPhpBlock converts the @php block starting at tokens[start] and
+// returns the index of its @endphp token
+func convertPhpBlock(out *strings.Builder, src string, tokens []lexer.Token, start int) (int, error) {
+	end := start + 1
+	for end < len(tokens)-1 && !(tokens[end].Type == lexer.TOKEN_DIRECTIVE && tokens[end].Value == "endphp") {
+		end++
+	}
+	if end >= len(tokens)-1 {
+		return 0, fmt.Errorf("unclosed @php block at line %d", tokens[start].Position.Line)
+	}
+
+	code := src[tokens[start+1].Position.Offset:tokens[end].Position.Offset]
+	var lines []string
+	for _, stmt := range splitTopLevel(code, ";") {
+		if stmt == "" {
+			continue
+		}
+		if phpAssignRe.MatchString(stmt) {
+			lines = append(lines, "@php("+stmt+")")
+		} else {
+			lines = append(lines, note("unsupported PHP statement: %s", stmt))
+		}
+	}
+	out.WriteString(strings.Join(lines, "\n"))
+	return end, nil
+}
+
+// flagComponentTags flags each Blade component tag in text
+func flagComponentTags(text string) string {
+	return componentTagRe.ReplaceAllStringFunc(text, func(tag string) string {
+		return note("component tag %s> is not supported, use @component", tag) + tag
+	})
+}
+
+// note returns a legit comment flagging a construct for manual migration
+func note(format string, args ...interface{}) string {
+	return "{{-- legit: " + fmt.Sprintf(format, args...) + " --}}"
+}
+
+// splitTopLevel splits s on sep outside of string literals and brackets,
+// trimming each part
+func splitTopLevel(s, sep string) []string {
+	var parts []string
+	depth := 0
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == quote && s[i-1] != '\\' {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '(' || ch == '[' || ch == '{':
+			depth++
+		case ch == ')' || ch == ']' || ch == '}':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+
+	return append(parts, strings.TrimSpace(s[start:]))
+}
@@ -0,0 +1,73 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvert_BladeFile(t *testing.T) {
+	blade := `@extends('layouts.app')
+
+@section('content')
+    <h1>{{ $title ?? 'Untitled' }}</h1>
+    {!! $user->bio ?? '<p>none</p>' !!}
+    @php
+        $total = count($items);
+        echo $total;
+    @endphp
+    <script>var data = @json($items, JSON_PRETTY_PRINT);</script>
+    @can('edit-post')
+        <a href="{{ route('posts.edit') }}">Edit</a>
+    @endcan
+    @hasSection('sidebar')x@endif
+    <x-alert type="info">Saved</x-alert>
+    {{-- keep {{ $a ?? 'b' }} --}}
+    @verbatim{{ $raw ?? 'raw' }}@endverbatim
+    @@can
+@endsection`
+
+	expected := `@extends('layouts.app')
+
+@section('content')
+    <h1>{{ coalesce($title, 'Untitled') }}</h1>
+    {!! coalesce($user->bio, '<p>none</p>') !!}
+    @php($total = count($items))
+{{-- legit: unsupported PHP statement: echo $total --}}
+    <script>var data = {{-- legit: @json flags JSON_PRETTY_PRINT were dropped --}}@json($items);</script>
+    @allow('edit-post')
+        <a href="{{-- legit: route() is not supported --}}{{ route('posts.edit') }}">Edit</a>
+    @endallow
+    {{-- legit: @hasSection is not supported --}}@hasSection('sidebar')x@endif
+    {{-- legit: component tag <x-alert> is not supported, use @component --}}<x-alert type="info">Saved</x-alert>
+    {{-- keep {{ $a ?? 'b' }} --}}
+    @verbatim{{ $raw ?? 'raw' }}@endverbatim
+    @@can
+@endsection`
+
+	out, err := Convert(blade)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestConvert_Unchanged(t *testing.T) {
+	src := "@if($a)\n  {{ $a->name }} @include('x', ['y' => 1])\n@endif"
+
+	out, err := Convert(src)
+	if err != nil || out != src {
+		t.Errorf("expected source unchanged, got %q (%v)", out, err)
+	}
+}
+
+func TestConvert_Errors(t *testing.T) {
+	for _, src := range []string{"{{ $a", "@php $a = 1;"} {
+		if _, err := Convert(src); err == nil {
+			t.Errorf("%q: expected error", src)
+		} else if !strings.Contains(err.Error(), "nclosed") {
+			t.Errorf("%q: unexpected error %v", src, err)
+		}
+	}
+}
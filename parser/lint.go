@@ -0,0 +1,195 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/codingersid/legit-template/lexer"
+)
+
+// LintIssue describes a problem found in a template
+type LintIssue struct {
+	Message  string
+	Position lexer.Position
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s at line %d, column %d", i.Message, i.Position.Line, i.Position.Column)
+}
+
+// blockEnds maps block directives to the directives that close them
+var blockEnds = map[string][]string{
+	"if":         {"endif"},
+	"unless":     {"endunless"},
+	"switch":     {"endswitch"},
+	"for":        {"endfor"},
+	"foreach":    {"endforeach"},
+	"forelse":    {"endforelse"},
+	"while":      {"endwhile"},
+	"section":    {"endsection", "show", "stop", "append", "overwrite"},
+	"push":       {"endpush"},
+	"pushOnce":   {"endPushOnce"},
+	"pushIf":     {"endPushIf"},
+	"prepend":    {"endprepend"},
+	"component":  {"endcomponent"},
+	"stack":      {"endstack"},
+	"slot":       {"endslot"},
+	"php":        {"endphp"},
+	"isset":      {"endisset"},
+	"empty":      {"endempty"},
+	"auth":       {"endauth"},
+	"guest":      {"endguest"},
+	"allow":      {"endallow"},
+	"feature":    {"endfeature"},
+	"env":        {"endenv"},
+	"production": {"endproduction"},
+	"error":      {"enderror"},
+	"flash":      {"endflash"},
+	"once":       {"endonce"},
+	"spaceless":  {"endspaceless"},
+	"cache":      {"endcache"},
+}
+
+// optionalBlocks lists blocks whose closing directive is optional,
+// e.g. a @component without a body
+var optionalBlocks = map[string]bool{
+	"component": true,
+	"stack":     true,
+}
+
+// blockBranches maps intermediate directives to the blocks they may appear in
+var blockBranches = map[string][]string{
+	"else":        {"if", "unless", "isset", "empty", "auth", "guest", "env", "production", "error", "feature"},
+	"elseif":      {"if"},
+	"featureelse": {"feature"},
+	"case":        {"switch"},
+	"default":     {"switch"},
+	"empty":       {"forelse"},
+}
+
+// simpleDirectives lists built-in directives that take no body
+var simpleDirectives = map[string]bool{
+	"extends": true, "yield": true, "parent": true,
+	"include": true, "includeIf": true, "includeWhen": true, "includeUnless": true, "includeFirst": true,
+	"each": true, "aware": true, "props": true, "break": true, "continue": true,
+	"csrf": true, "method": true, "json": true, "class": true, "style": true,
+	"checked": true, "selected": true, "disabled": true, "readonly": true, "required": true, "old": true,
+	"status": true, "header": true, "dump": true,
+}
+
+// Lint reports unclosed blocks, unexpected end directives, branches such
+// as @else outside their block and unknown directives. isFunction reports
+// whether an unknown directive name is a template function, which the
+// compiler calls instead.
+func (p *Parser) Lint(isFunction func(name string) bool) []LintIssue {
+	var issues []LintIssue
+	var stack []openBlock
+
+	report := func(pos lexer.Position, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Message: fmt.Sprintf(format, args...), Position: pos})
+	}
+
+	for _, tok := range p.tokens {
+		if tok.Type != lexer.TOKEN_DIRECTIVE && tok.Type != lexer.TOKEN_DIRECTIVE_ARGS {
+			continue
+		}
+		name := tok.Value
+
+		// Branches inside their block (@empty is a branch only without arguments)
+		if parents, ok := blockBranches[name]; ok && !(name == "empty" && tok.Args != "") {
+			if len(stack) == 0 || !contains(parents, stack[len(stack)-1].name) {
+				report(tok.Position, "@%s outside of @%s", name, parents[0])
+			}
+			continue
+		}
+
+		// Openings
+		if ends, ok := p.blockEndsFor(name, tok.Args); ok {
+			stack = append(stack, openBlock{
+				name:     name,
+				ends:     ends,
+				position: tok.Position,
+				optional: optionalBlocks[name],
+			})
+			continue
+		}
+
+		// Closings
+		if p.isEndDirective(name) {
+			for len(stack) > 0 && stack[len(stack)-1].optional && !contains(stack[len(stack)-1].ends, name) {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 {
+				report(tok.Position, "unexpected @%s without an open block", name)
+				continue
+			}
+			top := stack[len(stack)-1]
+			if !contains(top.ends, name) {
+				report(tok.Position, "unexpected @%s, expected @%s to close @%s opened at line %d, column %d",
+					name, top.ends[0], top.name, top.position.Line, top.position.Column)
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		// Inline forms of block directives such as @php($x = 1) are known too
+		_, inline := blockEnds[name]
+		if !inline && !simpleDirectives[name] && !p.directives[name] && (isFunction == nil || !isFunction(name)) {
+			report(tok.Position, "unknown directive @%s", name)
+		}
+	}
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		if !stack[i].optional {
+			report(stack[i].position, "unclosed @%s, expected @%s", stack[i].name, stack[i].ends[0])
+		}
+	}
+
+	return issues
+}
+
+// blockEndsFor returns the closing directives if name opens a block.
+// Registered custom block directives take precedence over built-ins.
+func (p *Parser) blockEndsFor(name, args string) ([]string, bool) {
+	if p.blockDirectives[name] {
+		return []string{"end" + name}, true
+	}
+
+	switch {
+	case name == "section" && len(splitArgs(args)) >= 2:
+		return nil, false // inline @section('name', 'content')
+	case name == "php" && args != "":
+		return nil, false // inline @php($x = 1)
+	case name == "empty" && args == "":
+		return nil, false // @empty branch of @forelse
+	}
+
+	if ends, ok := blockEnds[name]; ok {
+		return ends, true
+	}
+	return nil, false
+}
+
+// isEndDirective checks if name closes a built-in or custom block
+func (p *Parser) isEndDirective(name string) bool {
+	for _, ends := range blockEnds {
+		if contains(ends, name) {
+			return true
+		}
+	}
+	for block := range p.blockDirectives {
+		if name == "end"+block {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
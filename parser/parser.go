@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/codingersid/legit-template/lexer"
@@ -51,6 +52,14 @@ const (
 	NODE_ERROR
 	NODE_ONCE
 	NODE_PARENT
+	NODE_BLOCK
+	NODE_SPACELESS
+	NODE_AWARE
+	NODE_CACHE
+	NODE_ALLOW
+	NODE_FEATURE
+	NODE_PROPS
+	NODE_FLASH
 )
 
 // Node represents an AST node
@@ -77,7 +86,8 @@ type RootNode struct {
 // TextNode represents plain text
 type TextNode struct {
 	BaseNode
-	Content string
+	Content    string
+	Whitespace bool // only whitespace, as flagged by the lexer
 }
 
 // EchoNode represents {{ }} or {!! !!}
@@ -91,6 +101,7 @@ type EchoNode struct {
 type CommentNode struct {
 	BaseNode
 	Content string
+	Keep    bool // {{--! --}} comments are always rendered as HTML comments
 }
 
 // DirectiveNode represents a simple directive without block
@@ -149,7 +160,9 @@ type SwitchNode struct {
 type CaseNode struct {
 	BaseNode
 	Value    string
+	Values   []string // all values, when stacked @case directives share a body
 	Children []Node
+	Break    bool // ended by @break; otherwise it falls through to the next case
 }
 
 // DefaultNode represents @default in switch
@@ -200,6 +213,7 @@ type SectionNode struct {
 	Content  string   // For inline @section('name', 'content')
 	Children []Node
 	Show     bool     // If @show is used instead of @endsection
+	Merge    string   // "append" or "overwrite" when closed with @append or @overwrite
 }
 
 // YieldNode represents @yield
@@ -209,10 +223,11 @@ type YieldNode struct {
 	Default string
 }
 
-// ExtendsNode represents @extends
+// ExtendsNode represents @extends('layout') or @extends('layout', [...])
 type ExtendsNode struct {
 	BaseNode
 	Template string
+	Data     string // Optional data passed to the layout
 }
 
 // IncludeNode represents @include, @includeIf, @includeWhen, @includeUnless, @includeFirst
@@ -236,9 +251,10 @@ type EachNode struct {
 // PushNode represents @push...@endpush
 type PushNode struct {
 	BaseNode
-	Stack    string
-	Children []Node
-	Once     bool // For @pushOnce
+	Stack     string
+	Children  []Node
+	Once      bool   // For @pushOnce
+	Condition string // For @pushIf
 }
 
 // PrependNode represents @prepend...@endprepend
@@ -251,7 +267,8 @@ type PrependNode struct {
 // StackNode represents @stack
 type StackNode struct {
 	BaseNode
-	Name string
+	Name    string
+	Default []Node // Rendered when nothing was pushed, for @stack...@endstack
 }
 
 // ComponentNode represents @component...@endcomponent
@@ -266,8 +283,27 @@ type ComponentNode struct {
 // SlotNode represents @slot...@endslot
 type SlotNode struct {
 	BaseNode
-	Name     string
-	Children []Node
+	Name       string
+	Attributes string
+	Children   []Node
+}
+
+// AwareNode represents @aware(['name', 'other' => default])
+type AwareNode struct {
+	BaseNode
+	Props []PropNode
+}
+
+// PropsNode represents @props(['name', 'other' => default])
+type PropsNode struct {
+	BaseNode
+	Props []PropNode
+}
+
+// PropNode is a component property name with an optional default expression
+type PropNode struct {
+	Name    string
+	Default string
 }
 
 // VerbatimNode represents @verbatim...@endverbatim
@@ -276,10 +312,11 @@ type VerbatimNode struct {
 	Content string
 }
 
-// PhpNode represents @php...@endphp
+// PhpNode represents @php...@endphp or the inline @php(...) form
 type PhpNode struct {
 	BaseNode
-	Code string
+	Code   string
+	Inline bool
 }
 
 // BreakNode represents @break
@@ -315,6 +352,21 @@ type AuthNode struct {
 	Children []Node
 }
 
+// AllowNode represents @allow('permission')...@endallow
+type AllowNode struct {
+	BaseNode
+	Permission string // Permission expression
+	Children   []Node
+}
+
+// FeatureNode represents @feature('flag')...@else...@endfeature
+type FeatureNode struct {
+	BaseNode
+	Flag     string // Flag expression
+	Children []Node
+	Else     *ElseNode // @else or @featureelse branch, rendered when the flag is off
+}
+
 // GuestNode represents @guest...@endguest
 type GuestNode struct {
 	BaseNode
@@ -338,7 +390,15 @@ type ProductionNode struct {
 // ErrorNode represents @error...@enderror
 type ErrorNode struct {
 	BaseNode
-	Field    string
+	Field    string   // First field, kept for single-field usage
+	Fields   []string // All fields checked by the block
+	Children []Node
+}
+
+// FlashNode represents @flash('key')...@endflash
+type FlashNode struct {
+	BaseNode
+	Key      string
 	Children []Node
 }
 
@@ -348,6 +408,20 @@ type OnceNode struct {
 	Children []Node
 }
 
+// SpacelessNode represents @spaceless...@endspaceless
+type SpacelessNode struct {
+	BaseNode
+	Children []Node
+}
+
+// CacheNode represents @cache('key', ttl)...@endcache
+type CacheNode struct {
+	BaseNode
+	Key      string // Key expression
+	TTL      string // TTL expression, in seconds or as a duration string
+	Children []Node
+}
+
 // ParentNode represents @parent
 type ParentNode struct {
 	BaseNode
@@ -358,13 +432,37 @@ type Parser struct {
 	tokens  []lexer.Token
 	pos     int
 	current lexer.Token
+
+	// Custom directives registered by the engine
+	directives      map[string]bool
+	blockDirectives map[string]bool
+
+	// Reject directives that are neither built-in nor registered
+	strict bool
+
+	// Blocks currently being parsed, innermost last
+	blocks []openBlock
+
+	// Record recoverable errors instead of stopping at the first one
+	collect bool
+	errors  []error
+}
+
+// openBlock is a block directive waiting for its end directive
+type openBlock struct {
+	name     string
+	ends     []string
+	position lexer.Position
+	optional bool // may legitimately be left open (self-closing @component)
 }
 
 // New creates a new Parser
 func New(tokens []lexer.Token) *Parser {
 	p := &Parser{
-		tokens: tokens,
-		pos:    0,
+		tokens:          tokens,
+		pos:             0,
+		directives:      make(map[string]bool),
+		blockDirectives: make(map[string]bool),
 	}
 	if len(tokens) > 0 {
 		p.current = tokens[0]
@@ -372,6 +470,23 @@ func New(tokens []lexer.Token) *Parser {
 	return p
 }
 
+// RegisterDirective registers a custom inline directive name
+func (p *Parser) RegisterDirective(name string) {
+	p.directives[name] = true
+}
+
+// SetStrict enables or disables strict mode, in which unknown
+// directives produce a ParserError instead of a function call
+func (p *Parser) SetStrict(strict bool) {
+	p.strict = strict
+}
+
+// RegisterBlockDirective registers a custom directive whose children
+// are collected until the matching @end<name>
+func (p *Parser) RegisterBlockDirective(name string) {
+	p.blockDirectives[name] = true
+}
+
 // Parse parses tokens into AST
 func (p *Parser) Parse() (*RootNode, error) {
 	root := &RootNode{
@@ -392,6 +507,44 @@ func (p *Parser) Parse() (*RootNode, error) {
 	return root, nil
 }
 
+// ParseCollect parses the tokens like Parse but keeps going after
+// structural errors such as unclosed blocks, mismatched end directives and
+// unknown directives in strict mode, returning all of them in template
+// order. Unclosed blocks are closed at the end of the template.
+func (p *Parser) ParseCollect() (*RootNode, []error) {
+	p.collect = true
+	p.errors = nil
+	defer func() { p.collect = false }()
+
+	root, err := p.Parse()
+	if err != nil {
+		p.errors = append(p.errors, err)
+	}
+
+	sort.SliceStable(p.errors, func(i, j int) bool {
+		a, aok := p.errors[i].(*ParserError)
+		b, bok := p.errors[j].(*ParserError)
+		if !aok || !bok {
+			return false
+		}
+		if a.Position.Line != b.Position.Line {
+			return a.Position.Line < b.Position.Line
+		}
+		return a.Position.Column < b.Position.Column
+	})
+
+	return root, p.errors
+}
+
+// report records err and reports success when collecting errors
+func (p *Parser) report(err error) error {
+	if p.collect {
+		p.errors = append(p.errors, err)
+		return nil
+	}
+	return err
+}
+
 // parseNode parses a single node
 func (p *Parser) parseNode() (Node, error) {
 	token := p.current
@@ -400,8 +553,9 @@ func (p *Parser) parseNode() (Node, error) {
 	case lexer.TOKEN_TEXT:
 		p.advance()
 		return &TextNode{
-			BaseNode: BaseNode{NodeType: NODE_TEXT, Pos: token.Position},
-			Content:  token.Value,
+			BaseNode:   BaseNode{NodeType: NODE_TEXT, Pos: token.Position},
+			Content:    token.Value,
+			Whitespace: token.Whitespace,
 		}, nil
 
 	case lexer.TOKEN_ECHO_ESCAPED:
@@ -422,9 +576,14 @@ func (p *Parser) parseNode() (Node, error) {
 
 	case lexer.TOKEN_COMMENT:
 		p.advance()
+		content, keep := token.Value, strings.HasPrefix(token.Value, "!")
+		if keep {
+			content = strings.TrimSpace(content[1:])
+		}
 		return &CommentNode{
 			BaseNode: BaseNode{NodeType: NODE_COMMENT, Pos: token.Position},
-			Content:  token.Value,
+			Content:  content,
+			Keep:     keep,
 		}, nil
 
 	case lexer.TOKEN_DIRECTIVE, lexer.TOKEN_DIRECTIVE_ARGS:
@@ -449,6 +608,27 @@ func (p *Parser) parseDirective() (Node, error) {
 	args := token.Args
 	p.advance()
 
+	if p.isEndDirective(name) {
+		return nil, p.report(p.unexpectedEnd(token))
+	}
+
+	if ends, ok := p.blockEndsFor(name, args); ok && (!optionalBlocks[name] || p.hasClosing(name, ends[0])) {
+		p.blocks = append(p.blocks, openBlock{name: name, ends: ends, position: token.Position})
+		defer func() { p.blocks = p.blocks[:len(p.blocks)-1] }()
+	}
+
+	// Registered custom directives take precedence over built-ins
+	if p.blockDirectives[name] {
+		return p.parseBlock(token.Position, name, args)
+	}
+	if p.directives[name] {
+		return &DirectiveNode{
+			BaseNode: BaseNode{NodeType: NODE_DIRECTIVE, Pos: token.Position},
+			Name:     name,
+			Args:     args,
+		}, nil
+	}
+
 	switch name {
 	case "if":
 		return p.parseIf(token.Position, args)
@@ -469,10 +649,15 @@ func (p *Parser) parseDirective() (Node, error) {
 	case "yield":
 		return p.parseYield(token.Position, args)
 	case "extends":
-		return &ExtendsNode{
+		node := &ExtendsNode{
 			BaseNode: BaseNode{NodeType: NODE_EXTENDS, Pos: token.Position},
 			Template: trimQuotes(args),
-		}, nil
+		}
+		if parts := splitArgs(args); len(parts) > 1 {
+			node.Template = trimQuotes(parts[0])
+			node.Data = parts[1]
+		}
+		return node, nil
 	case "include", "includeIf", "includeWhen", "includeUnless", "includeFirst":
 		return p.parseInclude(token.Position, name, args)
 	case "each":
@@ -481,16 +666,32 @@ func (p *Parser) parseDirective() (Node, error) {
 		return p.parsePush(token.Position, args, false)
 	case "pushOnce":
 		return p.parsePush(token.Position, args, true)
+	case "pushIf":
+		return p.parsePushIf(token.Position, args)
 	case "prepend":
 		return p.parsePrepend(token.Position, args)
 	case "stack":
-		return &StackNode{
-			BaseNode: BaseNode{NodeType: NODE_STACK, Pos: token.Position},
-			Name:     trimQuotes(args),
-		}, nil
+		return p.parseStack(token.Position, args)
 	case "component":
 		return p.parseComponent(token.Position, args)
+	case "aware":
+		return &AwareNode{
+			BaseNode: BaseNode{NodeType: NODE_AWARE, Pos: token.Position},
+			Props:    parseProps(args),
+		}, nil
+	case "props":
+		return &PropsNode{
+			BaseNode: BaseNode{NodeType: NODE_PROPS, Pos: token.Position},
+			Props:    parseProps(args),
+		}, nil
 	case "php":
+		if token.Args != "" {
+			return &PhpNode{
+				BaseNode: BaseNode{NodeType: NODE_PHP, Pos: token.Position},
+				Code:     strings.TrimSpace(token.Args),
+				Inline:   true,
+			}, nil
+		}
 		return p.parsePhp(token.Position)
 	case "isset":
 		return p.parseIsset(token.Position, args)
@@ -500,14 +701,24 @@ func (p *Parser) parseDirective() (Node, error) {
 		return p.parseAuth(token.Position, args)
 	case "guest":
 		return p.parseGuest(token.Position, args)
+	case "allow":
+		return p.parseAllow(token.Position, args)
+	case "feature":
+		return p.parseFeature(token.Position, args)
 	case "env":
 		return p.parseEnv(token.Position, args)
 	case "production":
 		return p.parseProduction(token.Position)
 	case "error":
 		return p.parseError(token.Position, args)
+	case "flash":
+		return p.parseFlash(token.Position, args)
 	case "once":
 		return p.parseOnce(token.Position)
+	case "spaceless":
+		return p.parseSpaceless(token.Position)
+	case "cache":
+		return p.parseCache(token.Position, args)
 	case "break":
 		return &BreakNode{
 			BaseNode:  BaseNode{NodeType: NODE_BREAK, Pos: token.Position},
@@ -522,13 +733,21 @@ func (p *Parser) parseDirective() (Node, error) {
 		return &ParentNode{
 			BaseNode: BaseNode{NodeType: NODE_PARENT, Pos: token.Position},
 		}, nil
-	case "csrf", "method", "json", "class", "style", "checked", "selected", "disabled", "readonly", "required", "old":
+	case "csrf", "method", "json", "class", "style", "checked", "selected", "disabled", "readonly", "required", "old",
+		"status", "header", "dump":
 		return &DirectiveNode{
 			BaseNode: BaseNode{NodeType: NODE_DIRECTIVE, Pos: token.Position},
 			Name:     name,
 			Args:     args,
 		}, nil
 	default:
+		if p.strict {
+			return nil, p.report(&ParserError{
+				Message:  fmt.Sprintf("unknown directive @%s", name),
+				Position: token.Position,
+			})
+		}
+
 		// Unknown directive - treat as simple directive
 		return &DirectiveNode{
 			BaseNode: BaseNode{NodeType: NODE_DIRECTIVE, Pos: token.Position},
@@ -591,7 +810,6 @@ func (p *Parser) parseIf(pos lexer.Position, condition string) (*IfNode, error)
 		}
 
 		if p.isDirective("endif") {
-			p.advance()
 			break
 		}
 
@@ -607,6 +825,10 @@ func (p *Parser) parseIf(pos lexer.Position, condition string) (*IfNode, error)
 		}
 	}
 
+	if err := p.expectEnd(pos, "endif"); err != nil {
+		return nil, err
+	}
+
 	return node, nil
 }
 
@@ -628,8 +850,8 @@ func (p *Parser) parseUnless(pos lexer.Position, condition string) (*UnlessNode,
 		}
 	}
 
-	if p.isDirective("endunless") {
-		p.advance()
+	if err := p.expectEnd(pos, "endunless"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -647,14 +869,23 @@ func (p *Parser) parseSwitch(pos lexer.Position, expression string) (*SwitchNode
 
 	for !p.isAtEnd() && !p.isDirective("endswitch") {
 		if p.isDirective("case") {
+			caseToken := p.current
+			p.advance()
+
+			// @case(1) @case(2) ... stacked without a body are alternatives
+			if currentCase != nil && !currentCase.Break && node.Default == nil && isBlank(currentCase.Children) {
+				currentCase.Values = append(currentCase.Values, caseToken.Args)
+				currentCase.Children = currentCase.Children[:0]
+				continue
+			}
+
 			if currentCase != nil {
 				node.Cases = append(node.Cases, currentCase)
 			}
-			caseToken := p.current
-			p.advance()
 			currentCase = &CaseNode{
 				BaseNode: BaseNode{NodeType: NODE_CASE, Pos: caseToken.Position},
 				Value:    caseToken.Args,
+				Values:   []string{caseToken.Args},
 				Children: make([]Node, 0),
 			}
 			continue
@@ -675,6 +906,9 @@ func (p *Parser) parseSwitch(pos lexer.Position, expression string) (*SwitchNode
 		}
 
 		if p.isDirective("break") {
+			if currentCase != nil && node.Default == nil {
+				currentCase.Break = true
+			}
 			p.advance()
 			continue
 		}
@@ -696,8 +930,8 @@ func (p *Parser) parseSwitch(pos lexer.Position, expression string) (*SwitchNode
 		node.Cases = append(node.Cases, currentCase)
 	}
 
-	if p.isDirective("endswitch") {
-		p.advance()
+	if err := p.expectEnd(pos, "endswitch"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -732,8 +966,8 @@ func (p *Parser) parseFor(pos lexer.Position, args string) (*ForNode, error) {
 		}
 	}
 
-	if p.isDirective("endfor") {
-		p.advance()
+	if err := p.expectEnd(pos, "endfor"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -759,8 +993,8 @@ func (p *Parser) parseForeach(pos lexer.Position, args string) (*ForeachNode, er
 		}
 	}
 
-	if p.isDirective("endforeach") {
-		p.advance()
+	if err := p.expectEnd(pos, "endforeach"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -831,8 +1065,8 @@ func (p *Parser) parseForelse(pos lexer.Position, args string) (*ForelseNode, er
 		}
 	}
 
-	if p.isDirective("endforelse") {
-		p.advance()
+	if err := p.expectEnd(pos, "endforelse"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -856,8 +1090,8 @@ func (p *Parser) parseWhile(pos lexer.Position, condition string) (*WhileNode, e
 		}
 	}
 
-	if p.isDirective("endwhile") {
-		p.advance()
+	if err := p.expectEnd(pos, "endwhile"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -881,8 +1115,9 @@ func (p *Parser) parseSection(pos lexer.Position, args string) (*SectionNode, er
 		return node, nil
 	}
 
-	// Block section
-	for !p.isAtEnd() && !p.isDirective("endsection") && !p.isDirective("show") {
+	// Block section, closed by @endsection or its alias @stop, @show,
+	// @append or @overwrite
+	for !p.isAtEnd() && !p.isSectionEnd() {
 		child, err := p.parseNode()
 		if err != nil {
 			return nil, err
@@ -892,16 +1127,34 @@ func (p *Parser) parseSection(pos lexer.Position, args string) (*SectionNode, er
 		}
 	}
 
-	if p.isDirective("show") {
+	switch {
+	case p.isDirective("show"):
 		p.advance()
 		node.Show = true
-	} else if p.isDirective("endsection") {
+	case p.isDirective("append"), p.isDirective("overwrite"):
+		node.Merge = p.current.Value
+		p.advance()
+	case p.isDirective("stop"):
 		p.advance()
+	default:
+		if err := p.expectEnd(pos, "endsection"); err != nil {
+			return nil, err
+		}
 	}
 
 	return node, nil
 }
 
+// isSectionEnd checks if the current token closes a block section
+func (p *Parser) isSectionEnd() bool {
+	for _, end := range []string{"endsection", "show", "stop", "append", "overwrite"} {
+		if p.isDirective(end) {
+			return true
+		}
+	}
+	return false
+}
+
 // parseYield parses @yield
 func (p *Parser) parseYield(pos lexer.Position, args string) (*YieldNode, error) {
 	node := &YieldNode{
@@ -1004,8 +1257,69 @@ func (p *Parser) parsePush(pos lexer.Position, args string, once bool) (*PushNod
 		}
 	}
 
-	if p.isDirective(endDirective) {
-		p.advance()
+	if err := p.expectEnd(pos, endDirective); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parsePushIf parses @pushIf(condition, 'stack')...@endPushIf
+func (p *Parser) parsePushIf(pos lexer.Position, args string) (*PushNode, error) {
+	node := &PushNode{
+		BaseNode:  BaseNode{NodeType: NODE_PUSH, Pos: pos},
+		Children:  make([]Node, 0),
+		Condition: args,
+	}
+
+	// A missing stack name is reported by the compiler
+	if parts := splitArgs(args); len(parts) == 2 {
+		node.Condition = parts[0]
+		node.Stack = trimQuotes(parts[1])
+	}
+
+	for !p.isAtEnd() && !p.isDirective("endPushIf") {
+		child, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	if err := p.expectEnd(pos, "endPushIf"); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parseStack parses @stack or @stack...@endstack with default content
+func (p *Parser) parseStack(pos lexer.Position, args string) (*StackNode, error) {
+	node := &StackNode{
+		BaseNode: BaseNode{NodeType: NODE_STACK, Pos: pos},
+		Name:     trimQuotes(args),
+	}
+
+	// Without a matching @endstack the stack has no default
+	if !p.hasClosing("stack", "endstack") {
+		return node, nil
+	}
+
+	node.Default = make([]Node, 0)
+	for !p.isAtEnd() && !p.isDirective("endstack") {
+		child, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			node.Default = append(node.Default, child)
+		}
+	}
+
+	if err := p.expectEnd(pos, "endstack"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1029,8 +1343,8 @@ func (p *Parser) parsePrepend(pos lexer.Position, args string) (*PrependNode, er
 		}
 	}
 
-	if p.isDirective("endprepend") {
-		p.advance()
+	if err := p.expectEnd(pos, "endprepend"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1052,6 +1366,11 @@ func (p *Parser) parseComponent(pos lexer.Position, args string) (*ComponentNode
 		node.Data = parts[1]
 	}
 
+	// Without a matching @endcomponent the component has no body
+	if !p.hasClosing("component", "endcomponent") {
+		return node, nil
+	}
+
 	var currentSlot *SlotNode
 
 	for !p.isAtEnd() && !p.isDirective("endcomponent") {
@@ -1063,9 +1382,15 @@ func (p *Parser) parseComponent(pos lexer.Position, args string) (*ComponentNode
 			p.advance()
 			currentSlot = &SlotNode{
 				BaseNode: BaseNode{NodeType: NODE_SLOT, Pos: slotToken.Position},
-				Name:     trimQuotes(slotToken.Args),
 				Children: make([]Node, 0),
 			}
+			slotArgs := splitArgs(slotToken.Args)
+			if len(slotArgs) >= 1 {
+				currentSlot.Name = trimQuotes(slotArgs[0])
+			}
+			if len(slotArgs) >= 2 {
+				currentSlot.Attributes = slotArgs[1]
+			}
 			continue
 		}
 
@@ -1095,13 +1420,32 @@ func (p *Parser) parseComponent(pos lexer.Position, args string) (*ComponentNode
 		node.Slots[currentSlot.Name] = currentSlot
 	}
 
-	if p.isDirective("endcomponent") {
-		p.advance()
+	if err := p.expectEnd(pos, "endcomponent"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
 }
 
+// parseProps parses a property list like ['color', 'size' => 'md']
+func parseProps(args string) []PropNode {
+	args = strings.TrimSpace(args)
+	args = strings.TrimSuffix(strings.TrimPrefix(args, "["), "]")
+
+	var props []PropNode
+	for _, item := range splitArgs(args) {
+		prop := PropNode{Name: trimQuotes(item)}
+		if idx := strings.Index(item, "=>"); idx != -1 {
+			prop.Name = trimQuotes(item[:idx])
+			prop.Default = strings.TrimSpace(item[idx+2:])
+		}
+		if prop.Name != "" {
+			props = append(props, prop)
+		}
+	}
+	return props
+}
+
 // parseVerbatim parses @verbatim...@endverbatim
 func (p *Parser) parseVerbatim() (*VerbatimNode, error) {
 	pos := p.current.Position
@@ -1137,8 +1481,8 @@ func (p *Parser) parsePhp(pos lexer.Position) (*PhpNode, error) {
 		p.advance()
 	}
 
-	if p.isDirective("endphp") {
-		p.advance()
+	if err := p.expectEnd(pos, "endphp"); err != nil {
+		return nil, err
 	}
 
 	return &PhpNode{
@@ -1165,8 +1509,8 @@ func (p *Parser) parseIsset(pos lexer.Position, variable string) (*IssetNode, er
 		}
 	}
 
-	if p.isDirective("endisset") {
-		p.advance()
+	if err := p.expectEnd(pos, "endisset"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1190,8 +1534,8 @@ func (p *Parser) parseEmptyCheck(pos lexer.Position, variable string) (*EmptyChe
 		}
 	}
 
-	if p.isDirective("endempty") {
-		p.advance()
+	if err := p.expectEnd(pos, "endempty"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1215,8 +1559,72 @@ func (p *Parser) parseAuth(pos lexer.Position, guard string) (*AuthNode, error)
 		}
 	}
 
-	if p.isDirective("endauth") {
-		p.advance()
+	if err := p.expectEnd(pos, "endauth"); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parseAllow parses @allow('permission')...@endallow
+func (p *Parser) parseAllow(pos lexer.Position, permission string) (*AllowNode, error) {
+	node := &AllowNode{
+		BaseNode:   BaseNode{NodeType: NODE_ALLOW, Pos: pos},
+		Permission: permission,
+		Children:   make([]Node, 0),
+	}
+
+	for !p.isAtEnd() && !p.isDirective("endallow") {
+		child, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	if err := p.expectEnd(pos, "endallow"); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parseFeature parses @feature('flag')...@else...@endfeature. The off
+// branch may also be started with @featureelse.
+func (p *Parser) parseFeature(pos lexer.Position, flag string) (*FeatureNode, error) {
+	node := &FeatureNode{
+		BaseNode: BaseNode{NodeType: NODE_FEATURE, Pos: pos},
+		Flag:     flag,
+		Children: make([]Node, 0),
+	}
+
+	for !p.isAtEnd() && !p.isDirective("endfeature") {
+		if node.Else == nil && (p.isDirective("else") || p.isDirective("featureelse")) {
+			node.Else = &ElseNode{
+				BaseNode: BaseNode{NodeType: NODE_ELSE, Pos: p.current.Position},
+				Children: make([]Node, 0),
+			}
+			p.advance()
+			continue
+		}
+
+		child, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			if node.Else != nil {
+				node.Else.Children = append(node.Else.Children, child)
+			} else {
+				node.Children = append(node.Children, child)
+			}
+		}
+	}
+
+	if err := p.expectEnd(pos, "endfeature"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1240,8 +1648,8 @@ func (p *Parser) parseGuest(pos lexer.Position, guard string) (*GuestNode, error
 		}
 	}
 
-	if p.isDirective("endguest") {
-		p.advance()
+	if err := p.expectEnd(pos, "endguest"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1265,8 +1673,8 @@ func (p *Parser) parseEnv(pos lexer.Position, args string) (*EnvNode, error) {
 		}
 	}
 
-	if p.isDirective("endenv") {
-		p.advance()
+	if err := p.expectEnd(pos, "endenv"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1289,20 +1697,23 @@ func (p *Parser) parseProduction(pos lexer.Position) (*ProductionNode, error) {
 		}
 	}
 
-	if p.isDirective("endproduction") {
-		p.advance()
+	if err := p.expectEnd(pos, "endproduction"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
 }
 
 // parseError parses @error...@enderror
-func (p *Parser) parseError(pos lexer.Position, field string) (*ErrorNode, error) {
+func (p *Parser) parseError(pos lexer.Position, args string) (*ErrorNode, error) {
 	node := &ErrorNode{
 		BaseNode: BaseNode{NodeType: NODE_ERROR, Pos: pos},
-		Field:    trimQuotes(field),
+		Fields:   parseFieldList(args),
 		Children: make([]Node, 0),
 	}
+	if len(node.Fields) > 0 {
+		node.Field = node.Fields[0]
+	}
 
 	for !p.isAtEnd() && !p.isDirective("enderror") {
 		child, err := p.parseNode()
@@ -1314,8 +1725,33 @@ func (p *Parser) parseError(pos lexer.Position, field string) (*ErrorNode, error
 		}
 	}
 
-	if p.isDirective("enderror") {
-		p.advance()
+	if err := p.expectEnd(pos, "enderror"); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parseFlash parses @flash...@endflash
+func (p *Parser) parseFlash(pos lexer.Position, args string) (*FlashNode, error) {
+	node := &FlashNode{
+		BaseNode: BaseNode{NodeType: NODE_FLASH, Pos: pos},
+		Key:      trimQuotes(strings.TrimSpace(args)),
+		Children: make([]Node, 0),
+	}
+
+	for !p.isAtEnd() && !p.isDirective("endflash") {
+		child, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	if err := p.expectEnd(pos, "endflash"); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1338,8 +1774,92 @@ func (p *Parser) parseOnce(pos lexer.Position) (*OnceNode, error) {
 		}
 	}
 
-	if p.isDirective("endonce") {
-		p.advance()
+	if err := p.expectEnd(pos, "endonce"); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parseCache parses @cache('key', ttl)...@endcache
+func (p *Parser) parseCache(pos lexer.Position, args string) (*CacheNode, error) {
+	node := &CacheNode{
+		BaseNode: BaseNode{NodeType: NODE_CACHE, Pos: pos},
+		Children: make([]Node, 0),
+	}
+
+	parts := splitArgs(args)
+	if len(parts) >= 1 {
+		node.Key = parts[0]
+	}
+	if len(parts) >= 2 {
+		node.TTL = parts[1]
+	}
+
+	for !p.isAtEnd() && !p.isDirective("endcache") {
+		child, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	if err := p.expectEnd(pos, "endcache"); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parseSpaceless parses @spaceless...@endspaceless
+func (p *Parser) parseSpaceless(pos lexer.Position) (*SpacelessNode, error) {
+	node := &SpacelessNode{
+		BaseNode: BaseNode{NodeType: NODE_SPACELESS, Pos: pos},
+		Children: make([]Node, 0),
+	}
+
+	for !p.isAtEnd() && !p.isDirective("endspaceless") {
+		child, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	if err := p.expectEnd(pos, "endspaceless"); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parseBlock parses a custom block directive @name...@endname
+func (p *Parser) parseBlock(pos lexer.Position, name, args string) (*BlockNode, error) {
+	node := &BlockNode{
+		BaseNode: BaseNode{NodeType: NODE_BLOCK, Pos: pos},
+		Name:     name,
+		Args:     args,
+		Children: make([]Node, 0),
+	}
+
+	endDirective := "end" + name
+
+	for !p.isAtEnd() && !p.isDirective(endDirective) {
+		child, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	if err := p.expectEnd(pos, endDirective); err != nil {
+		return nil, err
 	}
 
 	return node, nil
@@ -1358,6 +1878,71 @@ func (p *Parser) isAtEnd() bool {
 	return p.pos >= len(p.tokens) || p.current.Type == lexer.TOKEN_EOF
 }
 
+// unexpectedEnd reports an end directive that does not close the innermost open block
+func (p *Parser) unexpectedEnd(token lexer.Token) error {
+	if len(p.blocks) == 0 {
+		return &ParserError{
+			Message:  fmt.Sprintf("unexpected @%s without an open block", token.Value),
+			Position: token.Position,
+		}
+	}
+
+	top := p.blocks[len(p.blocks)-1]
+	return &ParserError{
+		Message: fmt.Sprintf("unexpected @%s, expected @%s to close @%s opened at line %d, column %d",
+			token.Value, top.ends[0], top.name, top.position.Line, top.position.Column),
+		Position: token.Position,
+	}
+}
+
+// isBlank checks if nodes contain nothing but whitespace
+func isBlank(nodes []Node) bool {
+	for _, node := range nodes {
+		text, ok := node.(*TextNode)
+		if !ok || strings.TrimSpace(text.Content) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// expectEnd consumes the directive closing a block, reporting the opening
+// directive's position when the template ends first
+func (p *Parser) expectEnd(pos lexer.Position, end string) error {
+	if !p.isDirective(end) {
+		open := strings.TrimPrefix(end, "end")
+		open = strings.ToLower(open[:1]) + open[1:]
+		return p.report(&ParserError{
+			Message:  fmt.Sprintf("unclosed @%s, expected @%s", open, end),
+			Position: pos,
+		})
+	}
+	p.advance()
+	return nil
+}
+
+// hasClosing checks if a closing directive matching an already consumed
+// opening directive follows, accounting for nested openings
+func (p *Parser) hasClosing(open, close string) bool {
+	depth := 0
+	for i := p.pos; i < len(p.tokens); i++ {
+		tok := p.tokens[i]
+		if tok.Type != lexer.TOKEN_DIRECTIVE && tok.Type != lexer.TOKEN_DIRECTIVE_ARGS {
+			continue
+		}
+		switch tok.Value {
+		case open:
+			depth++
+		case close:
+			if depth == 0 {
+				return true
+			}
+			depth--
+		}
+	}
+	return false
+}
+
 func (p *Parser) isDirective(name string) bool {
 	return (p.current.Type == lexer.TOKEN_DIRECTIVE || p.current.Type == lexer.TOKEN_DIRECTIVE_ARGS) && p.current.Value == name
 }
@@ -1373,7 +1958,13 @@ func trimQuotes(s string) string {
 	return s
 }
 
-// splitArgs splits comma-separated arguments respecting strings and brackets
+// SplitArgs splits comma-separated arguments respecting strings and brackets
+func SplitArgs(args string) []string {
+	return splitArgs(args)
+}
+
+// splitArgs splits comma-separated arguments respecting strings, including
+// Go raw strings, and brackets
 func splitArgs(args string) []string {
 	var result []string
 	var current strings.Builder
@@ -1384,7 +1975,7 @@ func splitArgs(args string) []string {
 	for i := 0; i < len(args); i++ {
 		ch := args[i]
 
-		if (ch == '"' || ch == '\'') && (i == 0 || args[i-1] != '\\') {
+		if (ch == '"' || ch == '\'' || ch == '`') && (i == 0 || args[i-1] != '\\') {
 			if !inString {
 				inString = true
 				stringChar = ch
@@ -1433,6 +2024,23 @@ func parseEnvList(args string) []string {
 	return []string{trimQuotes(args)}
 }
 
+// parseFieldList parses field names from @error('a'), @error('a', 'b') or @error(['a', 'b'])
+func parseFieldList(args string) []string {
+	args = strings.TrimSpace(args)
+	if strings.HasPrefix(args, "[") && strings.HasSuffix(args, "]") {
+		args = args[1 : len(args)-1]
+	}
+
+	parts := splitArgs(args)
+	fields := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if field := trimQuotes(part); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
 // ParserError represents a parser error
 type ParserError struct {
 	Message  string
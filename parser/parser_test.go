@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/codingersid/legit-template/lexer"
@@ -225,6 +226,41 @@ func TestParser_Extends(t *testing.T) {
 	}
 }
 
+func TestParser_SectionTerminators(t *testing.T) {
+	tests := []struct {
+		input string
+		merge string
+	}{
+		{"@section('a')x@stop", ""},
+		{"@section('a')x@append", "append"},
+		{"@section('a')x@overwrite", "overwrite"},
+	}
+
+	for _, tt := range tests {
+		ast := parseTemplate(t, tt.input)
+		node, ok := ast.Children[0].(*SectionNode)
+		if !ok || len(ast.Children) != 1 {
+			t.Fatalf("%q: expected a single SectionNode", tt.input)
+		}
+		if node.Merge != tt.merge || len(node.Children) != 1 {
+			t.Errorf("%q: expected merge %q, got %q", tt.input, tt.merge, node.Merge)
+		}
+	}
+}
+
+func TestParser_ExtendsWithData(t *testing.T) {
+	ast := parseTemplate(t, "@extends('layouts.app', ['bodyClass' => 'home'])")
+
+	node, ok := ast.Children[0].(*ExtendsNode)
+	if !ok {
+		t.Fatal("expected ExtendsNode")
+	}
+
+	if node.Template != "layouts.app" || node.Data != "['bodyClass' => 'home']" {
+		t.Errorf("unexpected extends node: %q, %q", node.Template, node.Data)
+	}
+}
+
 func TestParser_Section(t *testing.T) {
 	ast := parseTemplate(t, "@section('content')Hello@endsection")
 
@@ -311,6 +347,59 @@ func TestParser_Push(t *testing.T) {
 	}
 }
 
+func TestParser_PushIf(t *testing.T) {
+	ast := parseTemplate(t, "@pushIf($needsChart, 'scripts')<script></script>@endPushIf")
+
+	node, ok := ast.Children[0].(*PushNode)
+	if !ok {
+		t.Fatal("expected PushNode")
+	}
+
+	if node.Stack != "scripts" || node.Condition != "$needsChart" {
+		t.Errorf("expected scripts if $needsChart, got %q if %q", node.Stack, node.Condition)
+	}
+	if len(node.Children) != 1 {
+		t.Errorf("expected 1 child, got %d", len(node.Children))
+	}
+}
+
+func TestParser_StackDefault(t *testing.T) {
+	ast := parseTemplate(t, "@stack('a')@stack('b')<i>none</i>@endstack")
+
+	plain, ok := ast.Children[0].(*StackNode)
+	if !ok || plain.Name != "a" || plain.Default != nil {
+		t.Fatalf("expected plain stack a, got %#v", ast.Children[0])
+	}
+
+	withDefault, ok := ast.Children[1].(*StackNode)
+	if !ok || withDefault.Name != "b" || len(withDefault.Default) != 1 {
+		t.Fatalf("expected stack b with default content, got %#v", ast.Children[1])
+	}
+}
+
+func TestParser_Allow(t *testing.T) {
+	ast := parseTemplate(t, "@allow('manage-users')<a>Users</a>@endallow")
+
+	node, ok := ast.Children[0].(*AllowNode)
+	if !ok || node.Permission != "'manage-users'" || len(node.Children) != 1 {
+		t.Fatalf("expected allow node, got %#v", ast.Children[0])
+	}
+}
+
+func TestParser_Feature(t *testing.T) {
+	for _, branch := range []string{"@else", "@featureelse"} {
+		ast := parseTemplate(t, "@feature('new-checkout')new"+branch+" old@endfeature")
+
+		node, ok := ast.Children[0].(*FeatureNode)
+		if !ok || node.Flag != "'new-checkout'" || len(node.Children) != 1 {
+			t.Fatalf("expected feature node, got %#v", ast.Children[0])
+		}
+		if node.Else == nil || len(node.Else.Children) != 1 {
+			t.Errorf("expected %s branch, got %#v", branch, node.Else)
+		}
+	}
+}
+
 func TestParser_Component(t *testing.T) {
 	ast := parseTemplate(t, "@component('alert')Message@slot('title')Title@endslot@endcomponent")
 
@@ -376,6 +465,32 @@ func TestParser_Error(t *testing.T) {
 	}
 }
 
+func TestParser_ErrorMultipleFields(t *testing.T) {
+	ast := parseTemplate(t, "@error(['email', 'password']){{ $message }}@enderror")
+
+	node, ok := ast.Children[0].(*ErrorNode)
+	if !ok {
+		t.Fatal("expected ErrorNode")
+	}
+
+	if len(node.Fields) != 2 || node.Fields[0] != "email" || node.Fields[1] != "password" {
+		t.Errorf("expected [email password], got %v", node.Fields)
+	}
+}
+
+func TestParser_Flash(t *testing.T) {
+	ast := parseTemplate(t, "@flash('success')<p>{{ $message }}</p>@endflash")
+
+	node, ok := ast.Children[0].(*FlashNode)
+	if !ok {
+		t.Fatal("expected FlashNode")
+	}
+
+	if node.Key != "success" || len(node.Children) != 3 {
+		t.Errorf("unexpected flash node: key %q, %d children", node.Key, len(node.Children))
+	}
+}
+
 func TestParser_Isset(t *testing.T) {
 	ast := parseTemplate(t, "@isset($var)Variable is set@endisset")
 
@@ -507,3 +622,288 @@ func TestParser_ComplexTemplate(t *testing.T) {
 		t.Errorf("expected 1 push, got %d", pushCount)
 	}
 }
+
+func TestParser_CustomBlockDirective(t *testing.T) {
+	lex := lexer.New("@feature('beta')content@endfeature")
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+
+	p := New(tokens)
+	p.RegisterBlockDirective("feature")
+	ast, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	if len(ast.Children) != 1 {
+		t.Fatalf("expected 1 child, got %d", len(ast.Children))
+	}
+
+	node, ok := ast.Children[0].(*BlockNode)
+	if !ok {
+		t.Fatalf("expected BlockNode, got %T", ast.Children[0])
+	}
+
+	if node.Name != "feature" || node.Args != "'beta'" {
+		t.Errorf("unexpected block %q(%q)", node.Name, node.Args)
+	}
+
+	if len(node.Children) != 1 {
+		t.Errorf("expected 1 child in block, got %d", len(node.Children))
+	}
+}
+
+func TestParser_StrictUnknownDirective(t *testing.T) {
+	lex := lexer.New("line one\n@wibble('x')")
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+
+	p := New(tokens)
+	p.SetStrict(true)
+	_, err = p.Parse()
+	if err == nil {
+		t.Fatal("expected error for unknown directive in strict mode")
+	}
+
+	perr, ok := err.(*ParserError)
+	if !ok {
+		t.Fatalf("expected ParserError, got %T", err)
+	}
+	if !strings.Contains(perr.Message, "@wibble") || perr.Position.Line != 2 {
+		t.Errorf("unexpected error: %v", perr)
+	}
+}
+
+func TestParser_StrictRegisteredDirective(t *testing.T) {
+	lex := lexer.New("@wibble('x')@if($a)a@endif")
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+
+	p := New(tokens)
+	p.SetStrict(true)
+	p.RegisterDirective("wibble")
+	if _, err := p.Parse(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestParser_UnclosedBlock(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		line     int
+	}{
+		{"@if($x)content", "@endif", 1},
+		{"before\n@foreach($items as $item){{ $item }}", "@endforeach", 2},
+		{"@if($a)@else@unless($b)x@endunless", "@endif", 1},
+	}
+
+	for _, tt := range tests {
+		lex := lexer.New(tt.input)
+		tokens, err := lex.Tokenize()
+		if err != nil {
+			t.Fatalf("lexer error: %v", err)
+		}
+
+		_, err = New(tokens).Parse()
+		perr, ok := err.(*ParserError)
+		if !ok {
+			t.Fatalf("%q: expected ParserError, got %T (%v)", tt.input, err, err)
+		}
+		if !strings.Contains(perr.Message, tt.expected) || perr.Position.Line != tt.line {
+			t.Errorf("%q: unexpected error: %v", tt.input, perr)
+		}
+	}
+}
+
+func TestParser_MismatchedEnd(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		line     int
+	}{
+		{"@if($x)content@endforeach", "unexpected @endforeach, expected @endif to close @if opened at line 1, column 1", 1},
+		{"@foreach($items as $item)\n  @if($item)\n    x\n  @endforeach\n@endforeach", "unexpected @endforeach, expected @endif to close @if opened at line 2, column 3", 4},
+		{"@if($a)@else@while($b)x@endif@endif", "unexpected @endif, expected @endwhile to close @while", 1},
+		{"text@endif", "unexpected @endif without an open block", 1},
+	}
+
+	for _, tt := range tests {
+		lex := lexer.New(tt.input)
+		tokens, err := lex.Tokenize()
+		if err != nil {
+			t.Fatalf("lexer error: %v", err)
+		}
+
+		_, err = New(tokens).Parse()
+		perr, ok := err.(*ParserError)
+		if !ok {
+			t.Fatalf("%q: expected ParserError, got %T (%v)", tt.input, err, err)
+		}
+		if !strings.Contains(perr.Message, tt.expected) || perr.Position.Line != tt.line {
+			t.Errorf("%q: unexpected error: %v", tt.input, perr)
+		}
+	}
+}
+
+func TestParser_Aware(t *testing.T) {
+	lex := lexer.New(`@aware(['color', 'size' => 'md'])`)
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+
+	ast, err := New(tokens).Parse()
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	aware, ok := ast.Children[0].(*AwareNode)
+	if !ok {
+		t.Fatalf("expected AwareNode, got %T", ast.Children[0])
+	}
+	if len(aware.Props) != 2 || aware.Props[0].Name != "color" || aware.Props[1].Name != "size" || aware.Props[1].Default != "'md'" {
+		t.Errorf("unexpected props: %+v", aware.Props)
+	}
+}
+
+func lintTemplate(t *testing.T, input string) []LintIssue {
+	lex := lexer.New(input)
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+	return New(tokens).Lint(nil)
+}
+
+func TestLint_MissingEndif(t *testing.T) {
+	issues := lintTemplate(t, "<p>\n@if($x)\nshown\n</p>")
+
+	if len(issues) != 1 {
+		t.Fatalf("expected 1 issue, got %v", issues)
+	}
+	if !strings.Contains(issues[0].Message, "unclosed @if") || issues[0].Position.Line != 2 {
+		t.Errorf("unexpected issue: %v", issues[0])
+	}
+}
+
+func TestLint_StrayElse(t *testing.T) {
+	issues := lintTemplate(t, "@foreach($items as $item)\n@else\n@endforeach")
+
+	if len(issues) != 1 {
+		t.Fatalf("expected 1 issue, got %v", issues)
+	}
+	if !strings.Contains(issues[0].Message, "@else outside of @if") || issues[0].Position.Line != 2 {
+		t.Errorf("unexpected issue: %v", issues[0])
+	}
+}
+
+func TestLint_MismatchedAndUnknown(t *testing.T) {
+	issues := lintTemplate(t, "@if($x)@endforeach@endif@frobnicate")
+
+	if len(issues) != 2 {
+		t.Fatalf("expected 2 issues, got %v", issues)
+	}
+	if !strings.Contains(issues[0].Message, "unexpected @endforeach") {
+		t.Errorf("unexpected issue: %v", issues[0])
+	}
+	if !strings.Contains(issues[1].Message, "unknown directive @frobnicate") {
+		t.Errorf("unexpected issue: %v", issues[1])
+	}
+}
+
+func TestLint_WellFormed(t *testing.T) {
+	input := `@forelse($items as $item)@if($a)x@elseif($b)y@else z@endif@empty none@endforelse` +
+		`@section("title", "Home")@php($n = 1)@component("alert")@switch($n)@case(1)one@break@default d@endswitch`
+	if issues := lintTemplate(t, input); len(issues) != 0 {
+		t.Errorf("expected no issues, got %v", issues)
+	}
+}
+
+func TestParser_ParseCollect(t *testing.T) {
+	lex := lexer.New("@foreach($items as $item)\n  {{ $item }}\n@endif\n@section('a')\n@if($x)\n  x\n")
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+
+	ast, errs := New(tokens).ParseCollect()
+	if ast == nil {
+		t.Fatal("expected an AST alongside the errors")
+	}
+	if len(errs) != 4 {
+		t.Fatalf("expected 4 errors, got %v", errs)
+	}
+
+	expected := []struct {
+		message string
+		line    int
+	}{
+		{"unclosed @foreach", 1},
+		{"unexpected @endif", 3},
+		{"unclosed @section", 4},
+		{"unclosed @if", 5},
+	}
+	for i, want := range expected {
+		perr, ok := errs[i].(*ParserError)
+		if !ok {
+			t.Fatalf("expected ParserError, got %T", errs[i])
+		}
+		if !strings.Contains(perr.Message, want.message) || perr.Position.Line != want.line {
+			t.Errorf("error %d: expected %q on line %d, got %v", i, want.message, want.line, perr)
+		}
+	}
+
+	// Well-formed templates report nothing
+	lex = lexer.New("@if($x)a@endif")
+	tokens, _ = lex.Tokenize()
+	if _, errs := New(tokens).ParseCollect(); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
+
+func TestParser_StrictBuiltinDirectives(t *testing.T) {
+	input := `@extends('layouts.app')
+@section('content')@parent @yield('title', 'x')
+@include('a') @includeIf('a') @includeWhen($a, 'a') @includeUnless($a, 'a') @includeFirst(['a', 'b']) @each('a', $items, 'item')
+@if($a) @elseif($b) @else @endif @unless($a) @endunless @isset($a) @endisset @empty($a) @endempty
+@switch($a) @case(1) @break @default @endswitch
+@for($i = 0; $i < 3; $i++) @endfor @foreach($items as $item) @continue @endforeach @forelse($items as $item) @empty @endforelse @while($a) @endwhile
+@auth @endauth @guest @endguest @allow('edit', $post) @endallow @feature('beta') @featureelse @endfeature @env('local') @endenv @production @endproduction
+@push('scripts') @endpush @prepend('scripts') @endprepend @pushOnce('scripts') @endPushOnce @pushIf($a, 'scripts') @endPushIf @stack('scripts')
+@component('card') @slot('title') @endslot @endcomponent @aware(['color']) @props(['type' => 'info'])
+@csrf @method('PUT') @error('name') @enderror @flash('status') @endflash @old('name') @status(404) @header('X-Frame-Options', 'DENY')
+@class(['a' => $a]) @style(['color: red' => $a]) @checked($a) @selected($a) @disabled($a) @readonly($a) @required($a)
+@json($a) @dump($a) @verbatim {{ x }} @endverbatim @php($x = 1) @php $y = 2; @endphp
+@once @endonce @spaceless @endspaceless @cache('k') @endcache
+@endsection`
+
+	lex := lexer.New(input)
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+
+	p := New(tokens)
+	p.SetStrict(true)
+	if _, err := p.Parse(); err != nil {
+		t.Fatalf("unexpected error in strict mode: %v", err)
+	}
+	for _, issue := range New(tokens).Lint(nil) {
+		t.Errorf("unexpected lint issue: %v", issue)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	got := SplitArgs("'a, b', \"c\\\", d\", `e, f`, fn(1, 2), ['x' => [1, 2]], {g, h}")
+	expected := []string{"'a, b'", "\"c\\\", d\"", "`e, f`", "fn(1, 2)", "['x' => [1, 2]]", "{g, h}"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
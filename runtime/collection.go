@@ -0,0 +1,151 @@
+package runtime
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Collection wraps a list of items with chainable helpers modelled on
+// Laravel collections. Every method returns a new collection or a value;
+// the wrapped items are never modified.
+//
+//	{{ ((collect .users).Where "active" true).Pluck "name" }}
+type Collection struct {
+	items []interface{}
+}
+
+// Group is a group of items sharing a key, produced by groupByOrdered
+type Group struct {
+	Key   string
+	Items []interface{}
+}
+
+// Collect wraps a slice or array, the values of a map in key order, or a
+// single value. nil gives an empty collection.
+func Collect(v interface{}) *Collection {
+	if c, ok := v.(*Collection); ok {
+		return c
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return &Collection{}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		pairs := Iterate(v)
+		items := make([]interface{}, len(pairs))
+		for i, pair := range pairs {
+			items[i] = pair.Value
+		}
+		return &Collection{items: items}
+	}
+	return &Collection{items: []interface{}{v}}
+}
+
+// All returns the items
+func (c *Collection) All() []interface{} {
+	return c.items
+}
+
+// Count returns the number of items
+func (c *Collection) Count() int {
+	return len(c.items)
+}
+
+// IsEmpty checks if the collection has no items
+func (c *Collection) IsEmpty() bool {
+	return len(c.items) == 0
+}
+
+// First returns the first item, or nil
+func (c *Collection) First() interface{} {
+	if len(c.items) == 0 {
+		return nil
+	}
+	return c.items[0]
+}
+
+// Last returns the last item, or nil
+func (c *Collection) Last() interface{} {
+	if len(c.items) == 0 {
+		return nil
+	}
+	return c.items[len(c.items)-1]
+}
+
+// Where keeps the items whose key matches: Where(key, value) compares for
+// equality and Where(key, op, value) uses an operator as in Matcher.
+// An unknown operator matches nothing.
+func (c *Collection) Where(key string, args ...interface{}) *Collection {
+	op, value := "=", interface{}(nil)
+	switch len(args) {
+	case 1:
+		value = args[0]
+	case 2:
+		op, _ = args[0].(string)
+		value = args[1]
+	default:
+		return &Collection{}
+	}
+
+	match, err := Matcher(op, value)
+	if err != nil {
+		return &Collection{}
+	}
+	return c.Filter(func(item interface{}) bool {
+		return match(Field(item, key))
+	})
+}
+
+// Filter keeps the items fn accepts, or the truthy items without fn
+func (c *Collection) Filter(fn ...func(interface{}) bool) *Collection {
+	keep := Truthy
+	if len(fn) > 0 && fn[0] != nil {
+		keep = fn[0]
+	}
+
+	items := make([]interface{}, 0, len(c.items))
+	for _, item := range c.items {
+		if keep(item) {
+			items = append(items, item)
+		}
+	}
+	return &Collection{items: items}
+}
+
+// Map replaces each item with the result of fn
+func (c *Collection) Map(fn func(interface{}) interface{}) *Collection {
+	items := make([]interface{}, len(c.items))
+	for i, item := range c.items {
+		items[i] = fn(item)
+	}
+	return &Collection{items: items}
+}
+
+// Pluck replaces each item with its key, skipping items without it
+func (c *Collection) Pluck(key string) *Collection {
+	items := make([]interface{}, 0, len(c.items))
+	for _, item := range c.items {
+		if value := Field(item, key); value != nil {
+			items = append(items, value)
+		}
+	}
+	return &Collection{items: items}
+}
+
+// SortBy sorts the items by a key, ascending unless order is "desc"
+func (c *Collection) SortBy(key string, order ...string) *Collection {
+	items := make([]interface{}, len(c.items))
+	copy(items, c.items)
+
+	desc := len(order) > 0 && strings.EqualFold(order[0], "desc")
+	sort.SliceStable(items, func(i, j int) bool {
+		cmp := Compare(Field(items[i], key), Field(items[j], key))
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	return &Collection{items: items}
+}
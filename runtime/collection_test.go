@@ -0,0 +1,51 @@
+package runtime
+
+import (
+	"fmt"
+	"testing"
+)
+
+type member struct {
+	Name  string
+	Age   int
+	Admin bool
+}
+
+func TestCollection_Chaining(t *testing.T) {
+	members := []member{{"Ada", 36, true}, {"Bob", 17, false}, {"Cy", 52, true}}
+
+	names := Collect(members).Where("Age", ">=", 18).Pluck("Name")
+	if names.Count() != 2 || fmt.Sprint(names.All()) != "[Ada Cy]" {
+		t.Errorf("unexpected names: %v", names.All())
+	}
+
+	admins := Collect(members).Filter(func(m interface{}) bool { return m.(member).Admin })
+	if admins.Count() != 2 || admins.Last().(member).Name != "Cy" {
+		t.Errorf("unexpected admins: %v", admins.All())
+	}
+
+	oldest := Collect(members).SortBy("Age", "desc").First().(member)
+	if oldest.Name != "Cy" {
+		t.Errorf("expected Cy to be oldest, got %s", oldest.Name)
+	}
+
+	ages := Collect(members).Map(func(m interface{}) interface{} { return m.(member).Age * 2 })
+	if fmt.Sprint(ages.All()) != "[72 34 104]" {
+		t.Errorf("unexpected ages: %v", ages.All())
+	}
+}
+
+func TestCollection_Sources(t *testing.T) {
+	if c := Collect(nil); !c.IsEmpty() || c.First() != nil {
+		t.Error("expected empty collection")
+	}
+	if c := Collect(map[string]int{"b": 2, "a": 1}); fmt.Sprint(c.All()) != "[1 2]" {
+		t.Errorf("expected map values in key order, got %v", c.All())
+	}
+	if c := Collect([]interface{}{0, "", "x", nil, 3}).Filter(); fmt.Sprint(c.All()) != "[x 3]" {
+		t.Errorf("expected truthy items, got %v", c.All())
+	}
+	if c := Collect([]int{1}).Where("x", "~", 1); !c.IsEmpty() {
+		t.Error("expected unknown operator to match nothing")
+	}
+}
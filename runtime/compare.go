@@ -0,0 +1,162 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Equal compares two values. Numbers compare by value across types, so an
+// int64 field matches an int literal and arithmetic results (float64).
+func Equal(a, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		return numberValue(a) == numberValue(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Compare orders numbers numerically, strings lexically and other values
+// by their printed form. It returns -1, 0 or 1.
+func Compare(a, b interface{}) int {
+	if isNumber(a) && isNumber(b) {
+		x, y := numberValue(a), numberValue(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// Truthy reports whether a value counts as true in a template condition:
+// false, zero, "", "0", "false", nil and empty collections are false
+func Truthy(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	switch b := v.(type) {
+	case bool:
+		return b
+	case int, int8, int16, int32, int64:
+		return reflect.ValueOf(b).Int() != 0
+	case uint, uint8, uint16, uint32, uint64:
+		return reflect.ValueOf(b).Uint() != 0
+	case float32, float64:
+		return reflect.ValueOf(b).Float() != 0
+	case string:
+		return b != "" && b != "0" && b != "false"
+	default:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return rv.Len() > 0
+		case reflect.Ptr, reflect.Interface:
+			return !rv.IsNil()
+		}
+		return true
+	}
+}
+
+// Field returns a map entry or exported struct field of item, following
+// pointers, or nil if there is none
+func Field(item interface{}, key string) interface{} {
+	rv := reflect.ValueOf(item)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		if val := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())); val.IsValid() {
+			return val.Interface()
+		}
+	case reflect.Struct:
+		if field := rv.FieldByName(key); field.IsValid() && field.CanInterface() {
+			return field.Interface()
+		}
+	}
+	return nil
+}
+
+// Matcher returns a predicate comparing a value against value with one of
+// =, !=, >, >=, <, <=, in (value is a list) or contains (the compared
+// value is a string or list)
+func Matcher(op string, value interface{}) (func(interface{}) bool, error) {
+	switch op {
+	case "=", "==":
+		return func(item interface{}) bool { return Equal(item, value) }, nil
+	case "!=", "<>":
+		return func(item interface{}) bool { return !Equal(item, value) }, nil
+	case ">":
+		return func(item interface{}) bool { return item != nil && Compare(item, value) > 0 }, nil
+	case ">=":
+		return func(item interface{}) bool { return item != nil && Compare(item, value) >= 0 }, nil
+	case "<":
+		return func(item interface{}) bool { return item != nil && Compare(item, value) < 0 }, nil
+	case "<=":
+		return func(item interface{}) bool { return item != nil && Compare(item, value) <= 0 }, nil
+	case "in":
+		return func(item interface{}) bool { return inList(value, item) }, nil
+	case "contains":
+		return func(item interface{}) bool {
+			if str, ok := item.(string); ok {
+				return strings.Contains(str, fmt.Sprint(value))
+			}
+			return inList(item, value)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+// inList checks if list is a slice or array holding value
+func inList(list, value interface{}) bool {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return false
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if Equal(rv.Index(i).Interface(), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNumber checks if v holds an integer or floating point value
+func isNumber(v interface{}) bool {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// numberValue converts any numeric kind, including named types, to float64
+func numberValue(v interface{}) float64 {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return 0
+}
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"fmt"
 	"sync"
 )
 
@@ -10,7 +11,8 @@ type Context struct {
 	stacks   map[string][]string
 	sections map[string]string
 	errors   map[string][]string
-	old      map[string]string
+	old      map[string]interface{}
+	flash    map[string]string
 	mu       sync.RWMutex
 }
 
@@ -21,7 +23,8 @@ func NewContext() *Context {
 		stacks:   make(map[string][]string),
 		sections: make(map[string]string),
 		errors:   make(map[string][]string),
-		old:      make(map[string]string),
+		old:      make(map[string]interface{}),
+		flash:    make(map[string]string),
 	}
 }
 
@@ -152,20 +155,77 @@ func (c *Context) GetError(field string) string {
 
 // Old input
 
-// SetOld sets old input values
-func (c *Context) SetOld(old map[string]string) {
+// SetOld sets old input values. Values may be strings or, for inputs such
+// as checkboxes and multi-selects, slices.
+func (c *Context) SetOld(old map[string]interface{}) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.old = old
 }
 
-// GetOld returns old input for a field
+// GetOld returns old input for a field as a string, or "" when it is
+// missing. Use GetOldValue for inputs holding several values.
 func (c *Context) GetOld(field string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	switch v := c.old[field].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// GetOldValue returns old input for a field as stored, or nil
+func (c *Context) GetOldValue(field string) interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.old[field]
 }
 
+// OldInput returns a copy of all old input values
+func (c *Context) OldInput() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make(map[string]interface{}, len(c.old))
+	for k, v := range c.old {
+		result[k] = v
+	}
+	return result
+}
+
+// Flash messages
+
+// Flash sets a one-time message, such as a status message carried over
+// from the previous request, shown by @flash
+func (c *Context) Flash(key, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flash[key] = message
+}
+
+// GetFlash returns the flash message for a key, or ""
+func (c *Context) GetFlash(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.flash[key]
+}
+
+// FlashMessages returns a copy of all flash messages
+func (c *Context) FlashMessages() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make(map[string]string, len(c.flash))
+	for k, v := range c.flash {
+		result[k] = v
+	}
+	return result
+}
+
 // Clone creates a copy of the context
 func (c *Context) Clone() *Context {
 	c.mu.RLock()
@@ -188,6 +248,9 @@ func (c *Context) Clone() *Context {
 	for k, v := range c.old {
 		newCtx.old[k] = v
 	}
+	for k, v := range c.flash {
+		newCtx.flash[k] = v
+	}
 
 	return newCtx
 }
@@ -219,6 +282,11 @@ func (s *SharedData) Get(key string) interface{} {
 	return s.data[key]
 }
 
+// Clone creates an independent copy of the shared data
+func (s *SharedData) Clone() *SharedData {
+	return &SharedData{data: s.All()}
+}
+
 // All returns all shared data
 func (s *SharedData) All() map[string]interface{} {
 	s.mu.RLock()
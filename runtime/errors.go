@@ -0,0 +1,155 @@
+package runtime
+
+import (
+	"sort"
+	"strings"
+)
+
+// ErrorBag holds validation error messages keyed by field name.
+// It is exposed to templates as $errors.
+type ErrorBag struct {
+	messages map[string][]string
+}
+
+// NewErrorBag creates an error bag from field messages
+func NewErrorBag(messages map[string][]string) *ErrorBag {
+	bag := &ErrorBag{
+		messages: make(map[string][]string, len(messages)),
+	}
+	for field, msgs := range messages {
+		if len(msgs) > 0 {
+			bag.messages[field] = append([]string(nil), msgs...)
+		}
+	}
+	return bag
+}
+
+// Any checks if the bag contains any messages
+func (b *ErrorBag) Any() bool {
+	return b.Count() > 0
+}
+
+// Count returns the total number of messages
+func (b *ErrorBag) Count() int {
+	if b == nil {
+		return 0
+	}
+	count := 0
+	for _, msgs := range b.messages {
+		count += len(msgs)
+	}
+	return count
+}
+
+// Has checks if any of the given fields has messages
+func (b *ErrorBag) Has(fields ...string) bool {
+	if b == nil {
+		return false
+	}
+	for _, field := range fields {
+		if len(b.Get(field)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// First returns the first message for a field,
+// or the first message in the bag when no field is given
+func (b *ErrorBag) First(field ...string) string {
+	if b == nil {
+		return ""
+	}
+	if len(field) > 0 {
+		if msgs := b.Get(field[0]); len(msgs) > 0 {
+			return msgs[0]
+		}
+		return ""
+	}
+	if all := b.All(); len(all) > 0 {
+		return all[0]
+	}
+	return ""
+}
+
+// Get returns all messages for a field. A field with a * wildcard, such
+// as "attachments.*", returns the messages of every matching field in
+// field name order.
+func (b *ErrorBag) Get(field string) []string {
+	if b == nil {
+		return nil
+	}
+	if !strings.Contains(field, "*") {
+		return b.messages[field]
+	}
+
+	var result []string
+	for _, key := range b.Keys() {
+		if FieldMatches(field, key) {
+			result = append(result, b.messages[key]...)
+		}
+	}
+	return result
+}
+
+// All returns every message in the bag, ordered by field name
+func (b *ErrorBag) All() []string {
+	if b == nil {
+		return nil
+	}
+	var result []string
+	for _, field := range b.Keys() {
+		result = append(result, b.messages[field]...)
+	}
+	return result
+}
+
+// Keys returns the fields that have messages, sorted by name
+func (b *ErrorBag) Keys() []string {
+	if b == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(b.messages))
+	for field := range b.messages {
+		keys = append(keys, field)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Messages returns a copy of the messages keyed by field
+func (b *ErrorBag) Messages() map[string][]string {
+	result := make(map[string][]string)
+	if b == nil {
+		return result
+	}
+	for field, msgs := range b.messages {
+		result[field] = append([]string(nil), msgs...)
+	}
+	return result
+}
+
+// FieldMatches reports whether a field name matches a pattern in which *
+// matches any sequence of characters, so "attachments.*" matches
+// "attachments.0" and "attachments.1.name"
+func FieldMatches(pattern, field string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == field
+	}
+
+	if !strings.HasPrefix(field, parts[0]) {
+		return false
+	}
+	field = field[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(field, part)
+		if i == -1 {
+			return false
+		}
+		field = field[i+len(part):]
+	}
+	return len(field) >= len(last) && strings.HasSuffix(field, last)
+}
@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestErrorBag(t *testing.T) {
+	bag := NewErrorBag(map[string][]string{
+		"email":    {"Email is required", "Email is invalid"},
+		"password": {"Password is too short"},
+		"name":     {},
+	})
+
+	if !bag.Any() {
+		t.Error("expected Any to be true")
+	}
+
+	if bag.Count() != 3 {
+		t.Errorf("expected 3 messages, got %d", bag.Count())
+	}
+
+	if !bag.Has("email") || bag.Has("name") || !bag.Has("name", "password") {
+		t.Error("unexpected Has result")
+	}
+
+	if bag.First("email") != "Email is required" {
+		t.Errorf("expected 'Email is required', got %q", bag.First("email"))
+	}
+
+	if bag.First("missing") != "" {
+		t.Errorf("expected empty first for missing field, got %q", bag.First("missing"))
+	}
+
+	if bag.First() != "Email is required" {
+		t.Errorf("expected first overall message, got %q", bag.First())
+	}
+
+	all := bag.All()
+	expected := []string{"Email is required", "Email is invalid", "Password is too short"}
+	if len(all) != len(expected) {
+		t.Fatalf("expected %d messages, got %v", len(expected), all)
+	}
+	for i := range expected {
+		if all[i] != expected[i] {
+			t.Errorf("expected %q at %d, got %q", expected[i], i, all[i])
+		}
+	}
+}
+
+func TestErrorBag_Empty(t *testing.T) {
+	var nilBag *ErrorBag
+	for _, bag := range []*ErrorBag{NewErrorBag(nil), nilBag} {
+		if bag.Any() || bag.Count() != 0 || bag.Has("email") || bag.First() != "" || len(bag.All()) != 0 {
+			t.Errorf("expected empty bag, got %v", bag.All())
+		}
+	}
+}
+
+func TestErrorBag_Wildcard(t *testing.T) {
+	bag := NewErrorBag(map[string][]string{
+		"attachments.1": {"Second file is too large"},
+		"attachments.0": {"First file is not a PDF"},
+		"attachment":    {"Unrelated"},
+	})
+
+	if !bag.Has("attachments.*") || bag.Has("images.*") {
+		t.Error("unexpected Has result for wildcard")
+	}
+	if got := bag.First("attachments.*"); got != "First file is not a PDF" {
+		t.Errorf("expected first matching message, got %q", got)
+	}
+	if got := bag.Get("attachments.*"); len(got) != 2 {
+		t.Errorf("expected 2 messages, got %v", got)
+	}
+}
+
+func TestFieldMatches(t *testing.T) {
+	tests := []struct {
+		pattern, field string
+		expected       bool
+	}{
+		{"attachments.*", "attachments.0", true},
+		{"attachments.*", "attachments.1.name", true},
+		{"attachments.*", "attachments", false},
+		{"items.*.price", "items.3.price", true},
+		{"items.*.price", "items.3.qty", false},
+		{"*", "anything", true},
+		{"email", "email", true},
+		{"a*a", "a", false},
+	}
+
+	for _, tt := range tests {
+		if got := FieldMatches(tt.pattern, tt.field); got != tt.expected {
+			t.Errorf("FieldMatches(%q, %q): expected %v", tt.pattern, tt.field, tt.expected)
+		}
+	}
+}
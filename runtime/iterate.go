@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Pair is a key/value pair produced by Iterate
+type Pair struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// Iterate returns the key/value pairs of a slice, array, map or struct in a
+// deterministic order: slices by index, maps by sorted key and structs by
+// exported field in declaration order. Pointers are followed; other values
+// yield no pairs.
+func Iterate(v interface{}) []Pair {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		pairs := make([]Pair, rv.Len())
+		for i := range pairs {
+			pairs[i] = Pair{Key: i, Value: rv.Index(i).Interface()}
+		}
+		return pairs
+
+	case reflect.Map:
+		keys := sortedKeys(rv)
+		pairs := make([]Pair, len(keys))
+		for i, key := range keys {
+			pairs[i] = Pair{Key: key.Interface(), Value: rv.MapIndex(key).Interface()}
+		}
+		return pairs
+
+	case reflect.Struct:
+		t := rv.Type()
+		pairs := make([]Pair, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			if field := t.Field(i); field.IsExported() {
+				pairs = append(pairs, Pair{Key: field.Name, Value: rv.Field(i).Interface()})
+			}
+		}
+		return pairs
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of a map, sorted numerically when they are
+// all numbers and alphabetically otherwise. Keys of interface maps are
+// compared by their dynamic value.
+func sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	numbers := make([]float64, len(keys))
+	numeric := true
+	for i, key := range keys {
+		if numbers[i], numeric = keyNumber(key); !numeric {
+			break
+		}
+	}
+
+	if numeric {
+		sort.Sort(byNumber{keys, numbers})
+		return keys
+	}
+
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = fmt.Sprint(key.Interface())
+	}
+	sort.Sort(byName{keys, names})
+	return keys
+}
+
+// keyNumber returns a numeric map key as a float64
+func keyNumber(v reflect.Value) (float64, bool) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// byNumber sorts map keys by their numeric value
+type byNumber struct {
+	keys    []reflect.Value
+	numbers []float64
+}
+
+func (s byNumber) Len() int           { return len(s.keys) }
+func (s byNumber) Less(i, j int) bool { return s.numbers[i] < s.numbers[j] }
+func (s byNumber) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.numbers[i], s.numbers[j] = s.numbers[j], s.numbers[i]
+}
+
+// byName sorts map keys by their string form
+type byName struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (s byName) Len() int           { return len(s.keys) }
+func (s byName) Less(i, j int) bool { return s.names[i] < s.names[j] }
+func (s byName) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.names[i], s.names[j] = s.names[j], s.names[i]
+}
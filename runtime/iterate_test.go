@@ -0,0 +1,40 @@
+package runtime
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIterate_MapSortedKeys(t *testing.T) {
+	pairs := Iterate(map[string]int{"c": 3, "a": 1, "b": 2})
+	if got := fmt.Sprint(pairs); got != "[{a 1} {b 2} {c 3}]" {
+		t.Errorf("unexpected pairs: %s", got)
+	}
+
+	pairs = Iterate(map[int]string{10: "x", 2: "y"})
+	if got := fmt.Sprint(pairs); got != "[{2 y} {10 x}]" {
+		t.Errorf("expected numeric key order, got %s", got)
+	}
+}
+
+func TestIterate_StructFields(t *testing.T) {
+	type user struct {
+		Name   string
+		Age    int
+		secret string
+	}
+
+	pairs := Iterate(&user{Name: "Ada", Age: 36, secret: "x"})
+	if got := fmt.Sprint(pairs); got != "[{Name Ada} {Age 36}]" {
+		t.Errorf("unexpected pairs: %s", got)
+	}
+}
+
+func TestIterate_SliceAndNil(t *testing.T) {
+	if got := fmt.Sprint(Iterate([]string{"a", "b"})); got != "[{0 a} {1 b}]" {
+		t.Errorf("unexpected pairs: %s", got)
+	}
+	if Iterate(nil) != nil || Iterate((*int)(nil)) != nil || Iterate(42) != nil {
+		t.Error("expected no pairs")
+	}
+}
@@ -44,6 +44,14 @@ func NewLoop(count, depth int) *Loop {
 	}
 }
 
+// Child creates the Loop of a loop nested in the current iteration, one
+// level deeper and with l as its parent
+func (l *Loop) Child(count int) *Loop {
+	child := NewLoop(count, l.Depth+1)
+	child.Parent = l
+	return child
+}
+
 // Update updates the loop for the next iteration
 func (l *Loop) Update(index int) *Loop {
 	newLoop := &Loop{
@@ -0,0 +1,41 @@
+package runtime
+
+import "testing"
+
+func TestLoop_UpdateKeepsParentAndDepth(t *testing.T) {
+	stack := NewLoopStack()
+	outer := NewLoop(2, 0)
+	stack.Push(outer)
+	inner := NewLoop(3, 0)
+	stack.Push(inner)
+
+	if inner.Parent != outer || inner.Depth != 2 {
+		t.Fatalf("expected pushed loop at depth 2 with outer parent, got depth %d", inner.Depth)
+	}
+
+	for i := 0; i < 3; i++ {
+		iter := inner.Update(i)
+		if iter.Parent != outer || iter.Depth != 2 {
+			t.Errorf("iteration %d: expected parent and depth to be kept, got depth %d", i, iter.Depth)
+		}
+		if iter.Last != (i == 2) || iter.Remaining != 2-i {
+			t.Errorf("iteration %d: unexpected Last %v, Remaining %d", i, iter.Last, iter.Remaining)
+		}
+	}
+
+	if stack.Pop() != inner || stack.Current() != outer || stack.Depth() != 1 {
+		t.Error("expected Pop to return to the outer loop")
+	}
+}
+
+func TestLoop_Child(t *testing.T) {
+	outer := NewLoop(2, 1).Update(1)
+	child := outer.Child(4).Update(0)
+
+	if child.Parent != outer || child.Depth != 2 || child.Count != 4 {
+		t.Errorf("unexpected child loop %+v", child)
+	}
+	if child.Parent.Index != 1 {
+		t.Errorf("expected parent index 1, got %d", child.Parent.Index)
+	}
+}
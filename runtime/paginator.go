@@ -0,0 +1,87 @@
+package runtime
+
+import "reflect"
+
+// Paginator holds one page of a slice together with the page metadata
+type Paginator struct {
+	Items       interface{} // Items on the current page, same slice type as the input
+	Total       int         // Total number of items
+	PerPage     int         // Items per page
+	CurrentPage int         // Current page (1-based)
+	LastPage    int         // Last page number (at least 1)
+	From        int         // Position of the first item on the page (1-based, 0 if none)
+	To          int         // Position of the last item on the page (0 if none)
+	HasMore     bool        // Are there pages after the current one?
+}
+
+// NewPaginator slices items to the given page. Pages before the first are
+// treated as the first page; pages past the last one are empty.
+func NewPaginator(items interface{}, perPage, currentPage int) *Paginator {
+	if perPage < 1 {
+		perPage = 1
+	}
+	if currentPage < 1 {
+		currentPage = 1
+	}
+
+	p := &Paginator{
+		PerPage:     perPage,
+		CurrentPage: currentPage,
+		LastPage:    1,
+	}
+
+	rv := reflect.ValueOf(items)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		p.Items = []interface{}{}
+		return p
+	}
+
+	p.Total = rv.Len()
+	if p.Total > 0 {
+		p.LastPage = (p.Total + perPage - 1) / perPage
+	}
+	p.HasMore = currentPage < p.LastPage
+
+	start := (currentPage - 1) * perPage
+	if start > p.Total {
+		start = p.Total
+	}
+	end := start + perPage
+	if end > p.Total {
+		end = p.Total
+	}
+
+	page := reflect.MakeSlice(reflect.SliceOf(rv.Type().Elem()), end-start, end-start)
+	for i := start; i < end; i++ {
+		page.Index(i - start).Set(rv.Index(i))
+	}
+	p.Items = page.Interface()
+
+	if end > start {
+		p.From = start + 1
+		p.To = end
+	}
+
+	return p
+}
+
+// OnFirstPage checks if the current page is the first one
+func (p *Paginator) OnFirstPage() bool {
+	return p.CurrentPage <= 1
+}
+
+// PreviousPage returns the previous page number, or 0 on the first page
+func (p *Paginator) PreviousPage() int {
+	if p.OnFirstPage() {
+		return 0
+	}
+	return p.CurrentPage - 1
+}
+
+// NextPage returns the next page number, or 0 on the last page
+func (p *Paginator) NextPage() int {
+	if !p.HasMore {
+		return 0
+	}
+	return p.CurrentPage + 1
+}
@@ -0,0 +1,46 @@
+package runtime
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPaginator_Pages(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7}
+
+	tests := []struct {
+		page                   int
+		items                  string
+		from, to               int
+		hasMore                bool
+		previousPage, nextPage int
+	}{
+		{1, "[1 2 3]", 1, 3, true, 0, 2},
+		{2, "[4 5 6]", 4, 6, true, 1, 3},
+		{3, "[7]", 7, 7, false, 2, 0},
+		{4, "[]", 0, 0, false, 3, 0},
+	}
+
+	for _, tt := range tests {
+		p := NewPaginator(items, 3, tt.page)
+		if p.Total != 7 || p.LastPage != 3 || p.CurrentPage != tt.page {
+			t.Errorf("page %d: unexpected metadata %+v", tt.page, p)
+		}
+		if got := fmt.Sprint(p.Items); got != tt.items {
+			t.Errorf("page %d: expected items %s, got %s", tt.page, tt.items, got)
+		}
+		if p.From != tt.from || p.To != tt.to || p.HasMore != tt.hasMore {
+			t.Errorf("page %d: expected from %d to %d hasMore %v, got %+v", tt.page, tt.from, tt.to, tt.hasMore, p)
+		}
+		if p.PreviousPage() != tt.previousPage || p.NextPage() != tt.nextPage {
+			t.Errorf("page %d: unexpected previous/next %d/%d", tt.page, p.PreviousPage(), p.NextPage())
+		}
+	}
+}
+
+func TestPaginator_Empty(t *testing.T) {
+	p := NewPaginator([]string{}, 10, 0)
+	if p.CurrentPage != 1 || p.LastPage != 1 || p.HasMore || p.From != 0 || !p.OnFirstPage() {
+		t.Errorf("unexpected metadata %+v", p)
+	}
+}
@@ -0,0 +1,71 @@
+package runtime
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Response collects the status code and headers a template declares with
+// @status and @header while it renders, together with the rendered body.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   string
+	mu     sync.Mutex
+}
+
+// NewResponse creates an empty response
+func NewResponse() *Response {
+	return &Response{Header: make(http.Header)}
+}
+
+// SetStatus sets the HTTP status code
+func (r *Response) SetStatus(code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Status = code
+}
+
+// SetHeader sets an HTTP header, replacing any previous value
+func (r *Response) SetHeader(name, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Header.Set(name, value)
+}
+
+// Merge copies the status (if set) and headers of other into r
+func (r *Response) Merge(other *Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if other.Status != 0 {
+		r.Status = other.Status
+	}
+	for name, values := range other.Header {
+		r.Header[name] = values
+	}
+}
+
+// Write applies the headers and status to w and writes the body.
+// The status defaults to 200 and the content type to HTML.
+func (r *Response) Write(w http.ResponseWriter) error {
+	for name, values := range r.Header {
+		w.Header()[name] = values
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	if r.Body == "" {
+		// 304 and 204 responses must not have a body
+		return nil
+	}
+
+	_, err := io.WriteString(w, r.Body)
+	return err
+}
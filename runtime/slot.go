@@ -0,0 +1,35 @@
+package runtime
+
+import "html/template"
+
+// Slot holds the content passed to a named component slot together with
+// the slot's attributes. It is a map so component templates can read
+// $title->attributes->class, and prints as its content.
+type Slot map[string]interface{}
+
+// NewSlot creates a slot from its content and attributes
+func NewSlot(content template.HTML, attributes map[string]interface{}) Slot {
+	if attributes == nil {
+		attributes = make(map[string]interface{})
+	}
+	return Slot{
+		"content":    content,
+		"attributes": attributes,
+	}
+}
+
+// String returns the slot content
+func (s Slot) String() string {
+	return string(s.HTML())
+}
+
+// HTML returns the rendered slot content
+func (s Slot) HTML() template.HTML {
+	content, _ := s["content"].(template.HTML)
+	return content
+}
+
+// IsEmpty checks if the slot has no content
+func (s Slot) IsEmpty() bool {
+	return s.String() == ""
+}
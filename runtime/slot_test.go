@@ -0,0 +1,17 @@
+package runtime
+
+import "testing"
+
+func TestSlot_StringAndAttributes(t *testing.T) {
+	slot := NewSlot("Hello", map[string]interface{}{"class": "big"})
+
+	if slot.String() != "Hello" || slot.IsEmpty() {
+		t.Errorf("unexpected content: %q", slot.String())
+	}
+	if attrs := slot["attributes"].(map[string]interface{}); attrs["class"] != "big" {
+		t.Errorf("unexpected attributes: %v", attrs)
+	}
+	if !NewSlot("", nil).IsEmpty() {
+		t.Error("expected empty slot")
+	}
+}